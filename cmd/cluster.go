@@ -44,5 +44,9 @@ func init() {
 		cliClusterLogs(),
 		cliClusterPurge(),
 		cliEnterNano(),
+		cliClusterExec(),
+		cliClusterGC(),
+		cliClusterSupportBundle(),
+		cliClusterTop(),
 	)
 }
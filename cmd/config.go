@@ -0,0 +1,586 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apcera/termtables"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	configSourceBuiltin = "builtin" // configSourceBuiltin is a value coming from setDefaultConfig()
+	configSourceFile    = "file"    // configSourceFile is a value coming from the configuration file
+	configFileName      = "cn.toml" // configFileName is the name of the configuration file cn searches for
+)
+
+var (
+	cmdConfig = &cobra.Command{
+		Use:   "config [command]",
+		Short: "View and edit cn's configuration",
+		Args:  cobra.NoArgs,
+	}
+
+	// configFlag is the configuration file passed with --config
+	configFlag string
+)
+
+func init() {
+	cmdConfig.AddCommand(
+		cliConfigView(),
+		cliConfigGet(),
+		cliConfigSet(),
+		cliConfigUnset(),
+		cliConfigPath(),
+		cliConfigEdit(),
+		cliConfigValidate(),
+	)
+}
+
+// cliConfigView is the Cobra CLI call
+func cliConfigView() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "view [PREFIX]",
+		Short: "Print the configuration in use and where each value comes from",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   viewConfig,
+		Example: "cn config view\n" +
+			"cn config view flavors.huge\n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+// cliConfigGet is the Cobra CLI call
+func cliConfigGet() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "get KEY",
+		Short:                 "Print the value of a configuration key",
+		Args:                  cobra.ExactArgs(1),
+		Run:                   getConfig,
+		Example:               "cn config get flavors.default.memory_size\n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+// cliConfigSet is the Cobra CLI call
+func cliConfigSet() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set KEY VALUE",
+		Short: "Set a configuration key in the configuration file",
+		Args:  cobra.ExactArgs(2),
+		Run:   setConfig,
+		Example: "cn config set flavors.default.memory_size 1GB\n" +
+			"cn config set images.nautilus.image_name ceph/daemon:latest-nautilus\n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+// cliConfigUnset is the Cobra CLI call
+func cliConfigUnset() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "unset KEY",
+		Short:                 "Remove a configuration key from the configuration file",
+		Args:                  cobra.ExactArgs(1),
+		Run:                   unsetConfig,
+		Example:               "cn config unset flavors.default.memory_size\n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+// cliConfigPath is the Cobra CLI call
+func cliConfigPath() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "path",
+		Short: "Print where cn searches for its configuration file",
+		Args:  cobra.NoArgs,
+		Run:   pathConfig,
+	}
+	return cmd
+}
+
+// cliConfigEdit is the Cobra CLI call
+func cliConfigEdit() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "edit",
+		Short: "Edit the configuration file with $EDITOR",
+		Args:  cobra.NoArgs,
+		Run:   editConfig,
+	}
+	return cmd
+}
+
+// cliConfigValidate is the Cobra CLI call
+func cliConfigValidate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate [FILE]",
+		Short: "Check a configuration file, the one in use by default",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   validateConfig,
+		Example: "cn config validate\n" +
+			"cn config validate ./cn.toml\n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+// getConfigFlag returns the value of --config from the command line
+// The configuration is loaded before Cobra parses the flags, so it's looked up here
+func getConfigFlag(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--config=") {
+			return strings.TrimPrefix(arg, "--config=")
+		}
+	}
+	return ""
+}
+
+// isConfigCommand reports if the command line runs 'cn config'
+func isConfigCommand(args []string) bool {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--config" {
+			// Skipping the value of --config
+			i++
+			continue
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			return args[i] == "config"
+		}
+	}
+	return false
+}
+
+// getConfigSearchPaths returns the configuration files cn looks for, in that order
+func getConfigSearchPaths() []string {
+	workingDirectory, _ := os.Getwd()
+	return []string{
+		filepath.Join("/etc/cn", configFileName),
+		makeCephNanoPath(configFileName),
+		filepath.Join(workingDirectory, configFileName),
+	}
+}
+
+// getWritableConfigFile returns the configuration file to modify, ~/.cn/cn.toml if none is used yet
+func getWritableConfigFile() string {
+	if len(configurationFile) > 0 {
+		return configurationFile
+	}
+	return makeCephNanoPath(configFileName)
+}
+
+// readFileConfig loads the configuration file only, without the builtins
+func readFileConfig(file string) *viper.Viper {
+	fileConfig := viper.New()
+	if len(file) == 0 {
+		return fileConfig
+	}
+	fileConfig.SetConfigFile(file)
+	if err := fileConfig.ReadInConfig(); err != nil {
+		log.Fatal(err)
+	}
+	return fileConfig
+}
+
+// getConfigSource reports where the value of a key comes from
+func getConfigSource(fileConfig *viper.Viper, key string) string {
+	if _, found := getConfigEnv(key); found {
+		return configSourceEnv + " (" + getConfigEnvName(key) + ")"
+	}
+	if fileConfig.IsSet(key) {
+		return configSourceFile
+	}
+	// Flavors get the items of their parents and images the ones of their default entry, see mergeFlavorsWithDefault()
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) < 3 || (parts[0] != FLAVORS && parts[0] != IMAGES) {
+		return configSourceBuiltin
+	}
+	parents := []string{"default"}
+	if parts[0] == FLAVORS {
+		chain, _ := getFlavorChain(parts[1])
+		parents = chain[1:]
+	}
+	for _, parent := range parents {
+		if _, found := getConfigEnv(parts[0] + "." + parent + "." + parts[2]); found && parent != parts[1] {
+			return configSourceEnv + " (" + getConfigEnvName(parts[0]+"."+parent+"."+parts[2]) + ")"
+		}
+		if parent != parts[1] && fileConfig.IsSet(parts[0]+"."+parent+"."+parts[2]) {
+			return configSourceFile + " (" + parts[0] + "." + parent + ")"
+		}
+	}
+	return configSourceBuiltin
+}
+
+// getConfigKeys returns the sorted configuration keys starting with a prefix
+func getConfigKeys(prefix string) []string {
+	var keys []string
+	prefix = strings.ToLower(prefix)
+	for _, key := range viper.AllKeys() {
+		// The name of a flavor is set by mergeFlavorsWithDefault(), it's not a setting
+		if strings.HasPrefix(key, FLAVORS+".") && strings.HasSuffix(key, ".name") && strings.Count(key, ".") == 2 {
+			continue
+		}
+		if len(prefix) == 0 || key == prefix || strings.HasPrefix(key, prefix+".") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func viewConfig(cmd *cobra.Command, args []string) {
+	var prefix string
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	keys := getConfigKeys(prefix)
+	if len(keys) == 0 {
+		log.Fatal("There is no configuration key matching " + prefix)
+	}
+
+	fileConfig := readFileConfig(configurationFile)
+	table := termtables.CreateTable()
+	table.AddHeaders("KEY", "VALUE", "SOURCE")
+	for _, key := range keys {
+		table.AddRow(key, fmt.Sprint(viper.Get(key)), getConfigSource(fileConfig, key))
+	}
+	fmt.Println(table.Render())
+}
+
+func getConfig(cmd *cobra.Command, args []string) {
+	if !viper.IsSet(args[0]) {
+		log.Fatal(args[0] + " is not set")
+	}
+	value := viper.Get(args[0])
+	if _, isTable := value.(map[string]interface{}); isTable {
+		PrettyPrint(value)
+		return
+	}
+	fmt.Println(value)
+}
+
+func setConfig(cmd *cobra.Command, args []string) {
+	key := strings.ToLower(args[0])
+	value, err := encodeConfigValue(key, args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	file := getWritableConfigFile()
+	err = updateConfigFile(file, func(doc *tomlDocument) error {
+		return doc.Set(key, value)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s set to %s in %s\n", key, value, file)
+	if _, found := getConfigEnv(key); found {
+		log.Println("Warning: " + getConfigEnvName(key) + " is set, it overrides this value.")
+	}
+}
+
+func unsetConfig(cmd *cobra.Command, args []string) {
+	key := strings.ToLower(args[0])
+	if len(configurationFile) == 0 {
+		log.Fatal("There is no configuration file, " + key + " can't be unset")
+	}
+	err := updateConfigFile(configurationFile, func(doc *tomlDocument) error {
+		found, err := doc.Unset(key)
+		if err == nil && !found {
+			err = errors.New(key + " is not set in " + configurationFile)
+		}
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s removed from %s\n", key, configurationFile)
+}
+
+func pathConfig(cmd *cobra.Command, args []string) {
+	table := termtables.CreateTable()
+	table.AddHeaders("PATH", "STATUS")
+	if len(configFlag) > 0 {
+		table.AddRow(configurationFile, "in use (--config)")
+	} else {
+		for _, file := range getConfigSearchPaths() {
+			status := ""
+			if _, err := os.Stat(file); err == nil {
+				status = "found"
+				if sameFile(file, configurationFile) {
+					status = "in use"
+				}
+			}
+			table.AddRow(file, status)
+		}
+	}
+	fmt.Println(table.Render())
+	if len(configurationFile) == 0 {
+		fmt.Println("No configuration file found, the builtin values are used")
+	}
+}
+
+// sameFile reports if two paths point to the same file
+func sameFile(a string, b string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+func editConfig(cmd *cobra.Command, args []string) {
+	file := getWritableConfigFile()
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+
+	// The file is edited as a copy, a broken configuration never replaces the one in use
+	tmp, err := ioutil.TempFile("", "cn-config-*.toml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(content)
+	tmp.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for {
+		if err := runEditor(tmp.Name()); err != nil {
+			log.Fatal(err)
+		}
+		edited, err := ioutil.ReadFile(tmp.Name())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if bytes.Equal(edited, content) {
+			fmt.Println("No change made to " + file)
+			return
+		}
+		problems, err := checkConfigContent(file, edited)
+		if err == nil {
+			printConfigProblems(problems, true)
+			err = getConfigErrors(problems)
+		}
+		if err == nil {
+			if err := writeFileAtomically(file, edited, 0644); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(file + " saved")
+			return
+		}
+		fmt.Println("The configuration is invalid:\n" + err.Error())
+		if !askYesNo("Edit it again?") {
+			log.Fatal("Changes discarded, " + file + " is unchanged")
+		}
+	}
+}
+
+// runEditor opens a file with $VISUAL or $EDITOR, vi otherwise
+func runEditor(file string) error {
+	editor := os.Getenv("VISUAL")
+	if len(editor) == 0 {
+		editor = os.Getenv("EDITOR")
+	}
+	if len(editor) == 0 {
+		editor = "vi"
+	}
+	// The editor may come with arguments, e.g: code --wait
+	args := strings.Fields(editor)
+	editorCmd := exec.Command(args[0], append(args[1:], file)...)
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = os.Stdout
+	editorCmd.Stderr = os.Stderr
+	if err := editorCmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s", editor, err)
+	}
+	return nil
+}
+
+// askYesNo asks a question on the terminal, yes is the default answer
+func askYesNo(question string) bool {
+	fmt.Print(question + " [Y/n] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		// Nobody is there to answer
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+func validateConfig(cmd *cobra.Command, args []string) {
+	file := configurationFile
+	if len(args) > 0 {
+		file = args[0]
+	}
+	var problems []configProblem
+	if len(file) > 0 {
+		var err error
+		if problems, err = checkConfigFile(file); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// The environment variables only override the configuration in use
+	if len(args) == 0 {
+		problems = append(problems, checkConfigEnv(os.Environ())...)
+	}
+	if len(problems) == 0 {
+		if len(file) == 0 {
+			fmt.Println("There is no configuration file, the builtin values are used")
+		} else {
+			fmt.Println(file + " is valid")
+		}
+		return
+	}
+	printConfigProblems(problems, false)
+	// Unknown keys don't prevent cn from running, they are still reported as failures here
+	os.Exit(1)
+}
+
+// printConfigProblems prints the problems of a configuration, warnings only if asked
+func printConfigProblems(problems []configProblem, warningsOnly bool) {
+	for _, problem := range problems {
+		if problem.warning || !warningsOnly {
+			fmt.Println(problem.String())
+		}
+	}
+}
+
+// updateConfigFile applies a change to a configuration file, the result is validated before being written
+func updateConfigFile(file string, change func(*tomlDocument) error) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	doc := parseTOMLDocument(string(content))
+	if err := change(doc); err != nil {
+		return err
+	}
+	problems, err := checkConfigContent(file, []byte(doc.String()))
+	if err == nil {
+		err = getConfigErrors(problems)
+	}
+	if err != nil {
+		return fmt.Errorf("the change would break %s:\n%s", file, err)
+	}
+
+	// Only the warnings brought by the change are worth reporting, e.g: a misspelled key
+	previous, _ := checkConfigContent(file, content)
+	for _, problem := range problems {
+		if !hasConfigProblem(previous, problem.message) {
+			fmt.Println(problem.String())
+		}
+	}
+	return writeFileAtomically(file, []byte(doc.String()), 0644)
+}
+
+// hasConfigProblem reports if a problem is part of a list
+func hasConfigProblem(problems []configProblem, message string) bool {
+	for _, problem := range problems {
+		if problem.message == message {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeConfigValue returns the TOML representation of a value given on the command line
+// The type of the current value is kept, e.g: cpu_count stays an integer
+func encodeConfigValue(key string, value string) (string, error) {
+	current := viper.Get(key)
+	if current == nil {
+		// A new flavor or image item has the type of the default one
+		parts := strings.SplitN(key, ".", 3)
+		if len(parts) == 3 && (parts[0] == FLAVORS || parts[0] == IMAGES) {
+			current = viper.Get(parts[0] + ".default." + parts[2])
+		}
+	}
+
+	switch current.(type) {
+	case map[string]interface{}:
+		return "", errors.New(key + " is a table, set its keys one by one")
+	case []interface{}, []string:
+		return "", errors.New(key + " is a list, use 'cn config edit' to change it")
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%s expects true or false, not %s", key, value)
+		}
+		return strconv.FormatBool(b), nil
+	case int, int32, int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s expects an integer, not %s", key, value)
+		}
+		return strconv.FormatInt(i, 10), nil
+	case float32, float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s expects a number, not %s", key, value)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	case string:
+		return strconv.Quote(value), nil
+	}
+
+	// That's a new key, let's guess its type
+	if b, err := strconv.ParseBool(value); err == nil && (value == "true" || value == "false") {
+		return strconv.FormatBool(b), nil
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return strconv.FormatInt(i, 10), nil
+	}
+	return strconv.Quote(value), nil
+}
@@ -0,0 +1,152 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// configEnvPrefix starts the environment variables overriding the configuration, e.g: CN_FLAVORS_DEFAULT_MEMORY_SIZE
+	configEnvPrefix = "CN"
+
+	// configSourceEnv is the source of a value coming from an environment variable
+	configSourceEnv = "env"
+)
+
+var (
+	// configEnvReplacer turns a configuration key into an environment variable name, flavor names can hold a '-'
+	configEnvReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+	// configEnvIgnored lists the CN_ environment variables which are not configuration keys
+	configEnvIgnored = []string{"CN_REGISTRY"}
+)
+
+// setConfigEnv makes every configuration key readable from an environment variable
+// The environment wins over the configuration file which wins over the builtin values
+func setConfigEnv() {
+	viper.SetEnvPrefix(configEnvPrefix)
+	viper.SetEnvKeyReplacer(configEnvReplacer)
+	viper.AutomaticEnv()
+}
+
+// getConfigEnvName returns the environment variable overriding a configuration key
+func getConfigEnvName(key string) string {
+	return configEnvPrefix + "_" + strings.ToUpper(configEnvReplacer.Replace(key))
+}
+
+// getConfigEnv returns the value of the environment variable overriding a key
+// Like viper does, an empty variable doesn't override anything
+func getConfigEnv(key string) (string, bool) {
+	value := os.Getenv(getConfigEnvName(key))
+	return value, len(value) > 0
+}
+
+// checkConfigEnv checks the CN_ environment variables against the schema
+// environ is formatted like os.Environ(), e.g: CN_FLAVORS_DEFAULT_CPU_COUNT=2
+func checkConfigEnv(environ []string) []configProblem {
+	keys := make(map[string]string)
+	var names []string
+	for _, key := range viper.AllKeys() {
+		keys[getConfigEnvName(key)] = key
+		names = append(names, getConfigEnvName(key))
+	}
+	sort.Strings(names)
+
+	var problems []configProblem
+	for _, variable := range environ {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 || !strings.HasPrefix(parts[0], configEnvPrefix+"_") || isStringInSlice(parts[0], configEnvIgnored) {
+			continue
+		}
+		key, found := keys[parts[0]]
+		if !found {
+			problems = append(problems, configProblem{file: parts[0], warning: true,
+				message: "not a configuration key, it is ignored" + didYouMean(parts[0], names)})
+			continue
+		}
+		if message := checkConfigEnvValue(key, parts[1]); len(message) > 0 {
+			problems = append(problems, configProblem{file: parts[0], message: key + " " + message})
+		}
+	}
+	return problems
+}
+
+// checkConfigEnvValue checks the string of an environment variable as the value of a key
+func checkConfigEnvValue(key string, value string) string {
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	spec, found := configSchema[parts[0]][parts[2]]
+	if !found {
+		// Tables like ceph.conf take any value
+		return ""
+	}
+
+	var typed interface{} = value
+	switch spec.kind {
+	case configBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			typed = b
+		}
+	case configInt:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			typed = i
+		}
+	case configTable:
+		return fmt.Sprintf("is a table, set its keys instead, e.g: %s_OSD_MEMORY_TARGET", getConfigEnvName(key))
+	case configList:
+		// Like viper.GetStringSlice(), the items of a list are separated by spaces
+		var list []interface{}
+		for _, item := range strings.Fields(value) {
+			list = append(list, item)
+		}
+		typed = list
+	}
+	return checkConfigValue(spec, typed)
+}
+
+// reportConfigEnvProblems prints the problems of the CN_ environment variables, cn exits if one is an error
+func reportConfigEnvProblems() {
+	if isConfigCommand(os.Args[1:]) {
+		return
+	}
+	problems := checkConfigEnv(os.Environ())
+	for _, problem := range problems {
+		if problem.warning {
+			fmt.Fprintln(os.Stderr, problem.String())
+		}
+	}
+	if err := getConfigErrors(problems); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "Please fix the "+configEnvPrefix+"_ environment variables, 'cn config validate' reports the problems.")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetConfigEnvName(t *testing.T) {
+	assert.Equal(t, "CN_FLAVORS_DEFAULT_MEMORY_SIZE", getConfigEnvName("flavors.default.memory_size"))
+	assert.Equal(t, "CN_UPDATE_CONFIG_WANT_UPDATE_NOTIFICATION", getConfigEnvName("update.config.want_update_notification"))
+	assert.Equal(t, "CN_FLAVORS_CI_TLS_CEPH_CONF_OSD_MEMORY_TARGET", getConfigEnvName("flavors.ci-tls.ceph.conf.osd_memory_target"))
+}
+
+func TestConfigEnvOverride(t *testing.T) {
+	readConfigFile(configFile)
+	os.Setenv("CN_FLAVORS_HUGE_CPU_COUNT", "3")
+	os.Setenv("CN_FLAVORS_TEST_CI_SMALL_MEMORY_SIZE", "2GB")
+	os.Setenv("CN_FLAVORS_TEST_CI_SMALL_CEPH_CONF_OSD_MEMORY_TARGET", "1073741824")
+	defer os.Unsetenv("CN_FLAVORS_HUGE_CPU_COUNT")
+	defer os.Unsetenv("CN_FLAVORS_TEST_CI_SMALL_MEMORY_SIZE")
+	defer os.Unsetenv("CN_FLAVORS_TEST_CI_SMALL_CEPH_CONF_OSD_MEMORY_TARGET")
+
+	assert.Equal(t, int64(3), getInt64FromConfig(FLAVORS, "huge", "cpu_count"))
+	assert.Equal(t, "2GB", getStringFromConfig(FLAVORS, "test_ci_small", "memory_size"))
+	assert.Equal(t, "1073741824", getStringMapFromConfig(FLAVORS, "test_ci_small", "ceph.conf")["osd_memory_target"])
+
+	fileConfig := readFileConfig(configFile)
+	assert.Equal(t, "env (CN_FLAVORS_HUGE_CPU_COUNT)", getConfigSource(fileConfig, "flavors.huge.cpu_count"))
+	assert.Equal(t, "env (CN_FLAVORS_TEST_CI_SMALL_MEMORY_SIZE)", getConfigSource(fileConfig, "flavors.test_ci_small.memory_size"))
+	// Children get the value of the environment variable of their parent
+	assert.Equal(t, "env (CN_FLAVORS_TEST_CI_SMALL_MEMORY_SIZE)", getConfigSource(fileConfig, "flavors.test_ci_small_tls.memory_size"))
+	assert.Equal(t, configSourceBuiltin, getConfigSource(fileConfig, "flavors.huge.memory_size"))
+}
+
+func TestCheckConfigEnv(t *testing.T) {
+	readConfigFile(configFile)
+	problems := checkConfigEnv([]string{
+		"CN_FLAVORS_DEFAULT_MEMORY_SIZE=1GB",
+		"CN_FLAVORS_HUGE_CPU_COUNT=4",
+		"CN_FLAVORS_TEST_RICH_ENV=A=1 B=2",
+		"CN_UPDATE_CONFIG_WANT_UPDATE_NOTIFICATION=false",
+		"CN_REGISTRY=redhat",
+		"CN_FLAVORS_DEFAULT_CPU_COUNT=",
+		"HOME=/root",
+	})
+	assert.Empty(t, problems)
+
+	problems = checkConfigEnv([]string{
+		"CN_FLAVORS_DEFAULT_MEMORY_SIZE=lots",
+		"CN_FLAVORS_HUGE_CPU_COUNT=two",
+		"CN_FLAVORS_DEFAULT_PRIVILEGED=maybe",
+		"CN_FLAVORS_DEFAULT_MEMORY_SIZ=1GB",
+		"CN_FLAVORS_TEST_RICH_ENV=RGW_FRONTEND_PORT=80",
+	})
+	if assert.Len(t, problems, 5) {
+		assert.Equal(t, `CN_FLAVORS_DEFAULT_MEMORY_SIZE: error: flavors.default.memory_size "lots" is not a valid size (`, problems[0].String()[:len(`CN_FLAVORS_DEFAULT_MEMORY_SIZE: error: flavors.default.memory_size "lots" is not a valid size (`)])
+		assert.Equal(t, `CN_FLAVORS_HUGE_CPU_COUNT: error: flavors.huge.cpu_count must be an integer, not the string "two"`, problems[1].String())
+		assert.Equal(t, `CN_FLAVORS_DEFAULT_PRIVILEGED: error: flavors.default.privileged must be true or false, not the string "maybe"`, problems[2].String())
+		assert.Equal(t, "CN_FLAVORS_DEFAULT_MEMORY_SIZ: warning: not a configuration key, it is ignored, did you mean CN_FLAVORS_DEFAULT_MEMORY_SIZE?", problems[3].String())
+		assert.False(t, problems[4].warning)
+	}
+}
@@ -23,8 +23,8 @@
 package cmd
 
 import (
+	"errors"
 	"log"
-	"path"
 	"strings"
 
 	"github.com/spf13/viper"
@@ -45,9 +45,15 @@ const LATESTIMAGE = DEFAULTIMAGE + ":latest-"
 // DEFAULTWORKDIRECTORY is the default work directory
 const DEFAULTWORKDIRECTORY = "/usr/share/ceph-nano"
 
+// DEFAULTBINDADDRESS is the default host address to publish the ports on
+const DEFAULTBINDADDRESS = "127.0.0.1"
+
 // UPDATE is a constant to represent the [update] group
 const UPDATE = "update"
 
+// LOCK is a constant to represent the [lock] group
+const LOCK = "lock"
+
 func readConfigFile(customFile ...string) string {
 	// By default, we consider there is no configuration file
 	var configurationFile string
@@ -55,14 +61,14 @@ func readConfigFile(customFile ...string) string {
 	// Loading the builtin values
 	setDefaultConfig()
 
+	// The CN_ environment variables override the builtin values and the configuration file
+	setConfigEnv()
+
 	// A custom configuration file got passed
 	// Let's handle it directly
 	if len(customFile) > 0 {
 		// customFile is an array of optional arguments
-		var filename = path.Base(customFile[0])
-		var fileDir = path.Dir(customFile[0])
-		viper.SetConfigFile(filename)
-		viper.AddConfigPath(fileDir)
+		viper.SetConfigFile(customFile[0])
 		err := viper.ReadInConfig()
 		// Find and read the config file
 		// If there is no configuration file, that's an error
@@ -81,15 +87,25 @@ func readConfigFile(customFile ...string) string {
 	viper.AddConfigPath(".")          // optionally look for config in the working directory
 
 	// Let's try to read an optional configuration file
-	if viper.ReadInConfig() == nil {
+	if err := viper.ReadInConfig(); err == nil {
+		configurationFile = viper.ConfigFileUsed()
+		goto out
+	} else if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound {
+		// A broken configuration file is not ignored, reportConfigProblems() tells what's wrong with it
 		configurationFile = viper.ConfigFileUsed()
 		goto out
 	}
 
 	// 'Out' label is a place to exit this function properly
 out:
+	// Let's check the configuration file against the schema
+	if len(configurationFile) > 0 {
+		reportConfigProblems(configurationFile)
+	}
 	// Let's import all the default value into flavors (builtins + customs from configuration file)
 	mergeFlavorsWithDefault()
+	// Let's check the environment variables once the flavors know all their keys
+	reportConfigEnvProblems()
 	// Returning the actual configuration file
 	return configurationFile
 }
@@ -105,6 +121,20 @@ func setDefaultConfig() {
 	viper.SetDefault(FLAVORS+".default.data", "")
 	viper.SetDefault(FLAVORS+".default.size", "")
 	viper.SetDefault(FLAVORS+".default.work_directory", DEFAULTWORKDIRECTORY)
+	viper.SetDefault(FLAVORS+".default.tls", false)
+	viper.SetDefault(FLAVORS+".default.bind_address", DEFAULTBINDADDRESS)
+	viper.SetDefault(FLAVORS+".default.advertise_address", "")
+	viper.SetDefault(FLAVORS+".default.ttl", "")
+	viper.SetDefault(FLAVORS+".default.prometheus", false)
+	viper.SetDefault(FLAVORS+".default.storage", "")
+	viper.SetDefault(FLAVORS+".default.env", []string{})
+	viper.SetDefault(FLAVORS+".default.volumes", []string{})
+	viper.SetDefault(FLAVORS+".default.ports", []string{})
+	viper.SetDefault(FLAVORS+".default.labels", []string{})
+	viper.SetDefault(FLAVORS+".default.ulimits", []string{})
+	viper.SetDefault(FLAVORS+".default.shm_size", "")
+	viper.SetDefault(FLAVORS+".default.restart_policy", "")
+	viper.SetDefault(FLAVORS+".default.demo_daemons", []string{})
 	viper.SetDefault(FLAVORS+".medium.memory_size", "768MB")
 	viper.SetDefault(FLAVORS+".large.memory_size", "1GB")
 	viper.SetDefault(FLAVORS+".huge.memory_size", "4GB")
@@ -121,6 +151,12 @@ func setDefaultConfig() {
 	// Setting up the default update notification configuration
 	viper.SetDefault(UPDATE+".config.want_update_notification", true)
 	viper.SetDefault(UPDATE+".config.reminder_wait_period_in_hours", 24)
+	viper.SetDefault(UPDATE+".config.channel", releaseChannelStable)     // stable or pre-release, see cn self-update
+	viper.SetDefault(UPDATE+".config.releases_url", githubCNReleasesURL) // A mirror of the GitHub releases API
+	viper.SetDefault(UPDATE+".config.signing_key", "")                   // Armored public key verifying the signed releases
+
+	// Setting up what to do when the local image differs from the one pinned in cn.lock
+	viper.SetDefault(LOCK+".config.on_mismatch", "warn")
 }
 
 func getStringFromConfig(group string, item string, name string) string {
@@ -179,13 +215,18 @@ func useDefault(group string, item string) bool {
 
 func getStringMapFromConfig(group string, item string, name string) map[string]interface{} {
 	var defaultConfig = make(map[string]interface{})
-	if useDefault(group, item) {
-		defaultConfig = viper.GetStringMap(group + ".default" + "." + name)
+	chain := []string{item}
+	if group == FLAVORS {
+		// A broken chain was reported when loading the configuration file, let's only consider the flavor itself
+		chain, _ = getFlavorChain(item)
+	} else if useDefault(group, item) {
+		chain = append(chain, "default")
 	}
-	itemValues := viper.GetStringMap(group + "." + item + "." + name)
-	if len(itemValues) > 0 {
-		for key, value := range itemValues {
-			defaultConfig[key] = value
+	// Starting from the farthest parent, the nearest values win
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key := range viper.GetStringMap(group + "." + chain[i] + "." + name) {
+			// Reading each key picks up its environment variable, e.g: CN_FLAVORS_DEFAULT_CEPH_CONF_OSD_MEMORY_TARGET
+			defaultConfig[key] = viper.Get(group + "." + chain[i] + "." + name + "." + key)
 		}
 	}
 	return defaultConfig
@@ -207,43 +248,87 @@ func isParameterExist(group string, item string, parameter string) bool {
 
 // A function to list the default parameters as they are not always seen
 func getDefaultParameters() map[string]interface{} {
+	return getFlavorParameters("default")
+}
+
+// getFlavorParameters lists the parameters of a flavor, nested ones like ceph.conf.osd_memory_target included
+func getFlavorParameters(flavor string) map[string]interface{} {
 	returnValue := make(map[string]interface{})
+	prefix := FLAVORS + "." + flavor + "."
 	// For each keys in the configuration
 	for _, param := range viper.AllKeys() {
-		// If there is a default entry
-		if strings.HasPrefix(param, FLAVORS+".default.") {
-			// extract the parameter name
-			parameter := strings.SplitAfter(param, FLAVORS+".default.")[1]
+		// If there is an entry of this flavor
+		if strings.HasPrefix(param, prefix) {
 			// Let's return the association parameter/value
-			returnValue[parameter] = viper.Get(param)
+			returnValue[strings.TrimPrefix(param, prefix)] = viper.Get(param)
 		}
 	}
 	return returnValue
 }
 
-// Considering the use_default value, let's merge the default values in other flavors
+// getFlavorParent returns the flavor a flavor inherits from, an empty string if none
+// 'inherits' wins over 'use_default' which only makes a flavor inherit from default
+func getFlavorParent(flavor string) string {
+	if flavor == "default" {
+		return ""
+	}
+	if isParameterExist(FLAVORS, flavor, "inherits") {
+		return viper.GetString(FLAVORS + "." + flavor + ".inherits")
+	}
+	if useDefault(FLAVORS, flavor) {
+		return "default"
+	}
+	return ""
+}
+
+// getFlavorChain returns a flavor followed by the flavors it inherits from, nearest first
+// e.g: ci-small-tls, ci-small, default
+func getFlavorChain(flavor string) ([]string, error) {
+	chain := []string{flavor}
+	for current := flavor; ; {
+		parent := getFlavorParent(current)
+		if len(parent) == 0 {
+			return chain, nil
+		}
+		for _, seen := range chain {
+			if seen == parent {
+				return []string{flavor}, errors.New("flavor " + flavor + " has an inheritance cycle: " + strings.Join(append(chain, parent), " -> "))
+			}
+		}
+		if !isEntryExist(FLAVORS, parent) {
+			return []string{flavor}, errors.New("flavor " + current + " inherits from " + parent + " which doesn't exist")
+		}
+		chain = append(chain, parent)
+		current = parent
+	}
+}
+
+// Considering the inherits and use_default values, let's merge the parent values in other flavors
 func mergeFlavorsWithDefault() {
-	// For each flavor
+	// The chains are computed first, merging a flavor must not change the parent of another one
+	chains := make(map[string][]string)
 	for flavor := range getItemsFromGroup(FLAVORS) {
 		// Adding the name of the flavor in the flavor itself
 		// This is useful to render it to users
 		viper.SetDefault(FLAVORS+"."+flavor+".name", flavor)
 
-		// Let's skip the default flavor
-		if flavor == "default" {
-			continue
-		}
+		// A broken chain was reported when loading the configuration file, the flavor is left as is
+		chains[flavor], _ = getFlavorChain(flavor)
+	}
 
-		// Nothing to do if the flavor sets the use_default=false
-		if !useDefault(FLAVORS, flavor) {
-			continue
-		}
-		// For every default parameter
-		for defaultParameter, defaultValue := range getDefaultParameters() {
-			// If the flavor doesn't define it
-			if viper.Get(FLAVORS+"."+flavor+"."+defaultParameter) == nil {
-				// Let's copy the default value in this flavor
-				viper.SetDefault(FLAVORS+"."+flavor+"."+defaultParameter, defaultValue)
+	for flavor, chain := range chains {
+		// From the nearest parent to the farthest, only the parameters the flavor doesn't have yet are copied
+		// Tables like ceph.conf are merged key by key as their parameters are flattened
+		for _, parent := range chain[1:] {
+			for parameter, value := range getFlavorParameters(parent) {
+				if parameter == "name" || parameter == "inherits" {
+					continue
+				}
+				// If the flavor doesn't define it
+				if viper.Get(FLAVORS+"."+flavor+"."+parameter) == nil {
+					// Let's copy the parent value in this flavor
+					viper.SetDefault(FLAVORS+"."+flavor+"."+parameter, value)
+				}
 			}
 		}
 	}
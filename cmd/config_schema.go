@@ -0,0 +1,503 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// configKind is the kind of value a configuration key expects
+type configKind int
+
+const (
+	configString   configKind = iota // configString is any string
+	configBool                       // configBool is true or false
+	configInt                        // configInt is an integer
+	configSize                       // configSize is a size parsed like toBytes, e.g: 4GB
+	configDuration                   // configDuration is a duration, e.g: 90m
+	configTable                      // configTable is a free form table, e.g: ceph.conf
+	configList                       // configList is a list of strings, e.g: env
+)
+
+// configKey describes a key of a configuration item
+type configKey struct {
+	kind     configKind
+	choices  []string           // choices are the allowed values of a string, if any
+	check    func(string) error // check validates a string or each string of a list, if any
+	required bool               // required keys must be set by a flavor not inheriting from default
+}
+
+// configSchema lists the keys of the items of each group
+var configSchema = map[string]map[string]configKey{
+	FLAVORS: {
+		"use_default":       {kind: configBool},
+		"inherits":          {kind: configString},
+		"memory_size":       {kind: configSize, required: true},
+		"cpu_count":         {kind: configInt, required: true},
+		"privileged":        {kind: configBool, required: true},
+		"data":              {kind: configString, required: true},
+		"size":              {kind: configSize, required: true},
+		"work_directory":    {kind: configString, required: true},
+		"tls":               {kind: configBool},
+		"bind_address":      {kind: configString},
+		"advertise_address": {kind: configString},
+		"ttl":               {kind: configDuration},
+		"prometheus":        {kind: configBool},
+		"storage":           {kind: configString, choices: []string{"", memoryStorage}},
+		"ceph.conf":         {kind: configTable},
+		"env":               {kind: configList, check: checkFlavorEnv},
+		"volumes":           {kind: configList, check: checkFlavorVolume},
+		"ports":             {kind: configList, check: checkFlavorPort},
+		"labels":            {kind: configList, check: checkFlavorLabel},
+		"ulimits":           {kind: configList, check: checkFlavorUlimit},
+		"shm_size":          {kind: configSize},
+		"restart_policy":    {kind: configString, check: checkRestartPolicy},
+		"demo_daemons":      {kind: configList, check: checkDemoDaemon},
+	},
+	IMAGES: {
+		"use_default": {kind: configBool},
+		"image_name":  {kind: configString},
+	},
+	UPDATE: {
+		"want_update_notification":      {kind: configBool},
+		"reminder_wait_period_in_hours": {kind: configInt},
+		"channel":                       {kind: configString, choices: releaseChannels},
+		"releases_url":                  {kind: configString},
+		"signing_key":                   {kind: configString},
+	},
+	LOCK: {
+		"on_mismatch": {kind: configString, choices: []string{lockMismatchWarn, lockMismatchFail}},
+	},
+}
+
+// builtinFlavors lists the flavors set by setDefaultConfig(), a flavor can inherit from them
+var builtinFlavors = []string{"default", "medium", "large", "huge"}
+
+// builtinImages lists the image aliases set by setDefaultConfig()
+var builtinImages = []string{"default", "mimic", "luminous", "redhat"}
+
+// configFixedItems lists the groups with a single item, the items of the others are free, e.g: flavor names
+var configFixedItems = map[string]string{
+	UPDATE: "config",
+	LOCK:   "config",
+}
+
+// configProblem is an issue found in a configuration file
+type configProblem struct {
+	file    string
+	line    int
+	column  int
+	message string
+	warning bool // warning means the configuration still loads, e.g: an unknown key is ignored
+}
+
+func (p configProblem) String() string {
+	severity := "error"
+	if p.warning {
+		severity = "warning"
+	}
+	// Environment variables have no position
+	if p.line == 0 {
+		return fmt.Sprintf("%s: %s: %s", p.file, severity, p.message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s", p.file, p.line, p.column, severity, p.message)
+}
+
+// configChecker collects the problems of a configuration file
+type configChecker struct {
+	file     string
+	problems []configProblem
+}
+
+func (c *configChecker) report(position toml.Position, warning bool, format string, args ...interface{}) {
+	c.problems = append(c.problems, configProblem{
+		file:    c.file,
+		line:    position.Line,
+		column:  position.Col,
+		message: fmt.Sprintf(format, args...),
+		warning: warning,
+	})
+}
+
+// checkConfigFile checks a configuration file against the schema
+func checkConfigFile(file string) ([]configProblem, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return checkConfigContent(file, content)
+}
+
+// checkConfigContent checks the content of a configuration file against the schema
+// An error means the content is not even valid TOML
+func checkConfigContent(file string, content []byte) ([]configProblem, error) {
+	tree, err := toml.LoadBytes(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	checker := &configChecker{file: file}
+	for _, group := range tree.Keys() {
+		position := tree.GetPositionPath([]string{group})
+		items, isTable := tree.GetPath([]string{group}).(*toml.Tree)
+		if _, known := configSchema[strings.ToLower(group)]; !known {
+			kind := "key"
+			if isTable {
+				kind = "group"
+			}
+			checker.report(position, true, "unknown %s %s%s", kind, group, didYouMean(group, getSchemaGroups()))
+			continue
+		}
+		if !isTable {
+			checker.report(position, false, "%s must be a table, e.g: [%s.default]", group, group)
+			continue
+		}
+		checker.checkGroup(strings.ToLower(group), items)
+		if strings.ToLower(group) == FLAVORS {
+			checker.checkInheritance(items)
+		}
+	}
+
+	sort.SliceStable(checker.problems, func(i, j int) bool {
+		return checker.problems[i].line < checker.problems[j].line
+	})
+	return checker.problems, nil
+}
+
+// getSchemaGroups returns the groups of the schema
+func getSchemaGroups() []string {
+	var groups []string
+	for group := range configSchema {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (c *configChecker) checkGroup(group string, items *toml.Tree) {
+	for _, item := range items.Keys() {
+		position := items.GetPositionPath([]string{item})
+		if fixedItem, fixed := configFixedItems[group]; fixed && strings.ToLower(item) != fixedItem {
+			c.report(position, true, "unknown item %s.%s%s", group, item, didYouMean(item, []string{fixedItem}))
+			continue
+		}
+		keys, isTable := items.GetPath([]string{item}).(*toml.Tree)
+		if !isTable {
+			c.report(position, false, "%s.%s must be a table, e.g: [%s.%s]", group, item, group, item)
+			continue
+		}
+		c.checkItem(group, item, keys, position)
+	}
+}
+
+func (c *configChecker) checkItem(group string, item string, keys *toml.Tree, itemPosition toml.Position) {
+	schema := configSchema[group]
+	prefix := group + "." + item + "."
+	var known []string
+	for key := range schema {
+		known = append(known, key)
+	}
+
+	for _, key := range keys.Keys() {
+		position := keys.GetPositionPath([]string{key})
+		value := keys.GetPath([]string{key})
+		name := strings.ToLower(key)
+
+		// ceph.conf is parsed as a 'conf' table inside a 'ceph' table
+		if sub, isTable := value.(*toml.Tree); isTable && name == "ceph" {
+			if sub.Has("conf") && len(sub.Keys()) == 1 {
+				key, name, value = "ceph.conf", "ceph.conf", sub.GetPath([]string{"conf"})
+			}
+		}
+
+		spec, found := schema[name]
+		if !found {
+			c.report(position, true, "unknown key %s%s is ignored%s", prefix, key, didYouMean(name, known))
+			continue
+		}
+		if message := checkConfigValue(spec, value); len(message) > 0 {
+			c.report(position, false, "%s%s %s", prefix, key, message)
+		}
+	}
+
+	if parent, isString := keys.GetPath([]string{"inherits"}).(string); isString && group == FLAVORS {
+		if useDefault, isBool := keys.GetPath([]string{"use_default"}).(bool); isBool && !useDefault {
+			c.report(keys.GetPositionPath([]string{"use_default"}), true, "%suse_default is ignored, %s.%s inherits from %s", prefix, group, item, parent)
+		}
+	}
+
+	// Without inheritance, the keys read unconditionally must be there
+	if useDefault, isBool := keys.GetPath([]string{"use_default"}).(bool); isBool && !useDefault && !keys.Has("inherits") {
+		for name, spec := range schema {
+			if spec.required && !keys.Has(name) {
+				c.report(itemPosition, false, "%s%s is missing, %s.%s doesn't inherit from %s.default", prefix, name, group, item, group)
+			}
+		}
+		if group == IMAGES && !keys.Has("image_name") {
+			c.report(itemPosition, false, "the %s alias has no image_name, it can't be used", item)
+		}
+	}
+	if imageName, isString := keys.GetPath([]string{"image_name"}).(string); group == IMAGES && isString && len(imageName) == 0 {
+		c.report(keys.GetPositionPath([]string{"image_name"}), false, "the %s alias has an empty image_name, it can't be used", item)
+	}
+}
+
+// checkInheritance ensures the parents of the flavors exist and no flavor inherits from itself
+func (c *configChecker) checkInheritance(flavors *toml.Tree) {
+	parents := make(map[string]string)
+	positions := make(map[string]toml.Position)
+	for _, flavor := range builtinFlavors[1:] {
+		parents[flavor] = "default"
+	}
+	for _, flavor := range flavors.Keys() {
+		keys, isTable := flavors.GetPath([]string{flavor}).(*toml.Tree)
+		if !isTable {
+			continue
+		}
+		name := strings.ToLower(flavor)
+		if parent, isString := keys.GetPath([]string{"inherits"}).(string); isString {
+			parents[name] = parent
+			positions[name] = keys.GetPositionPath([]string{"inherits"})
+		} else if useDefault, isBool := keys.GetPath([]string{"use_default"}).(bool); isBool && !useDefault {
+			delete(parents, name)
+		} else if name != "default" {
+			parents[name] = "default"
+		}
+	}
+
+	var flavorNames []string
+	for _, flavor := range flavors.Keys() {
+		flavorNames = append(flavorNames, strings.ToLower(flavor))
+	}
+	flavorNames = append(flavorNames, builtinFlavors...)
+
+	for flavor, position := range positions {
+		parent := parents[flavor]
+		if flavor == "default" {
+			c.report(position, false, "flavors.default can't inherit from another flavor")
+			continue
+		}
+		if !isStringInSlice(parent, flavorNames) {
+			c.report(position, false, "flavors.%s inherits from %s which doesn't exist%s", flavor, parent, didYouMean(parent, flavorNames))
+			continue
+		}
+		chain := []string{flavor}
+		for current := parent; len(current) > 0; current = parents[current] {
+			if current == flavor {
+				c.report(position, false, "flavors.%s inherits from itself: %s", flavor, strings.Join(append(chain, current), " -> "))
+				break
+			}
+			if isStringInSlice(current, chain) {
+				// The cycle doesn't go through this flavor, it's reported on the flavors part of it
+				break
+			}
+			chain = append(chain, current)
+		}
+	}
+}
+
+// checkConfigValue returns what's wrong with a value, an empty string if nothing
+func checkConfigValue(spec configKey, value interface{}) string {
+	switch spec.kind {
+	case configBool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Sprintf("must be true or false, not %s", describeConfigValue(value))
+		}
+	case configInt:
+		if _, ok := value.(int64); !ok {
+			return fmt.Sprintf("must be an integer, not %s", describeConfigValue(value))
+		}
+	case configTable:
+		if _, ok := value.(*toml.Tree); !ok {
+			return fmt.Sprintf("must be a table, not %s", describeConfigValue(value))
+		}
+	case configList:
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Sprintf("must be a list of strings, not %s", describeConfigValue(value))
+		}
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Sprintf("must be a list of strings, not a list holding %s", describeConfigValue(item))
+			}
+			if spec.check != nil {
+				if err := spec.check(s); err != nil {
+					return err.Error()
+				}
+			}
+		}
+	default:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Sprintf("must be a string, not %s", describeConfigValue(value))
+		}
+		if len(spec.choices) > 0 && !isStringInSlice(s, spec.choices) {
+			return fmt.Sprintf("must be one of %s, not %q", strings.Join(quoteStrings(spec.choices), ", "), s)
+		}
+		// An empty size or duration means none
+		if len(s) == 0 {
+			return ""
+		}
+		if spec.kind == configSize {
+			if _, err := parseBytes(s); err != nil {
+				return fmt.Sprintf("%q is not a valid size (%s), e.g: 512MB or 4GB", s, err)
+			}
+		}
+		if spec.check != nil {
+			if err := spec.check(s); err != nil {
+				return err.Error()
+			}
+		}
+		if spec.kind == configDuration {
+			if _, err := time.ParseDuration(s); err != nil {
+				return fmt.Sprintf("%q is not a valid duration, e.g: 90m or 2h", s)
+			}
+		}
+	}
+	return ""
+}
+
+// describeConfigValue returns the TOML type of a value for error messages
+func describeConfigValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("the string %q", v)
+	case int64:
+		return fmt.Sprintf("the integer %d", v)
+	case float64:
+		return fmt.Sprintf("the number %v", v)
+	case bool:
+		return fmt.Sprintf("the boolean %t", v)
+	case *toml.Tree:
+		return "a table"
+	case []interface{}, []*toml.Tree:
+		return "an array"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// isStringInSlice reports if a string is part of a list
+func isStringInSlice(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteStrings quotes every string of a list
+func quoteStrings(list []string) []string {
+	var quoted []string
+	for _, s := range list {
+		quoted = append(quoted, fmt.Sprintf("%q", s))
+	}
+	return quoted
+}
+
+// didYouMean suggests the closest candidate of a misspelled name, e.g: memory_sise
+func didYouMean(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(candidate)); d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	if len(best) == 0 || bestDistance >= len(name) {
+		return ""
+	}
+	return ", did you mean " + best + "?"
+}
+
+// editDistance returns the Levenshtein distance of two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// getConfigErrors returns the problems preventing a configuration from being used
+func getConfigErrors(problems []configProblem) error {
+	var messages []string
+	for _, problem := range problems {
+		if !problem.warning {
+			messages = append(messages, problem.String())
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(messages, "\n"))
+}
+
+// reportConfigProblems prints the problems of the configuration file in use, errors are fatal
+// 'cn config' is left alone as it's the way to fix them
+func reportConfigProblems(file string) {
+	if isConfigCommand(os.Args[1:]) {
+		return
+	}
+	problems, err := checkConfigFile(file)
+	if err == nil {
+		for _, problem := range problems {
+			if problem.warning {
+				fmt.Fprintln(os.Stderr, problem.String())
+			}
+		}
+		err = getConfigErrors(problems)
+	}
+	if err == nil {
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+	fmt.Fprintln(os.Stderr, "Please fix "+file+", 'cn config validate' reports the problems and 'cn config edit' opens it.")
+	os.Exit(1)
+}
@@ -0,0 +1,187 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func checkTestConfig(t *testing.T, content string) []string {
+	problems, err := checkConfigContent("cn.toml", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	return messages
+}
+
+func TestCheckConfigValid(t *testing.T) {
+	assert.Empty(t, checkTestConfig(t, `
+[flavors.huge]
+  memory_size = "4GB"
+  cpu_count = 2
+  ttl = "2h"
+  storage = "memory"
+  [flavors.huge.ceph.conf]
+    osd_memory_target = 3841234556
+
+[images.nautilus]
+  image_name = "ceph/daemon:latest-nautilus"
+
+[update.config]
+  want_update_notification = false
+
+[lock.config]
+  on_mismatch = "fail"
+`))
+}
+
+func TestCheckConfigUnknownKeys(t *testing.T) {
+	assert.Equal(t, []string{
+		"cn.toml:2:1: warning: unknown group flavor, did you mean flavors?",
+		"cn.toml:5:3: warning: unknown key flavors.huge.memory_sise is ignored, did you mean memory_size?",
+		"cn.toml:6:3: warning: unknown key flavors.huge.nawak is ignored",
+		"cn.toml:8:2: warning: unknown item update.configs, did you mean config?",
+	}, checkTestConfig(t, `
+[flavor.huge]
+  memory_size = "4GB"
+[flavors.huge]
+  memory_sise = "4GB"
+  nawak = 1
+[update]
+	[update.configs]
+`))
+}
+
+func TestCheckConfigTypes(t *testing.T) {
+	assert.Equal(t, []string{
+		`cn.toml:3:3: error: flavors.huge.memory_size "4GG" is not a valid size (units: unknown unit GG in 4GG), e.g: 512MB or 4GB`,
+		`cn.toml:4:3: error: flavors.huge.cpu_count must be an integer, not the string "2"`,
+		`cn.toml:5:3: error: flavors.huge.privileged must be true or false, not the string "yes"`,
+		`cn.toml:6:3: error: flavors.huge.ttl "2 days" is not a valid duration, e.g: 90m or 2h`,
+		`cn.toml:7:3: error: flavors.huge.storage must be one of "", "memory", not "disk"`,
+		`cn.toml:8:3: error: flavors.huge.ceph.conf must be a table, not the integer 1`,
+		`cn.toml:10:3: error: lock.config.on_mismatch must be one of "warn", "fail", not "ignore"`,
+	}, checkTestConfig(t, `
+[flavors.huge]
+  memory_size = "4GG"
+  cpu_count = "2"
+  privileged = "yes"
+  ttl = "2 days"
+  storage = "disk"
+  ceph.conf = 1
+[lock.config]
+  on_mismatch = "ignore"
+`))
+}
+
+func TestCheckConfigMissingKeys(t *testing.T) {
+	assert.Equal(t, []string{
+		"cn.toml:2:1: error: flavors.tiny.privileged is missing, flavors.tiny doesn't inherit from flavors.default",
+		"cn.toml:9:1: error: the broken alias has no image_name, it can't be used",
+		`cn.toml:12:3: error: the empty alias has an empty image_name, it can't be used`,
+	}, checkTestConfig(t, `
+[flavors.tiny]
+  use_default = false
+  memory_size = "256MB"
+  cpu_count = 1
+  data = ""
+  size = ""
+  work_directory = "/tmp"
+[images.broken]
+  use_default = false
+[images.empty]
+  image_name = ""
+`))
+}
+
+func TestCheckConfigSyntax(t *testing.T) {
+	// The typo of a table header is not valid TOML
+	_, err := checkConfigContent("cn.toml", []byte("|flavors.test2]\n  cpu_count=2\n"))
+	assert.NotNil(t, err)
+}
+
+func TestCheckConfigTestFile(t *testing.T) {
+	problems, err := checkConfigFile(configFile)
+	assert.Nil(t, err)
+	assert.Nil(t, getConfigErrors(problems))
+}
+
+func TestDidYouMean(t *testing.T) {
+	known := []string{"memory_size", "cpu_count", "size"}
+	assert.Equal(t, ", did you mean memory_size?", didYouMean("memory_sise", known))
+	assert.Equal(t, ", did you mean cpu_count?", didYouMean("CPU_COUNTS", known))
+	assert.Equal(t, "", didYouMean("nawak", known))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+}
+
+func TestIsConfigCommand(t *testing.T) {
+	assert.True(t, isConfigCommand([]string{"config", "edit"}))
+	assert.True(t, isConfigCommand([]string{"--config", "cn.toml", "config", "validate"}))
+	assert.False(t, isConfigCommand([]string{"--config", "config"}))
+	assert.False(t, isConfigCommand([]string{"cluster", "ls"}))
+}
+
+func TestCheckConfigInheritance(t *testing.T) {
+	assert.Equal(t, []string{
+		"cn.toml:3:3: error: flavors.a inherits from itself: a -> b -> a",
+		"cn.toml:5:3: error: flavors.b inherits from itself: b -> a -> b",
+		"cn.toml:7:3: error: flavors.c inherits from hugee which doesn't exist, did you mean huge?",
+		"cn.toml:10:3: warning: flavors.d.use_default is ignored, flavors.d inherits from huge",
+	}, checkTestConfig(t, `
+[flavors.a]
+  inherits = "b"
+[flavors.b]
+  inherits = "a"
+[flavors.c]
+  inherits = "hugee"
+[flavors.d]
+  inherits = "huge"
+  use_default = false
+[flavors.e]
+  inherits = "d"
+`))
+}
+
+func TestCheckConfigLists(t *testing.T) {
+	assert.Equal(t, []string{
+		`cn.toml:3:3: error: flavors.rich.env "OSD_COUNT" is not a KEY=value variable`,
+		`cn.toml:4:3: error: flavors.rich.volumes must be a list of strings, not the string "/srv:/srv"`,
+		`cn.toml:5:3: error: flavors.rich.ports must be a list of strings, not a list holding the integer 9000`,
+		`cn.toml:6:3: error: flavors.rich.restart_policy "sometimes" is not one of no, always, unless-stopped, on-failure`,
+		`cn.toml:7:3: error: flavors.rich.shm_size "lots" is not a valid size (units: invalid lots), e.g: 512MB or 4GB`,
+	}, checkTestConfig(t, `
+[flavors.rich]
+  env = ["OSD_COUNT"]
+  volumes = "/srv:/srv"
+  ports = [9000]
+  restart_policy = "sometimes"
+  shm_size = "lots"
+  demo_daemons = ["mds"]
+`))
+}
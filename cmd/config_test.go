@@ -47,6 +47,16 @@ func TestDefaultConfig(t *testing.T) {
 	assert.Equal(t, true, getPrivileged("default"))
 	setPrivileged("default", false)
 	assert.Equal(t, "", getSize("default"))
+	assert.Equal(t, false, getTLS("default"))
+	assert.Equal(t, false, getTLS("test_nano_no_default")) // Flavors without use_default don't define tls
+	assert.Equal(t, false, getPrometheus("default"))
+	assert.Equal(t, false, getPrometheus("test_nano_no_default"))
+	assert.Equal(t, DEFAULTBINDADDRESS, getBindAddress("default"))
+	assert.Equal(t, DEFAULTBINDADDRESS, getBindAddress("test_nano_no_default"))
+	assert.Equal(t, "", getAdvertiseAddress("default"))
+	assert.Equal(t, "", getStorage("default"))
+	assert.Equal(t, "", getStorage("test_nano_no_default"))
+	assert.Equal(t, lockMismatchWarn, getLockMismatch())
 
 	defaultImageName := imageName
 	// Without any configuration file, the default should be satisfied
@@ -160,3 +170,95 @@ func TestMerge(t *testing.T) {
 	assert.Equal(t, false, isParameterExist(FLAVORS, "test_nano_no_default", "new_param"))
 	assert.Equal(t, true, isParameterExist(FLAVORS, "test_nano_default", "new_param"))
 }
+
+func TestGetConfigFlag(t *testing.T) {
+	assert.Equal(t, "a.toml", getConfigFlag([]string{"cluster", "ls", "--config", "a.toml"}))
+	assert.Equal(t, "b.toml", getConfigFlag([]string{"--config=b.toml", "version"}))
+	assert.Equal(t, "", getConfigFlag([]string{"s3", "put", "--", "--config"}))
+	assert.Equal(t, "", getConfigFlag([]string{"version"}))
+}
+
+func TestEncodeConfigValue(t *testing.T) {
+	value, err := encodeConfigValue("flavors.default.memory_size", "1GB")
+	assert.Nil(t, err)
+	assert.Equal(t, `"1GB"`, value)
+
+	// A new flavor gets the types of the default one
+	value, err = encodeConfigValue("flavors.nawak.cpu_count", "4")
+	assert.Nil(t, err)
+	assert.Equal(t, "4", value)
+	_, err = encodeConfigValue("flavors.nawak.cpu_count", "four")
+	assert.NotNil(t, err)
+	_, err = encodeConfigValue("flavors.default.privileged", "maybe")
+	assert.NotNil(t, err)
+	_, err = encodeConfigValue("flavors.default", "1")
+	assert.NotNil(t, err)
+
+	// Unknown keys are guessed
+	value, err = encodeConfigValue("nawak.config.enabled", "true")
+	assert.Nil(t, err)
+	assert.Equal(t, "true", value)
+	value, err = encodeConfigValue("nawak.config.name", "1.0")
+	assert.Nil(t, err)
+	assert.Equal(t, `"1.0"`, value)
+}
+
+func TestGetConfigSource(t *testing.T) {
+	fileConfig := readFileConfig(configFile)
+	assert.Equal(t, configSourceFile, getConfigSource(fileConfig, "flavors.test_nano_no_default.memory_size"))
+	assert.Equal(t, configSourceFile+" (flavors.default)", getConfigSource(fileConfig, "flavors.test_nano_default.new_param"))
+	assert.Equal(t, configSourceBuiltin, getConfigSource(fileConfig, "flavors.huge.memory_size"))
+	assert.NotContains(t, getConfigKeys("flavors.huge"), "flavors.huge.name")
+	assert.Contains(t, getConfigKeys("flavors.huge"), "flavors.huge.memory_size")
+}
+
+func TestFlavorInheritance(t *testing.T) {
+	chain, err := getFlavorChain("test_ci_small_tls_multiosd")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test_ci_small_tls_multiosd", "test_ci_small_tls", "test_ci_small", "default"}, chain)
+
+	// Every level brings its values, the nearest one wins
+	assert.Equal(t, int64(3), getCPUCount("test_ci_small_tls_multiosd"))
+	assert.Equal(t, "1GB", getMemorySize("test_ci_small_tls_multiosd"))
+	assert.Equal(t, true, getTLS("test_ci_small_tls_multiosd"))
+	assert.Equal(t, DEFAULTWORKDIRECTORY, getWorkDirectory("test_ci_small_tls_multiosd"))
+	assert.Equal(t, true, isParameterExist(FLAVORS, "test_ci_small_tls_multiosd", "new_param"))
+
+	// ceph.conf is merged key by key
+	cephConf := getCephConf("test_ci_small_tls_multiosd")
+	assert.Equal(t, int64(805306368), cephConf["osd_memory_target"])
+	assert.Equal(t, int64(20), cephConf["osd_pg_log_trim_min"])
+	assert.Equal(t, int64(10), cephConf["osd_max_pg_log_entries"])
+	assert.Equal(t, int64(10), getCephConf("test_ci_small")["osd_pg_log_trim_min"])
+
+	// A chain starting with use_default=false doesn't reach default
+	chain, err = getFlavorChain("test_nano_child")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test_nano_child", "test_nano_no_default"}, chain)
+	assert.Equal(t, "30GB", getSize("test_nano_child"))
+	assert.Equal(t, "/tmp/nano/", getWorkDirectory("test_nano_child"))
+	assert.Equal(t, false, isParameterExist(FLAVORS, "test_nano_child", "new_param"))
+	assert.Equal(t, map[string]interface{}{"osd_memory_target": int64(3841234556)}, getCephConf("test_nano_child"))
+
+	// The builtins keep inheriting from default
+	chain, err = getFlavorChain("huge")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"huge", "default"}, chain)
+}
+
+func TestFlavorInheritanceErrors(t *testing.T) {
+	viper.Set(FLAVORS+".test_cycle_a.inherits", "test_cycle_b")
+	viper.Set(FLAVORS+".test_cycle_b.inherits", "test_cycle_a")
+	_, err := getFlavorChain("test_cycle_a")
+	assert.EqualError(t, err, "flavor test_cycle_a has an inheritance cycle: test_cycle_a -> test_cycle_b -> test_cycle_a")
+
+	viper.Set(FLAVORS+".test_orphan.inherits", "nawak")
+	_, err = getFlavorChain("test_orphan")
+	assert.EqualError(t, err, "flavor test_orphan inherits from nawak which doesn't exist")
+}
+
+func TestGetConfigSourceInheritance(t *testing.T) {
+	fileConfig := readFileConfig(configFile)
+	assert.Equal(t, configSourceFile+" (flavors.test_ci_small)", getConfigSource(fileConfig, "flavors.test_ci_small_tls_multiosd.memory_size"))
+	assert.Equal(t, configSourceFile+" (flavors.test_nano_no_default)", getConfigSource(fileConfig, "flavors.test_nano_child.work_directory"))
+}
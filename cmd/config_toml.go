@@ -0,0 +1,283 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// tomlTableRegexp matches a table header, e.g: [flavors.huge]
+	tomlTableRegexp = regexp.MustCompile(`^\s*\[\s*([^\[\]]+?)\s*\]\s*(#.*)?$`)
+
+	// tomlKeyRegexp matches the beginning of a key/value line up to the value, e.g: memory_size = "4GB"
+	tomlKeyRegexp = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+|"[^"]*")(\s*=\s*)`)
+)
+
+// tomlDocument edits a TOML configuration file line by line, the comments and the layout are kept as is
+// Only the keys of the form 'key = value' on a single line can be edited
+type tomlDocument struct {
+	lines []string
+}
+
+// parseTOMLDocument splits a TOML file into lines
+func parseTOMLDocument(content string) *tomlDocument {
+	content = strings.TrimSuffix(content, "\n")
+	if len(content) == 0 {
+		return &tomlDocument{}
+	}
+	return &tomlDocument{lines: strings.Split(content, "\n")}
+}
+
+// String returns the content of the document
+func (d *tomlDocument) String() string {
+	if len(d.lines) == 0 {
+		return ""
+	}
+	return strings.Join(d.lines, "\n") + "\n"
+}
+
+// splitTOMLKey splits a dotted key into its table and its name, e.g: flavors.huge and memory_size
+func splitTOMLKey(key string) (string, string) {
+	i := strings.LastIndex(key, ".")
+	if i < 0 {
+		return "", key
+	}
+	return key[:i], key[i+1:]
+}
+
+// normalizeTOMLKey returns a key the way viper sees it, lower case without spaces around the dots
+func normalizeTOMLKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i := range parts {
+		parts[i] = strings.ToLower(strings.Trim(strings.TrimSpace(parts[i]), `"`))
+	}
+	return strings.Join(parts, ".")
+}
+
+// findTable returns the lines of a table: its header (-1 for the root table) and the first line of the next table
+func (d *tomlDocument) findTable(table string) (int, int, bool) {
+	start, found := -1, len(table) == 0
+	for i, line := range d.lines {
+		match := tomlTableRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if found {
+			return start, i, true
+		}
+		if normalizeTOMLKey(match[1]) == normalizeTOMLKey(table) {
+			start, found = i, true
+		}
+	}
+	return start, len(d.lines), found
+}
+
+// findKey returns the line of a key between two lines, -1 if it's not there
+func (d *tomlDocument) findKey(start int, end int, name string) int {
+	for i := start + 1; i < end; i++ {
+		match := tomlKeyRegexp.FindStringSubmatch(d.lines[i])
+		if match != nil && normalizeTOMLKey(match[2]) == normalizeTOMLKey(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the raw TOML value of a key and reports if it was found
+func (d *tomlDocument) Get(key string) (string, bool) {
+	table, name := splitTOMLKey(key)
+	start, end, found := d.findTable(table)
+	if !found {
+		return "", false
+	}
+	i := d.findKey(start, end, name)
+	if i < 0 {
+		return "", false
+	}
+	prefix := tomlKeyRegexp.FindString(d.lines[i])
+	value, _ := splitTOMLValue(d.lines[i][len(prefix):])
+	return value, true
+}
+
+// Set sets a key to a TOML encoded value, the table is created if needed
+func (d *tomlDocument) Set(key string, value string) error {
+	table, name := splitTOMLKey(key)
+	start, end, found := d.findTable(table)
+	if !found {
+		if len(d.lines) > 0 && len(strings.TrimSpace(d.lines[len(d.lines)-1])) > 0 {
+			d.lines = append(d.lines, "")
+		}
+		d.lines = append(d.lines, "["+table+"]", "  "+name+" = "+value)
+		return nil
+	}
+
+	if i := d.findKey(start, end, name); i >= 0 {
+		prefix := tomlKeyRegexp.FindString(d.lines[i])
+		oldValue, comment := splitTOMLValue(d.lines[i][len(prefix):])
+		if isMultilineTOMLValue(oldValue) {
+			return errors.New(key + " spans several lines, use 'cn config edit' to change it")
+		}
+		d.lines[i] = prefix + value + comment
+		return nil
+	}
+
+	// A new key goes after the last line of the table, before the blank lines separating the next one
+	last := end - 1
+	for last > start && len(strings.TrimSpace(d.lines[last])) == 0 {
+		last--
+	}
+	indent := "  "
+	for i := start + 1; i < end; i++ {
+		if match := tomlKeyRegexp.FindStringSubmatch(d.lines[i]); match != nil {
+			indent = match[1]
+			break
+		}
+	}
+	line := indent + name + " = " + value
+	d.lines = append(d.lines[:last+1], append([]string{line}, d.lines[last+1:]...)...)
+	return nil
+}
+
+// Unset removes a key and reports if it was there
+func (d *tomlDocument) Unset(key string) (bool, error) {
+	table, name := splitTOMLKey(key)
+	start, end, found := d.findTable(table)
+	if !found {
+		return false, nil
+	}
+	i := d.findKey(start, end, name)
+	if i < 0 {
+		return false, nil
+	}
+	prefix := tomlKeyRegexp.FindString(d.lines[i])
+	if value, _ := splitTOMLValue(d.lines[i][len(prefix):]); isMultilineTOMLValue(value) {
+		return false, errors.New(key + " spans several lines, use 'cn config edit' to remove it")
+	}
+	d.lines = append(d.lines[:i], d.lines[i+1:]...)
+	return true, nil
+}
+
+// HasTable reports if a table or one of its sub-tables is defined, e.g: [flavors.huge] or [flavors.huge.ceph.conf]
+func (d *tomlDocument) HasTable(table string) bool {
+	return d.findTableBlock(table) >= 0
+}
+
+// RemoveTable removes a table with its sub-tables and reports if something was removed
+func (d *tomlDocument) RemoveTable(table string) bool {
+	removed := false
+	for start := d.findTableBlock(table); start >= 0; start = d.findTableBlock(table) {
+		end := start + 1
+		for end < len(d.lines) && !tomlTableRegexp.MatchString(d.lines[end]) {
+			end++
+		}
+		d.lines = append(d.lines[:start], d.lines[end:]...)
+		removed = true
+	}
+	// Blank lines left at the end of the file are noise
+	for len(d.lines) > 0 && len(strings.TrimSpace(d.lines[len(d.lines)-1])) == 0 {
+		d.lines = d.lines[:len(d.lines)-1]
+	}
+	return removed
+}
+
+// findTableBlock returns the header line of a table or of one of its sub-tables, -1 if there is none
+func (d *tomlDocument) findTableBlock(table string) int {
+	table = normalizeTOMLKey(table)
+	for i, line := range d.lines {
+		match := tomlTableRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if name := normalizeTOMLKey(match[1]); name == table || strings.HasPrefix(name, table+".") {
+			return i
+		}
+	}
+	return -1
+}
+
+// encodeTOMLValue returns the TOML representation of a value read by viper
+func encodeTOMLValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []string:
+		var items []interface{}
+		for _, item := range v {
+			items = append(items, item)
+		}
+		return encodeTOMLValue(items)
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			encoded, err := encodeTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, encoded)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	}
+	return "", fmt.Errorf("unable to write %v in TOML", value)
+}
+
+// splitTOMLValue splits what follows the equal sign into the value and the trailing comment with its leading spaces
+func splitTOMLValue(rest string) (string, string) {
+	inString := byte(0)
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+		switch {
+		case inString != 0 && c == '\\' && inString == '"':
+			i++
+		case inString != 0 && c == inString:
+			inString = 0
+		case inString == 0 && (c == '"' || c == '\''):
+			inString = c
+		case inString == 0 && c == '#':
+			value := strings.TrimRight(rest[:i], " \t")
+			return value, rest[len(value):]
+		}
+	}
+	value := strings.TrimRight(rest, " \t")
+	return value, rest[len(value):]
+}
+
+// isMultilineTOMLValue reports if a value continues on the next lines, e.g: an array or a multi-line string
+func isMultilineTOMLValue(value string) bool {
+	if strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''") {
+		return strings.Count(value, value[:3]) < 2
+	}
+	return strings.Count(value, "[") > strings.Count(value, "]")
+}
@@ -0,0 +1,165 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTOMLDocument = `title = "nano" # the title
+
+[flavors]
+  [flavors.default]
+    memory_size = "512MB" # enough for a demo
+    cpu_count = 1
+
+    [flavors.default.ceph.conf]
+      osd_memory_target = 536870912
+
+  [flavors.huge]
+    memory_size = "4GB"
+    hosts = [
+      "a",
+    ]
+`
+
+func TestTOMLDocumentGet(t *testing.T) {
+	doc := parseTOMLDocument(testTOMLDocument)
+	value, found := doc.Get("title")
+	assert.True(t, found)
+	assert.Equal(t, `"nano"`, value)
+	value, found = doc.Get("flavors.default.memory_size")
+	assert.True(t, found)
+	assert.Equal(t, `"512MB"`, value)
+	value, found = doc.Get("Flavors.Default.Ceph.Conf.osd_memory_target")
+	assert.True(t, found)
+	assert.Equal(t, "536870912", value)
+	_, found = doc.Get("flavors.huge.cpu_count")
+	assert.False(t, found)
+	_, found = doc.Get("flavors.large.memory_size")
+	assert.False(t, found)
+	assert.Equal(t, testTOMLDocument, doc.String())
+}
+
+func TestTOMLDocumentSet(t *testing.T) {
+	doc := parseTOMLDocument(testTOMLDocument)
+
+	// The comment and the indentation are kept
+	assert.Nil(t, doc.Set("flavors.default.memory_size", `"1GB"`))
+	assert.Contains(t, doc.String(), "\n    memory_size = \"1GB\" # enough for a demo\n")
+
+	// A new key goes at the end of its table, before the sub-tables
+	assert.Nil(t, doc.Set("flavors.default.privileged", "true"))
+	assert.Contains(t, doc.String(), "    cpu_count = 1\n    privileged = true\n\n    [flavors.default.ceph.conf]")
+
+	// A new table goes at the end
+	assert.Nil(t, doc.Set("images.nautilus.image_name", `"ceph/daemon:latest-nautilus"`))
+	assert.Contains(t, doc.String(), "  ]\n\n[images.nautilus]\n  image_name = \"ceph/daemon:latest-nautilus\"\n")
+
+	// Root keys stay before the first table
+	assert.Nil(t, doc.Set("version", "2"))
+	assert.Contains(t, doc.String(), "title = \"nano\" # the title\nversion = 2\n\n[flavors]")
+
+	// A '#' in a string is not a comment
+	assert.Nil(t, doc.Set("title", `"nano # 1"`))
+	assert.Nil(t, doc.Set("title", `"nano"`))
+	assert.Contains(t, doc.String(), "title = \"nano\" # the title\n")
+
+	// Multi-line values are left to the editor
+	assert.NotNil(t, doc.Set("flavors.huge.hosts", `["b"]`))
+}
+
+func TestTOMLDocumentUnset(t *testing.T) {
+	doc := parseTOMLDocument(testTOMLDocument)
+	found, err := doc.Unset("flavors.default.cpu_count")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.NotContains(t, doc.String(), "cpu_count")
+
+	found, err = doc.Unset("flavors.default.cpu_count")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	_, err = doc.Unset("flavors.huge.hosts")
+	assert.NotNil(t, err)
+}
+
+func TestTOMLDocumentRemoveTable(t *testing.T) {
+	doc := parseTOMLDocument(testTOMLDocument)
+	assert.True(t, doc.HasTable("flavors.default"))
+	assert.True(t, doc.RemoveTable("flavors.default"))
+	assert.False(t, doc.HasTable("flavors.default"))
+	assert.False(t, doc.HasTable("flavors.default.ceph.conf"))
+	assert.NotContains(t, doc.String(), "osd_memory_target")
+	assert.NotContains(t, doc.String(), "enough for a demo")
+
+	// The following tables are kept
+	value, found := doc.Get("flavors.huge.memory_size")
+	assert.True(t, found)
+	assert.Equal(t, `"4GB"`, value)
+	assert.False(t, doc.RemoveTable("flavors.default"))
+}
+
+func TestEncodeTOMLValue(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		encoded string
+	}{
+		{"4GB", `"4GB"`},
+		{`a"b`, `"a\"b"`},
+		{true, "true"},
+		{2, "2"},
+		{int64(805306368), "805306368"},
+		{[]string{"A=1", "B=2"}, `["A=1", "B=2"]`},
+		{[]interface{}{}, "[]"},
+	}
+	for _, test := range tests {
+		encoded, err := encodeTOMLValue(test.value)
+		assert.Nil(t, err)
+		assert.Equal(t, test.encoded, encoded)
+	}
+	_, err := encodeTOMLValue(map[string]interface{}{})
+	assert.NotNil(t, err)
+}
+
+func TestSplitTOMLValue(t *testing.T) {
+	tests := []struct {
+		rest    string
+		value   string
+		comment string
+	}{
+		{`"4GB"`, `"4GB"`, ""},
+		{`"4GB"   # huge`, `"4GB"`, "   # huge"},
+		{`"a#b" # c`, `"a#b"`, " # c"},
+		{`'a\' # c`, `'a\'`, " # c"},
+		{`"a\"#" # c`, `"a\"#"`, " # c"},
+		{`2 `, `2`, " "},
+	}
+	for _, test := range tests {
+		value, comment := splitTOMLValue(test.rest)
+		assert.Equal(t, test.value, value, test.rest)
+		assert.Equal(t, test.comment, comment, test.rest)
+	}
+}
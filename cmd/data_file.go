@@ -0,0 +1,178 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ceph/cn/pkg/blockdev"
+)
+
+// dataFilePrefix marks a data store backed by an image file, e.g: file:/srv/nano.img
+const dataFilePrefix = "file:"
+
+// isDataFile reports if the underlying storage is an image file
+func isDataFile(data string) bool {
+	return strings.HasPrefix(data, dataFilePrefix)
+}
+
+// getDataFilePath returns the absolute path of the image file of a file: data store
+func getDataFilePath(data string) (string, error) {
+	path := strings.TrimPrefix(data, dataFilePrefix)
+	if len(path) == 0 {
+		return "", errors.New("the data file path is empty, use file:/path/to/image")
+	}
+	return filepath.Abs(path)
+}
+
+// prepareDataFile creates a sparse image file of the given size or checks an existing one is unused
+// It reports if the file was created, cn only removes the files it created
+func prepareDataFile(path string, size string) (bool, error) {
+	var sizeInBytes int64
+	if len(size) > 0 {
+		var err error
+		if sizeInBytes, err = parseBytes(size); err != nil || sizeInBytes <= 0 {
+			return false, fmt.Errorf("wrong size %s. Please refer to https://en.wikipedia.org/wiki/Byte", size)
+		}
+	}
+
+	finfo, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if sizeInBytes == 0 {
+			return false, fmt.Errorf("%s doesn't exist, use --size to create it", path)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		// Truncating doesn't allocate anything, the file only grows as Ceph writes
+		if err := f.Truncate(sizeInBytes); err != nil {
+			os.Remove(path)
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Reusing a file is fine as long as nothing was written on it
+	if !finfo.Mode().IsRegular() {
+		return false, fmt.Errorf("%s is not a regular file", path)
+	}
+	report, err := blockdev.Inspect(path)
+	if err != nil {
+		return false, err
+	}
+	if !report.IsEmpty() {
+		return false, fmt.Errorf("%s has %s, doing nothing", path, report.String())
+	}
+	if sizeInBytes > finfo.Size() {
+		return false, os.Truncate(path, sizeInBytes)
+	}
+	return false, nil
+}
+
+// checkDataFileUser ensures cn runs as root, a data file is attached to a loop device
+func checkDataFileUser() {
+	meUserName, meID := whoAmI()
+	if meID != "0" {
+		log.Fatal("Hey " + meUserName + "! Run me as 'root' when using a data file, it's attached to a loop device.")
+	}
+}
+
+// attachDataFile prepares the image file of a file: data store and attaches it to a loop device
+// It returns the path of the file, the loop device and whether the file was created
+func attachDataFile(data string, size string) (string, string, bool) {
+	checkDataFileUser()
+	path, err := getDataFilePath(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	created, err := prepareDataFile(path, size)
+	if err != nil {
+		log.Fatal(err)
+	}
+	loopDevice, err := blockdev.AttachLoop(path)
+	if err != nil {
+		if created {
+			os.Remove(path)
+		}
+		log.Fatal(err)
+	}
+	log.Println("Attached " + path + " to " + loopDevice)
+	return path, loopDevice, created
+}
+
+// ensureDataFileAttached attaches the image file of a cluster again, e.g: after a reboot of the host
+// The same loop device is used as the container refers to it
+func ensureDataFileAttached(containerName string) {
+	dataFile := dockerInspect(containerName, "data_file")
+	loopDevice := dockerInspect(containerName, "loop_device")
+	if len(dataFile) == 0 || len(loopDevice) == 0 {
+		return
+	}
+	attached, err := blockdev.IsLoopBackedBy(loopDevice, dataFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if attached {
+		return
+	}
+	if err := blockdev.AttachLoopAt(loopDevice, dataFile); err != nil {
+		log.Fatal("Unable to attach " + dataFile + " to " + loopDevice + " again: " + err.Error())
+	}
+}
+
+// removeDataFile detaches the image file of a cluster and removes it if cn created it, it's wiped otherwise
+func removeDataFile(loopDevice string, dataFile string, created bool) {
+	if len(dataFile) == 0 {
+		return
+	}
+	if len(loopDevice) > 0 {
+		attached, err := blockdev.IsLoopBackedBy(loopDevice, dataFile)
+		if err == nil && attached {
+			err = blockdev.DetachLoop(loopDevice)
+		}
+		if err != nil {
+			log.Println("Unable to detach " + loopDevice + ": " + err.Error())
+		}
+	}
+	// A file that existed before the cluster is kept, wiped for the next cluster to use it
+	if !created {
+		if _, err := os.Stat(dataFile); err == nil {
+			wipeSignatures(dataFile)
+		}
+		return
+	}
+	if err := os.Remove(dataFile); err != nil && !os.IsNotExist(err) {
+		log.Println("Something went wrong while removing " + dataFile + ", you need to remove it manually.")
+		log.Println(err)
+	}
+}
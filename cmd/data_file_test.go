@@ -0,0 +1,107 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDataFilePath(t *testing.T) {
+	assert.True(t, isDataFile("file:/srv/nano.img"))
+	assert.False(t, isDataFile("/srv/nano"))
+
+	path, err := getDataFilePath("file:/srv/nano.img")
+	assert.Nil(t, err)
+	assert.Equal(t, "/srv/nano.img", path)
+
+	_, err = getDataFilePath("file:")
+	assert.NotNil(t, err)
+}
+
+func TestPrepareDataFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cn-data-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nano.img")
+
+	// A size is needed to create the file
+	_, err = prepareDataFile(path, "")
+	assert.NotNil(t, err)
+	_, err = prepareDataFile(path, "10 apples")
+	assert.NotNil(t, err)
+
+	created, err := prepareDataFile(path, "10MB")
+	assert.Nil(t, err)
+	assert.True(t, created)
+	finfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10*1024*1024), finfo.Size())
+
+	// An unused file can be reused and grown, it isn't cn's to remove then
+	created, err = prepareDataFile(path, "20MB")
+	assert.Nil(t, err)
+	assert.False(t, created)
+	finfo, _ = os.Stat(path)
+	assert.Equal(t, int64(20*1024*1024), finfo.Size())
+
+	// A file used by a previous OSD is refused
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0)
+	f.WriteAt([]byte("bluestore block device\n"), 0)
+	f.Close()
+	_, err = prepareDataFile(path, "")
+	assert.NotNil(t, err)
+
+	_, err = prepareDataFile(dir, "")
+	assert.NotNil(t, err)
+}
+
+func TestRemoveDataFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cn-data-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nano.img")
+	content := make([]byte, 1024*1024)
+	copy(content, "bluestore block device\n")
+	ioutil.WriteFile(path, content, 0600)
+
+	// A file the user brought is kept, wiped for the next cluster to use it
+	removeDataFile("", path, false)
+	_, err = os.Stat(path)
+	assert.Nil(t, err)
+	created, err := prepareDataFile(path, "")
+	assert.Nil(t, err)
+	assert.False(t, created)
+
+	removeDataFile("", path, true)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,227 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// hostInterface is a network interface as seen by the endpoint resolver
+type hostInterface struct {
+	Name  string
+	Flags net.Flags
+	IPs   []net.IP
+}
+
+// virtualInterfacePrefixes are interface names clients are unlikely to reach us on
+// (container bridges, hypervisors, VPNs)
+var virtualInterfacePrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vboxnet", "vmnet", "cni", "flannel", "cali",
+	"podman", "lxc", "lxd", "tun", "tap", "utun", "wg", "ppp", "zt",
+}
+
+// listHostInterfaces returns the network interfaces of the machine running cn
+// This is a variable so tests can replace it
+var listHostInterfaces = func() ([]hostInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list network interfaces. %s", err)
+	}
+
+	var hostInterfaces []hostInterface
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to determine addresses of interface %s. %s", iface.Name, err)
+		}
+		hi := hostInterface{Name: iface.Name, Flags: iface.Flags}
+		for _, addr := range addrs {
+			ip, _, err := net.ParseCIDR(addr.String())
+			if err != nil {
+				continue
+			}
+			hi.IPs = append(hi.IPs, ip)
+		}
+		hostInterfaces = append(hostInterfaces, hi)
+	}
+	return hostInterfaces, nil
+}
+
+// isVirtualInterface reports if an interface looks like a bridge, a tunnel or a VPN
+func isVirtualInterface(name string) bool {
+	for _, prefix := range virtualInterfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopbackHost reports if a host name or an IP address designates the local machine
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// getDockerDaemonHost returns the host of a remote Docker daemon from a DOCKER_HOST value
+// An empty string is returned when the daemon is local (unix socket, npipe or loopback)
+func getDockerDaemonHost(dockerHost string) string {
+	if len(dockerHost) == 0 {
+		return ""
+	}
+	u, err := url.Parse(dockerHost)
+	if err != nil {
+		return ""
+	}
+	switch u.Scheme {
+	case "tcp", "http", "https", "ssh":
+	default:
+		return ""
+	}
+	host := u.Hostname()
+	if len(host) == 0 || isLoopbackHost(host) {
+		return ""
+	}
+	return host
+}
+
+// preferredIP returns the first global IPv4 address of a list, then the first global IPv6 one
+func preferredIP(ips []net.IP) net.IP {
+	for _, ip := range ips {
+		if ip.To4() != nil && ip.IsGlobalUnicast() {
+			return ip
+		}
+	}
+	for _, ip := range ips {
+		if ip.To4() == nil && ip.IsGlobalUnicast() {
+			return ip
+		}
+	}
+	return nil
+}
+
+// resolveAdvertiseAddress returns the address clients should use to reach a cluster
+// advertiseAddress can be an IP, an interface name or a host name, if empty it's guessed from:
+//  1. the host of a remote Docker daemon
+//  2. the bind address when ports are not published on all interfaces
+//  3. the first physical interface, IPv4 first
+//
+// Ports published on loopback can't be reached from an advertised address or through a remote daemon, that's an error
+func resolveAdvertiseAddress(advertiseAddress string, bindAddress string, dockerHost string) (string, error) {
+	interfaces, err := listHostInterfaces()
+	if err != nil {
+		return "", err
+	}
+	bindIP := net.ParseIP(bindAddress)
+
+	if len(advertiseAddress) > 0 {
+		address := advertiseAddress
+		if ip := net.ParseIP(advertiseAddress); ip != nil {
+			address = ip.String()
+		}
+		for _, iface := range interfaces {
+			if iface.Name == advertiseAddress {
+				ip := preferredIP(iface.IPs)
+				if ip == nil {
+					return "", fmt.Errorf("interface %s has no usable address to advertise", advertiseAddress)
+				}
+				address = ip.String()
+				break
+			}
+		}
+		// Neither an IP nor an interface is kept as a host name
+		if bindIP != nil && bindIP.IsLoopback() && !isLoopbackHost(address) {
+			return "", fmt.Errorf("the ports are only published on %s, they can't be reached from %s: use --bind-address 0.0.0.0 or the advertised IP", bindAddress, address)
+		}
+		return address, nil
+	}
+
+	// A remote Docker daemon publishes the ports on its own interfaces
+	if daemonHost := getDockerDaemonHost(dockerHost); len(daemonHost) > 0 {
+		if bindIP != nil && bindIP.IsLoopback() {
+			return "", fmt.Errorf("the Docker daemon %s is remote while the ports are only published on its %s, use --bind-address 0.0.0.0 to reach them", daemonHost, bindAddress)
+		}
+		return daemonHost, nil
+	}
+
+	// Ports bound to a specific address are only reachable there
+	if bindIP != nil && !bindIP.IsUnspecified() {
+		return bindIP.String(), nil
+	}
+
+	var candidates []net.IP
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || isVirtualInterface(iface.Name) {
+			continue
+		}
+		candidates = append(candidates, iface.IPs...)
+	}
+	if ip := preferredIP(candidates); ip != nil {
+		return ip.String(), nil
+	}
+
+	// Nothing better was found, loopback always works locally
+	if bindIP != nil && bindIP.To4() == nil {
+		return "::1", nil
+	}
+	return "127.0.0.1", nil
+}
+
+// buildURL returns an URL for a host that can be an IPv6 address
+func buildURL(scheme string, host string, port string) string {
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// getClusterEndpointHost returns the address recorded when the cluster was created
+// Clusters created by older cn versions publish their ports on all interfaces
+func getClusterEndpointHost(containerName string) string {
+	if advertiseAddress := dockerInspect(containerName, "advertise_address"); len(advertiseAddress) > 0 {
+		return advertiseAddress
+	}
+	advertiseAddress, err := resolveAdvertiseAddress("", "0.0.0.0", os.Getenv("DOCKER_HOST"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return advertiseAddress
+}
+
+// getHostIPs returns all the addresses of the machine running cn
+func getHostIPs() []net.IP {
+	var ips []net.IP
+	interfaces, err := listHostInterfaces()
+	if err != nil {
+		return ips
+	}
+	for _, iface := range interfaces {
+		ips = append(ips, iface.IPs...)
+	}
+	return ips
+}
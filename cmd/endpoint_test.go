@@ -0,0 +1,152 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func patchHostInterfaces(interfaces []hostInterface) func() {
+	bck := listHostInterfaces
+	listHostInterfaces = func() ([]hostInterface, error) {
+		return interfaces, nil
+	}
+	return func() {
+		listHostInterfaces = bck
+	}
+}
+
+var testHostInterfaces = []hostInterface{
+	{Name: "lo", Flags: net.FlagUp | net.FlagLoopback, IPs: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}},
+	{Name: "docker0", Flags: net.FlagUp, IPs: []net.IP{net.ParseIP("172.17.0.1")}},
+	{Name: "eth0", Flags: net.FlagUp, IPs: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::10"), net.ParseIP("192.168.1.10")}},
+	{Name: "tun0", Flags: net.FlagUp, IPs: []net.IP{net.ParseIP("10.8.0.254")}},
+	{Name: "eth1", Flags: 0, IPs: []net.IP{net.ParseIP("192.168.2.10")}},
+}
+
+func TestGetDockerDaemonHost(t *testing.T) {
+	assert.Equal(t, "", getDockerDaemonHost(""))
+	assert.Equal(t, "", getDockerDaemonHost("unix:///var/run/docker.sock"))
+	assert.Equal(t, "", getDockerDaemonHost("npipe:////./pipe/docker_engine"))
+	assert.Equal(t, "", getDockerDaemonHost("tcp://127.0.0.1:2375"))
+	assert.Equal(t, "", getDockerDaemonHost("tcp://localhost:2375"))
+	assert.Equal(t, "docker.example.com", getDockerDaemonHost("tcp://docker.example.com:2376"))
+	assert.Equal(t, "10.0.0.5", getDockerDaemonHost("ssh://user@10.0.0.5"))
+	assert.Equal(t, "2001:db8::5", getDockerDaemonHost("tcp://[2001:db8::5]:2375"))
+}
+
+func TestResolveAdvertiseAddress(t *testing.T) {
+	defer patchHostInterfaces(testHostInterfaces)()
+
+	tests := []struct {
+		advertise  string
+		bind       string
+		dockerHost string
+		expected   string
+	}{
+		// Explicit IPs are kept as-is
+		{"10.1.2.3", "0.0.0.0", "", "10.1.2.3"},
+		{"2001:db8::42", "::", "", "2001:db8::42"},
+		// Interface names are resolved, IPv4 first
+		{"eth0", "0.0.0.0", "", "192.168.1.10"},
+		{"docker0", "0.0.0.0", "", "172.17.0.1"},
+		// Anything else is a host name
+		{"nano.example.com", "0.0.0.0", "", "nano.example.com"},
+		// A remote daemon wins over the local interfaces
+		{"", "0.0.0.0", "tcp://docker.example.com:2376", "docker.example.com"},
+		{"", "0.0.0.0", "unix:///var/run/docker.sock", "192.168.1.10"},
+		// A specific bind address is the only reachable one
+		{"", "127.0.0.1", "", "127.0.0.1"},
+		{"", "::1", "", "::1"},
+		// All interfaces: skip loopback, bridges, tunnels and down interfaces
+		{"", "0.0.0.0", "", "192.168.1.10"},
+		{"", "::", "", "192.168.1.10"},
+	}
+	for _, test := range tests {
+		address, err := resolveAdvertiseAddress(test.advertise, test.bind, test.dockerHost)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, address, "advertise=%q bind=%q docker_host=%q", test.advertise, test.bind, test.dockerHost)
+	}
+}
+
+func TestResolveAdvertiseAddressLoopbackBind(t *testing.T) {
+	defer patchHostInterfaces(testHostInterfaces)()
+
+	// Ports published on loopback are only reachable from loopback
+	tests := []struct {
+		advertise  string
+		bind       string
+		dockerHost string
+		expected   string
+	}{
+		{"10.1.2.3", "127.0.0.1", "", ""},
+		{"eth0", "127.0.0.1", "", ""},
+		{"nano.example.com", "::1", "", ""},
+		{"", "127.0.0.1", "tcp://docker.example.com:2376", ""},
+		{"localhost", "127.0.0.1", "", "localhost"},
+		{"127.0.0.1", "127.0.0.1", "", "127.0.0.1"},
+		{"", "127.0.0.1", "tcp://127.0.0.1:2375", "127.0.0.1"},
+	}
+	for _, test := range tests {
+		address, err := resolveAdvertiseAddress(test.advertise, test.bind, test.dockerHost)
+		if len(test.expected) == 0 {
+			assert.NotNil(t, err, "advertise=%q bind=%q docker_host=%q", test.advertise, test.bind, test.dockerHost)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, address, "advertise=%q bind=%q docker_host=%q", test.advertise, test.bind, test.dockerHost)
+	}
+}
+
+func TestResolveAdvertiseAddressIPv6Only(t *testing.T) {
+	defer patchHostInterfaces([]hostInterface{
+		{Name: "lo", Flags: net.FlagUp | net.FlagLoopback, IPs: []net.IP{net.ParseIP("::1")}},
+		{Name: "eth0", Flags: net.FlagUp, IPs: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::10")}},
+	})()
+
+	address, err := resolveAdvertiseAddress("", "::", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8::10", address)
+	assert.Equal(t, "https://[2001:db8::10]:8000", buildURL("https", address, "8000"))
+}
+
+func TestResolveAdvertiseAddressNoInterface(t *testing.T) {
+	// Used to panic when no IPv4 interface was found
+	defer patchHostInterfaces([]hostInterface{
+		{Name: "lo", Flags: net.FlagUp | net.FlagLoopback, IPs: []net.IP{net.ParseIP("127.0.0.1")}},
+	})()
+
+	address, err := resolveAdvertiseAddress("", "0.0.0.0", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", address)
+
+	address, err = resolveAdvertiseAddress("", "::", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "::1", address)
+
+	_, err = resolveAdvertiseAddress("lo", "0.0.0.0", "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,194 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	// cephFormat is the output format passed to the ceph and radosgw-admin commands
+	cephFormat string
+)
+
+// cliClusterExec is the Cobra CLI call
+func cliClusterExec() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "exec [cluster] -- COMMAND [ARG...]",
+		Short: "Run a command inside a given cluster and return its exit code",
+		Args:  cobra.MinimumNArgs(2),
+		Run:   execNano,
+		Example: "cn cluster exec mycluster -- ceph osd pool ls \n" +
+			"cat policy.json | cn cluster exec mycluster -- sh -c 'cat > /tmp/policy.json' \n",
+		DisableFlagsInUseLine: true,
+	}
+
+	return cmd
+}
+
+// cliCephNano is the Cobra CLI call
+func cliCephNano() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ceph [cluster] -- [ARG...]",
+		Short: "Run the ceph command inside a given cluster",
+		Args:  cobra.MinimumNArgs(2),
+		Run:   cephNano,
+		Example: "cn ceph mycluster -- -s \n" +
+			"cn ceph mycluster --format json -- osd df \n",
+	}
+	cmd.Flags().StringVar(&cephFormat, "format", "", "Output format passed to the command (plain, json, json-pretty, xml...)")
+
+	return cmd
+}
+
+// cliRadosgwAdminNano is the Cobra CLI call
+func cliRadosgwAdminNano() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "radosgw-admin [cluster] -- [ARG...]",
+		Short: "Run the radosgw-admin command inside a given cluster",
+		Args:  cobra.MinimumNArgs(2),
+		Run:   radosgwAdminNano,
+		Example: "cn radosgw-admin mycluster -- user info --uid nano \n" +
+			"cn radosgw-admin mycluster --format json -- bucket stats \n",
+	}
+	cmd.Flags().StringVar(&cephFormat, "format", "", "Output format passed to the command (json, xml...)")
+
+	return cmd
+}
+
+// execNano runs a command inside a cluster and exits with its exit code
+func execNano(cmd *cobra.Command, args []string) {
+	runInCluster(args[0], args[1:])
+}
+
+// cephNano runs the ceph command inside a cluster and exits with its exit code
+func cephNano(cmd *cobra.Command, args []string) {
+	runInCluster(args[0], withFormat(append([]string{"ceph"}, args[1:]...), cephFormat))
+}
+
+// radosgwAdminNano runs the radosgw-admin command inside a cluster and exits with its exit code
+func radosgwAdminNano(cmd *cobra.Command, args []string) {
+	runInCluster(args[0], withFormat(append([]string{"radosgw-admin"}, args[1:]...), cephFormat))
+}
+
+// withFormat appends the --format flag given to cn, unless the command already has one
+func withFormat(c []string, format string) []string {
+	if len(format) == 0 {
+		return c
+	}
+	for _, arg := range c[1:] {
+		if arg == "--format" || arg == "-f" || strings.HasPrefix(arg, "--format=") {
+			return c
+		}
+	}
+	return append(c, "--format", format)
+}
+
+// runInCluster runs a command inside a running cluster with our own stdin, stdout and stderr
+func runInCluster(containerNameToShow string, c []string) {
+	containerName := containerNamePrefix + containerNameToShow
+	notExistCheck(containerName)
+	notRunningCheck(containerName)
+
+	os.Exit(runWithStdio(func(stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+		return execContainerWithStdio(containerName, c, stdin, stdout, stderr)
+	}, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// runWithStdio runs a command with the given stdin, stdout and stderr and returns the exit code cn must exit with
+// A terminal on stdin means nothing is piped to us, it's not forwarded as commands waiting for input would hang
+func runWithStdio(run func(stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error), stdin *os.File, stdout io.Writer, stderr io.Writer) int {
+	var input io.Reader
+	if !terminal.IsTerminal(int(stdin.Fd())) {
+		input = stdin
+	}
+
+	exitCode, err := run(input, stdout, stderr)
+	if err != nil {
+		log.New(stderr, "", log.LstdFlags).Println(err)
+		return 1
+	}
+	return exitCode
+}
+
+// execContainerWithStdio execs a command inside the container without a TTY
+// stdout and stderr are kept separated and the exit code of the command is returned
+// stdin is forwarded to the command unless it's nil
+func execContainerWithStdio(containerName string, cmd []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+	optionsCreate := types.ExecConfig{
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	}
+
+	response, err := getDocker().ContainerExecCreate(ctx, containerName, optionsCreate)
+	if err != nil {
+		return -1, err
+	}
+
+	optionsAttach := types.ExecConfig{
+		Detach: false,
+		Tty:    false,
+	}
+	connection, err := getDocker().ContainerExecAttach(ctx, response.ID, optionsAttach)
+	if err != nil {
+		return -1, err
+	}
+	defer connection.Close()
+
+	if stdin != nil {
+		go func() {
+			io.Copy(connection.Conn, stdin)
+			// Let the command know there is nothing left to read
+			connection.CloseWrite()
+		}()
+	}
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, connection.Reader); err != nil {
+		return -1, err
+	}
+
+	// The exit code is only known once Docker notices the process is gone
+	for i := 0; i < 50; i++ {
+		inspect, err := getDocker().ContainerExecInspect(ctx, response.ID)
+		if err != nil {
+			return -1, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return -1, errors.New("unable to get the exit code of " + strings.Join(cmd, " "))
+}
@@ -0,0 +1,87 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithFormat(t *testing.T) {
+	tests := []struct {
+		c        []string
+		format   string
+		expected []string
+	}{
+		{[]string{"ceph", "-s"}, "", []string{"ceph", "-s"}},
+		{[]string{"ceph", "-s"}, "json", []string{"ceph", "-s", "--format", "json"}},
+		// The format the user gave to the command wins
+		{[]string{"ceph", "osd", "df", "--format", "xml"}, "json", []string{"ceph", "osd", "df", "--format", "xml"}},
+		{[]string{"ceph", "osd", "df", "--format=xml"}, "json", []string{"ceph", "osd", "df", "--format=xml"}},
+		{[]string{"ceph", "-f", "plain", "-s"}, "json", []string{"ceph", "-f", "plain", "-s"}},
+		{[]string{"radosgw-admin", "bucket", "stats", "--format=xml"}, "json", []string{"radosgw-admin", "bucket", "stats", "--format=xml"}},
+		{[]string{"radosgw-admin", "user", "info"}, "json", []string{"radosgw-admin", "user", "info", "--format", "json"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, withFormat(test.c, test.format))
+	}
+}
+
+func TestRunWithStdio(t *testing.T) {
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdinWriter.Write([]byte("policy"))
+	stdinWriter.Close()
+	defer stdinReader.Close()
+
+	// The exit code, stdout and stderr of the command are kept apart
+	var stdout, stderr bytes.Buffer
+	exitCode := runWithStdio(func(stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+		if assert.NotNil(t, stdin, "a piped stdin must be forwarded") {
+			input, _ := ioutil.ReadAll(stdin)
+			stdout.Write(input)
+		}
+		stderr.Write([]byte("warning"))
+		return 3, nil
+	}, stdinReader, &stdout, &stderr)
+	assert.Equal(t, 3, exitCode)
+	assert.Equal(t, "policy", stdout.String())
+	assert.Equal(t, "warning", stderr.String())
+
+	// cn fails when the command can't be run
+	stdout.Reset()
+	stderr.Reset()
+	exitCode = runWithStdio(func(stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, error) {
+		return -1, errors.New("no such container")
+	}, stdinReader, &stdout, &stderr)
+	assert.Equal(t, 1, exitCode)
+	assert.Contains(t, stderr.String(), "no such container")
+}
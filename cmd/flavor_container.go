@@ -0,0 +1,304 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
+	"github.com/spf13/viper"
+)
+
+var (
+	// reservedEnvs are the variables cn sets and reads back, a flavor can't change them
+	reservedEnvs = []string{"RGW_FRONTEND_PORT", "SREE_PORT", "DEMO_DAEMONS"}
+
+	// reservedLabels are the labels cn keeps its metadata in, see dockerInspect()
+	reservedLabels = []string{"flavor", "rgw_port", "bind_address", "advertise_address", "prometheus_port",
+		"data_file", "data_file_created", "loop_device", "partition", "storage", "expires_at", "tls", "image_alias"}
+
+	// restartPolicies are the restart policies of Docker, on-failure accepts a maximum retry count
+	restartPolicies = []string{"no", "always", "unless-stopped", "on-failure"}
+)
+
+// flavorContainerSettings are the container settings a flavor adds to the ones of cn
+type flavorContainerSettings struct {
+	env           []string
+	labels        map[string]string
+	binds         []string
+	exposedPorts  nat.PortSet
+	portBindings  nat.PortMap
+	ulimits       []*units.Ulimit
+	shmSize       int64
+	restartPolicy container.RestartPolicy
+	demoDaemons   []string
+}
+
+// getFlavorStrings returns a list item of a flavor, e.g: env
+func getFlavorStrings(containerFlavor string, name string) []string {
+	// Flavors not inheriting from default may not define it
+	if !isParameterExist(FLAVORS, containerFlavor, name) {
+		return nil
+	}
+	return viper.GetStringSlice(FLAVORS + "." + containerFlavor + "." + name)
+}
+
+// getFlavorContainerSettings reads and checks the container settings of a flavor
+func getFlavorContainerSettings(containerFlavor string) (*flavorContainerSettings, error) {
+	settings := &flavorContainerSettings{labels: make(map[string]string)}
+	wrap := func(name string, err error) error {
+		return fmt.Errorf("%s in flavor %s: %s", name, containerFlavor, err)
+	}
+
+	for _, env := range getFlavorStrings(containerFlavor, "env") {
+		if _, _, err := parseFlavorEnv(env); err != nil {
+			return nil, wrap("env", err)
+		}
+		settings.env = append(settings.env, env)
+	}
+
+	for _, label := range getFlavorStrings(containerFlavor, "labels") {
+		key, value, err := parseFlavorLabel(label)
+		if err != nil {
+			return nil, wrap("labels", err)
+		}
+		settings.labels[key] = value
+	}
+
+	for _, volume := range getFlavorStrings(containerFlavor, "volumes") {
+		if err := checkFlavorVolume(volume); err != nil {
+			return nil, wrap("volumes", err)
+		}
+		settings.binds = append(settings.binds, volume)
+	}
+
+	exposedPorts, portBindings, err := nat.ParsePortSpecs(getFlavorStrings(containerFlavor, "ports"))
+	if err != nil {
+		return nil, wrap("ports", err)
+	}
+	settings.exposedPorts, settings.portBindings = exposedPorts, portBindings
+
+	for _, ulimit := range getFlavorStrings(containerFlavor, "ulimits") {
+		parsed, err := units.ParseUlimit(ulimit)
+		if err != nil {
+			return nil, wrap("ulimits", err)
+		}
+		settings.ulimits = append(settings.ulimits, parsed)
+	}
+
+	if isParameterExist(FLAVORS, containerFlavor, "shm_size") {
+		if shmSize := getStringFromConfig(FLAVORS, containerFlavor, "shm_size"); len(shmSize) > 0 {
+			if settings.shmSize, err = parseBytes(shmSize); err != nil {
+				return nil, wrap("shm_size", err)
+			}
+		}
+	}
+
+	if isParameterExist(FLAVORS, containerFlavor, "restart_policy") {
+		if policy := getStringFromConfig(FLAVORS, containerFlavor, "restart_policy"); len(policy) > 0 {
+			if settings.restartPolicy, err = parseRestartPolicy(policy); err != nil {
+				return nil, wrap("restart_policy", err)
+			}
+		}
+	}
+
+	for _, daemon := range getFlavorStrings(containerFlavor, "demo_daemons") {
+		if err := checkDemoDaemon(daemon); err != nil {
+			return nil, wrap("demo_daemons", err)
+		}
+		settings.demoDaemons = append(settings.demoDaemons, daemon)
+	}
+	return settings, nil
+}
+
+// apply merges the settings of a flavor into the container configuration built by runContainer()
+// The published ports of a flavor go on the bind address of the cluster unless they have one
+func (s *flavorContainerSettings) apply(config *container.Config, hostConfig *container.HostConfig, hostBindAddress string) error {
+	for _, env := range s.env {
+		config.Env = setEnv(config.Env, env)
+	}
+	if len(s.demoDaemons) > 0 {
+		config.Env = addDemoDaemons(config.Env, s.demoDaemons)
+	}
+
+	for key, value := range s.labels {
+		config.Labels[key] = value
+	}
+
+	hostConfig.Binds = append(hostConfig.Binds, s.binds...)
+
+	for port := range s.exposedPorts {
+		if _, used := config.ExposedPorts[port]; used {
+			return fmt.Errorf("port %s is already published by cn", port)
+		}
+		config.ExposedPorts[port] = struct{}{}
+	}
+	for port, bindings := range s.portBindings {
+		for _, binding := range bindings {
+			if len(binding.HostIP) == 0 {
+				binding.HostIP = hostBindAddress
+			}
+			hostConfig.PortBindings[port] = append(hostConfig.PortBindings[port], binding)
+		}
+	}
+
+	hostConfig.Ulimits = append(hostConfig.Ulimits, s.ulimits...)
+	if s.shmSize > 0 {
+		hostConfig.ShmSize = s.shmSize
+	}
+	if len(s.restartPolicy.Name) > 0 {
+		hostConfig.RestartPolicy = s.restartPolicy
+	}
+	return nil
+}
+
+// parseFlavorEnv splits a KEY=value environment variable
+func parseFlavorEnv(env string) (string, string, error) {
+	parts := strings.SplitN(env, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return "", "", fmt.Errorf("%q is not a KEY=value variable", env)
+	}
+	if isStringInSlice(parts[0], reservedEnvs) {
+		if parts[0] == "DEMO_DAEMONS" {
+			return "", "", fmt.Errorf("%s is set by cn, use demo_daemons to add daemons", parts[0])
+		}
+		return "", "", fmt.Errorf("%s is set by cn and can't be changed", parts[0])
+	}
+	return parts[0], parts[1], nil
+}
+
+// checkFlavorEnv ensures an environment variable looks like KEY=value
+func checkFlavorEnv(env string) error {
+	_, _, err := parseFlavorEnv(env)
+	return err
+}
+
+// parseFlavorLabel splits a key=value container label
+func parseFlavorLabel(label string) (string, string, error) {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return "", "", fmt.Errorf("%q is not a key=value label", label)
+	}
+	if isStringInSlice(parts[0], reservedLabels) {
+		return "", "", fmt.Errorf("the %s label is used by cn", parts[0])
+	}
+	return parts[0], parts[1], nil
+}
+
+// checkFlavorLabel ensures a label looks like key=value
+func checkFlavorLabel(label string) error {
+	_, _, err := parseFlavorLabel(label)
+	return err
+}
+
+// checkFlavorVolume ensures a bind mount looks like /host/path:/container/path[:options]
+func checkFlavorVolume(volume string) error {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 2 || len(parts) > 3 || len(parts[0]) == 0 {
+		return fmt.Errorf("%q is not a /host/path:/container/path[:options] volume", volume)
+	}
+	if !path.IsAbs(parts[1]) {
+		return fmt.Errorf("the container path of %q must be absolute", volume)
+	}
+	return nil
+}
+
+// checkFlavorPort ensures a published port looks like [ip:]host_port:container_port[/protocol]
+func checkFlavorPort(port string) error {
+	_, _, err := nat.ParsePortSpecs([]string{port})
+	return err
+}
+
+// checkFlavorUlimit ensures a ulimit looks like name=soft[:hard]
+func checkFlavorUlimit(ulimit string) error {
+	_, err := units.ParseUlimit(ulimit)
+	return err
+}
+
+// checkDemoDaemon ensures a daemon can be added to the comma separated DEMO_DAEMONS
+func checkDemoDaemon(daemon string) error {
+	if len(daemon) == 0 || strings.ContainsAny(daemon, ", =") {
+		return fmt.Errorf("%q is not a daemon name, e.g: mds", daemon)
+	}
+	return nil
+}
+
+// parseRestartPolicy parses a Docker restart policy, e.g: on-failure:3
+func parseRestartPolicy(policy string) (container.RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+	restartPolicy := container.RestartPolicy{Name: parts[0]}
+	if !isStringInSlice(parts[0], restartPolicies) {
+		return restartPolicy, fmt.Errorf("%q is not one of %s", policy, strings.Join(restartPolicies, ", "))
+	}
+	if len(parts) == 2 {
+		retries, err := strconv.Atoi(parts[1])
+		if parts[0] != "on-failure" || err != nil || retries < 0 {
+			return restartPolicy, fmt.Errorf("%q is not a valid restart policy, only on-failure takes a maximum retry count", policy)
+		}
+		restartPolicy.MaximumRetryCount = retries
+	}
+	return restartPolicy, nil
+}
+
+// checkRestartPolicy ensures a restart policy is valid, an empty one is Docker's default
+func checkRestartPolicy(policy string) error {
+	if len(policy) == 0 {
+		return nil
+	}
+	_, err := parseRestartPolicy(policy)
+	return err
+}
+
+// setEnv sets a KEY=value variable, the existing one is replaced in place
+func setEnv(envs []string, env string) []string {
+	key := strings.SplitN(env, "=", 2)[0]
+	for i := range envs {
+		if strings.HasPrefix(envs[i], key+"=") {
+			envs[i] = env
+			return envs
+		}
+	}
+	return append(envs, env)
+}
+
+// addDemoDaemons adds daemons to the DEMO_DAEMONS variable, the ones already there are kept once
+func addDemoDaemons(envs []string, daemons []string) []string {
+	for i := range envs {
+		if !strings.HasPrefix(envs[i], "DEMO_DAEMONS=") {
+			continue
+		}
+		current := strings.Split(strings.TrimPrefix(envs[i], "DEMO_DAEMONS="), ",")
+		for _, daemon := range daemons {
+			if !isStringInSlice(daemon, current) {
+				current = append(current, daemon)
+			}
+		}
+		envs[i] = "DEMO_DAEMONS=" + strings.Join(current, ",")
+	}
+	return envs
+}
@@ -0,0 +1,105 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlavorContainerSettings(t *testing.T) {
+	settings, err := getFlavorContainerSettings("test_rich")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &container.Config{
+		Env:          []string{"RGW_FRONTEND_PORT=8000", "SREE_PORT=5000", "CEPH_PUBLIC_NETWORK=0.0.0.0/0", "DEMO_DAEMONS=mon,mgr,osd,rgw"},
+		Labels:       map[string]string{"flavor": "test_rich"},
+		ExposedPorts: nat.PortSet{"8000/tcp": {}},
+	}
+	hostConfig := &container.HostConfig{
+		Binds:        []string{"/usr/share/ceph-nano:/tmp/"},
+		PortBindings: nat.PortMap{"8000/tcp": {{HostIP: "127.0.0.1", HostPort: "8000"}}},
+	}
+	assert.Nil(t, settings.apply(config, hostConfig, "127.0.0.1"))
+
+	// The positions read by dockerInspect() are kept
+	assert.Equal(t, []string{"RGW_FRONTEND_PORT=8000", "SREE_PORT=5000", "CEPH_PUBLIC_NETWORK=10.0.0.0/8", "DEMO_DAEMONS=mon,mgr,osd,rgw,mds", "OSD_COUNT=2"}, config.Env)
+	assert.Equal(t, map[string]string{"flavor": "test_rich", "team": "storage"}, config.Labels)
+	assert.Equal(t, []string{"/usr/share/ceph-nano:/tmp/", "/srv/seed:/seed:ro"}, hostConfig.Binds)
+	assert.Contains(t, config.ExposedPorts, nat.Port("9000/tcp"))
+	assert.Equal(t, []nat.PortBinding{{HostIP: "127.0.0.1", HostPort: "9000"}}, hostConfig.PortBindings["9000/tcp"])
+	assert.Equal(t, []nat.PortBinding{{HostIP: "10.0.0.1", HostPort: "7000"}}, hostConfig.PortBindings["7000/udp"])
+	if assert.Len(t, hostConfig.Ulimits, 1) {
+		assert.Equal(t, "nofile", hostConfig.Ulimits[0].Name)
+		assert.Equal(t, int64(4096), hostConfig.Ulimits[0].Soft)
+		assert.Equal(t, int64(8192), hostConfig.Ulimits[0].Hard)
+	}
+	assert.Equal(t, int64(256<<20), hostConfig.ShmSize)
+	assert.Equal(t, container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, hostConfig.RestartPolicy)
+
+	// Flavors without extra settings leave the container as is
+	settings, err = getFlavorContainerSettings("test_nano_no_default")
+	assert.Nil(t, err)
+	assert.Empty(t, settings.env)
+	assert.Equal(t, container.RestartPolicy{}, settings.restartPolicy)
+}
+
+func TestFlavorContainerSettingsConflicts(t *testing.T) {
+	settings := &flavorContainerSettings{exposedPorts: nat.PortSet{"8000/tcp": {}}}
+	config := &container.Config{ExposedPorts: nat.PortSet{"8000/tcp": {}}}
+	assert.NotNil(t, settings.apply(config, &container.HostConfig{}, "127.0.0.1"))
+
+	assert.NotNil(t, checkFlavorEnv("SREE_PORT=1"))
+	assert.NotNil(t, checkFlavorEnv("DEMO_DAEMONS=mds"))
+	assert.NotNil(t, checkFlavorEnv("NOVALUE"))
+	assert.Nil(t, checkFlavorEnv("EMPTY="))
+	assert.NotNil(t, checkFlavorLabel("rgw_port=1"))
+	assert.Nil(t, checkFlavorLabel("com.example.team=storage"))
+}
+
+func TestCheckFlavorItems(t *testing.T) {
+	assert.Nil(t, checkFlavorVolume("/srv:/srv"))
+	assert.Nil(t, checkFlavorVolume("cache:/var/cache:z"))
+	assert.NotNil(t, checkFlavorVolume("/srv"))
+	assert.NotNil(t, checkFlavorVolume("/srv:relative"))
+	assert.Nil(t, checkFlavorPort("127.0.0.1:9000:9000/tcp"))
+	assert.NotNil(t, checkFlavorPort("9000:nawak"))
+	assert.Nil(t, checkFlavorUlimit("memlock=-1:-1"))
+	assert.NotNil(t, checkFlavorUlimit("nawak=1"))
+	assert.NotNil(t, checkDemoDaemon("mds,nfs"))
+	assert.Nil(t, checkRestartPolicy(""))
+	assert.Nil(t, checkRestartPolicy("unless-stopped"))
+	assert.NotNil(t, checkRestartPolicy("always:3"))
+	assert.NotNil(t, checkRestartPolicy("sometimes"))
+}
+
+func TestSetEnv(t *testing.T) {
+	assert.Equal(t, []string{"A=1", "DEMO_DAEMONS=mon,osd,mds"}, addDemoDaemons([]string{"A=1", "DEMO_DAEMONS=mon,osd"}, []string{"osd", "mds"}))
+	assert.Equal(t, []string{"A=2", "AB=1"}, setEnv([]string{"A=1", "AB=1"}, "A=2"))
+	assert.Equal(t, []string{"A=1", "B=1"}, setEnv([]string{"A=1"}, "B=1"))
+}
@@ -24,10 +24,10 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 
 	"github.com/apcera/termtables"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var (
@@ -42,6 +42,10 @@ func init() {
 	cmdFlavors.AddCommand(
 		cliFlavorsList(),
 		cliFlavorsShow(),
+		cliFlavorsCreate(),
+		cliFlavorsUpdate(),
+		cliFlavorsClone(),
+		cliFlavorsRemove(),
 	)
 }
 
@@ -79,13 +83,18 @@ func listFlavors(cmd *cobra.Command, args []string) {
 
 func showFlavors(cmd *cobra.Command, args []string) {
 	flavorName := args[0]
-	flavor := FLAVORS + "." + flavorName
 	if isEntryExist(FLAVORS, flavorName) {
 		if flavorName == "default" {
 			PrettyPrint(getDefaultParameters())
 		} else {
-			//PrettyPrint(viper.AllKeys())
-			PrettyPrint(viper.Get(flavor))
+			// The resolved parameters are reported, with the flavors they come from
+			parameters := getFlavorParameters(flavorName)
+			chain, err := getFlavorChain(flavorName)
+			if err != nil {
+				log.Fatal(err)
+			}
+			parameters["inheritance"] = chain
+			PrettyPrint(parameters)
 		}
 	} else {
 		// The flavor doesn't exist, let's report an empty structure
@@ -0,0 +1,329 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// flavorSettings are the KEY=VALUE items passed with --set
+	flavorSettings []string
+
+	// flavorInherits is the parent flavor passed with --inherits
+	flavorInherits string
+
+	// itemNameRegexp matches the names of flavors and image aliases, they are bare TOML keys
+	itemNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// cliFlavorsCreate is the Cobra CLI call
+func cliFlavorsCreate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create FLAVOR",
+		Short: "Create a flavor in the configuration file",
+		Args:  cobra.ExactArgs(1),
+		Run:   createFlavor,
+		Example: "cn flavors create ci-small --set memory_size=1GB --set cpu_count=2\n" +
+			"cn flavors create ci-small-tls --inherits ci-small --set tls=true\n" +
+			"cn flavors create ci-mds --set demo_daemons=mds --set env=OSD_COUNT=2 --set ceph.conf.osd_pool_default_size=1\n",
+	}
+	cmd.Flags().StringArrayVar(&flavorSettings, "set", nil, "Item of the flavor as KEY=VALUE, repeat it for lists like env")
+	cmd.Flags().StringVar(&flavorInherits, "inherits", "", "Flavor to inherit from instead of default")
+	return cmd
+}
+
+// cliFlavorsUpdate is the Cobra CLI call
+func cliFlavorsUpdate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "update FLAVOR",
+		Short: "Change the items of a flavor in the configuration file",
+		Args:  cobra.ExactArgs(1),
+		Run:   updateFlavor,
+		Example: "cn flavors update ci-small --set memory_size=2GB\n" +
+			"cn flavors update huge --set cpu_count=4\n",
+	}
+	cmd.Flags().StringArrayVar(&flavorSettings, "set", nil, "Item of the flavor as KEY=VALUE, repeat it for lists like env")
+	cmd.Flags().StringVar(&flavorInherits, "inherits", "", "Flavor to inherit from instead of default")
+	return cmd
+}
+
+// cliFlavorsClone is the Cobra CLI call
+func cliFlavorsClone() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "clone FLAVOR NEW_FLAVOR",
+		Short:                 "Copy a flavor to a new one in the configuration file",
+		Args:                  cobra.ExactArgs(2),
+		Run:                   cloneFlavor,
+		Example:               "cn flavors clone huge my-huge\n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+// cliFlavorsRemove is the Cobra CLI call
+func cliFlavorsRemove() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "rm FLAVOR",
+		Short:                 "Remove a flavor from the configuration file",
+		Args:                  cobra.ExactArgs(1),
+		Run:                   removeFlavor,
+		Example:               "cn flavors rm ci-small\n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+func createFlavor(cmd *cobra.Command, args []string) {
+	flavorName := args[0]
+	if !itemNameRegexp.MatchString(flavorName) {
+		log.Fatal("Wrong flavor name " + flavorName + ", only letters, digits, '_' and '-' are allowed.")
+	}
+	if isEntryExist(FLAVORS, flavorName) {
+		log.Fatal("The flavor " + flavorName + " already exists, use 'cn flavors update' to change it.")
+	}
+	values, err := parseFlavorSettings(flavorName, flavorSettings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// An empty flavor still needs a table, it's a copy of default
+	if len(values) == 0 && len(flavorInherits) == 0 {
+		values["use_default"] = "true"
+	}
+	writeFlavor(flavorName, values)
+	fmt.Println("Flavor " + flavorName + " created in " + getWritableConfigFile())
+}
+
+func updateFlavor(cmd *cobra.Command, args []string) {
+	flavorName := args[0]
+	if !isEntryExist(FLAVORS, flavorName) {
+		log.Fatal("The flavor " + flavorName + " doesn't exist, use 'cn flavors create' to add it.")
+	}
+	if len(flavorSettings) == 0 && len(flavorInherits) == 0 {
+		log.Fatal("Nothing to update, use --set or --inherits.")
+	}
+	values, err := parseFlavorSettings(flavorName, flavorSettings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeFlavor(flavorName, values)
+	fmt.Println("Flavor " + flavorName + " updated in " + getWritableConfigFile())
+}
+
+func cloneFlavor(cmd *cobra.Command, args []string) {
+	source, flavorName := args[0], args[1]
+	if !isEntryExist(FLAVORS, source) {
+		log.Fatal("The flavor " + source + " doesn't exist.")
+	}
+	if !itemNameRegexp.MatchString(flavorName) {
+		log.Fatal("Wrong flavor name " + flavorName + ", only letters, digits, '_' and '-' are allowed.")
+	}
+	if isEntryExist(FLAVORS, flavorName) {
+		log.Fatal("The flavor " + flavorName + " already exists.")
+	}
+	values := make(map[string]string)
+	for key, value := range getFlavorOwnParameters(source) {
+		encoded, err := encodeTOMLValue(value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		values[key] = encoded
+	}
+	if len(values) == 0 {
+		values["use_default"] = "true"
+	}
+	writeFlavor(flavorName, values)
+	fmt.Println("Flavor " + source + " cloned to " + flavorName + " in " + getWritableConfigFile())
+}
+
+func removeFlavor(cmd *cobra.Command, args []string) {
+	flavorName := args[0]
+	if flavorName == "default" {
+		log.Fatal("The default flavor can't be removed, all the flavors inherit from it.")
+	}
+	if !isEntryExist(FLAVORS, flavorName) {
+		log.Fatal("The flavor " + flavorName + " doesn't exist.")
+	}
+	if len(configurationFile) == 0 {
+		log.Fatal("There is no configuration file, " + flavorName + " is a builtin flavor.")
+	}
+
+	var children []string
+	for flavor := range getItemsFromGroup(FLAVORS) {
+		if isParameterExist(FLAVORS, flavor, "inherits") && getFlavorParent(flavor) == flavorName {
+			children = append(children, flavor)
+		}
+	}
+	if len(children) > 0 {
+		sort.Strings(children)
+		log.Fatal("The flavor " + flavorName + " can't be removed, " + strings.Join(children, ", ") + " inherit(s) from it.")
+	}
+	if clusters := getClustersWithLabel("flavor", flavorName); len(clusters) > 0 {
+		log.Fatal("The flavor " + flavorName + " can't be removed, it is used by the cluster(s) " + strings.Join(clusters, ", ") + ".")
+	}
+
+	removeConfigTable(FLAVORS, flavorName, isStringInSlice(flavorName, builtinFlavors))
+}
+
+// removeConfigTable removes a flavor or an image alias from the configuration file
+func removeConfigTable(group string, item string, builtin bool) {
+	removed := false
+	err := updateConfigFile(configurationFile, func(doc *tomlDocument) error {
+		removed = doc.RemoveTable(group + "." + item)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case removed && builtin:
+		fmt.Println(item + " removed from " + configurationFile + ", its builtin values apply again")
+	case removed:
+		fmt.Println(item + " removed from " + configurationFile)
+	case builtin:
+		log.Fatal(item + " is builtin, " + configurationFile + " doesn't override it.")
+	default:
+		log.Fatal(item + " is not defined in " + configurationFile + ".")
+	}
+}
+
+// writeFlavor writes the encoded items of a flavor in the configuration file
+func writeFlavor(flavorName string, values map[string]string) {
+	if len(flavorInherits) > 0 {
+		if !isEntryExist(FLAVORS, flavorInherits) {
+			log.Fatal("The flavor " + flavorInherits + " doesn't exist.")
+		}
+		values["inherits"] = fmt.Sprintf("%q", flavorInherits)
+	}
+
+	// The items come before the sub-tables like ceph.conf, so the flavor has a single [flavors.NAME] table
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		iNested, jNested := strings.Contains(keys[i], "."), strings.Contains(keys[j], ".")
+		if iNested != jNested {
+			return jNested
+		}
+		return keys[i] < keys[j]
+	})
+
+	err := updateConfigFile(getWritableConfigFile(), func(doc *tomlDocument) error {
+		for _, key := range keys {
+			if err := doc.Set(FLAVORS+"."+flavorName+"."+key, values[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseFlavorSettings returns the TOML values of KEY=VALUE items
+// The keys of the lists can be repeated, e.g: env=A=1 env=B=2
+func parseFlavorSettings(flavorName string, settings []string) (map[string]string, error) {
+	values := make(map[string]string)
+	lists := make(map[string][]string)
+	var known []string
+	for key := range configSchema[FLAVORS] {
+		known = append(known, key)
+	}
+
+	for _, setting := range settings {
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("%q is not a KEY=VALUE item", setting)
+		}
+		key, value := strings.ToLower(parts[0]), parts[1]
+		spec, found := configSchema[FLAVORS][key]
+		if !found && !strings.HasPrefix(key, "ceph.conf.") {
+			return nil, fmt.Errorf("unknown flavor item %s%s", key, didYouMean(key, known))
+		}
+		if spec.kind == configList {
+			if spec.check != nil {
+				if err := spec.check(value); err != nil {
+					return nil, fmt.Errorf("%s: %s", key, err)
+				}
+			}
+			lists[key] = append(lists[key], value)
+			continue
+		}
+		encoded, err := encodeConfigValue(FLAVORS+"."+flavorName+"."+key, value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = encoded
+	}
+
+	for key, list := range lists {
+		encoded, err := encodeTOMLValue(list)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = encoded
+	}
+	return values, nil
+}
+
+// getFlavorOwnParameters returns the parameters a flavor doesn't get from its parent
+// A flavor made of them resolves like the original one
+func getFlavorOwnParameters(flavor string) map[string]interface{} {
+	own := getFlavorParameters(flavor)
+	delete(own, "name")
+	chain, _ := getFlavorChain(flavor)
+	if len(chain) < 2 {
+		return own
+	}
+	parent := getFlavorParameters(chain[1])
+	for key, value := range own {
+		if key != "inherits" && reflect.DeepEqual(parent[key], value) {
+			delete(own, key)
+		}
+	}
+	return own
+}
+
+// getClustersWithLabel returns the clusters labelled with a value, e.g: flavor=huge
+func getClustersWithLabel(label string, value string) []string {
+	var clusters []string
+	for _, containerName := range getNanoContainers() {
+		inspect, err := getDocker().ContainerInspect(ctx, containerName)
+		if err != nil {
+			continue
+		}
+		if inspect.Config != nil && inspect.Config.Labels[label] == value {
+			clusters = append(clusters, strings.TrimPrefix(containerName, containerNamePrefix))
+		}
+	}
+	return clusters
+}
@@ -0,0 +1,67 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFlavorSettings(t *testing.T) {
+	values, err := parseFlavorSettings("test_new", []string{
+		"memory_size=1GB",
+		"cpu_count=2",
+		"tls=true",
+		"env=OSD_COUNT=2",
+		"env=DEBUG=1",
+		"ceph.conf.osd_memory_target=805306368",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"memory_size":                 `"1GB"`,
+		"cpu_count":                   "2",
+		"tls":                         "true",
+		"env":                         `["OSD_COUNT=2", "DEBUG=1"]`,
+		"ceph.conf.osd_memory_target": "805306368",
+	}, values)
+
+	for _, setting := range []string{"memory_size", "=1GB", "memory_sise=1GB", "cpu_count=two", "env=RGW_FRONTEND_PORT=80"} {
+		_, err = parseFlavorSettings("test_new", []string{setting})
+		assert.NotNil(t, err, setting)
+	}
+}
+
+func TestGetFlavorOwnParameters(t *testing.T) {
+	// Only what differs from the parent is kept
+	assert.Equal(t, map[string]interface{}{
+		"inherits":                      "test_ci_small",
+		"tls":                           true,
+		"ceph.conf.osd_pg_log_trim_min": int64(20),
+	}, getFlavorOwnParameters("test_ci_small_tls"))
+
+	// A flavor not inheriting from default keeps everything
+	own := getFlavorOwnParameters("test_nano_no_default")
+	assert.Equal(t, false, own["use_default"])
+	assert.NotContains(t, own, "name")
+}
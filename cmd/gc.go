@@ -0,0 +1,262 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apcera/termtables"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// gcDryRun only reports what the garbage collection would do
+	gcDryRun bool
+
+	// gcPurge purges collected clusters instead of stopping them
+	gcPurge bool
+
+	// gcIdleHours collects running clusters without S3 requests for that many hours, 0 disables it
+	gcIdleHours int
+)
+
+// gcReportLine is one action taken by the garbage collection
+type gcReportLine struct {
+	action string
+	target string
+	reason string
+	result string
+}
+
+// cliClusterGC is the Cobra CLI call
+func cliClusterGC() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Stop or purge expired and idle clusters, remove leftovers of deleted clusters",
+		Args:  cobra.NoArgs,
+		Run:   gcNano,
+		Example: "cn cluster gc --dry-run \n" +
+			"cn cluster gc --purge \n" +
+			"cn cluster gc --idle-hours 12 \n",
+	}
+	cmd.Flags().SortFlags = false
+	cmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "Only report what would be done")
+	cmd.Flags().BoolVar(&gcPurge, "purge", false, "Purge collected clusters instead of stopping them. DANGEROUS!")
+	cmd.Flags().IntVar(&gcIdleHours, "idle-hours", 0, "Also collect running clusters with no S3 request for that many hours (0 disables it)")
+
+	return cmd
+}
+
+// gcNano garbage collects clusters
+func gcNano(cmd *cobra.Command, args []string) {
+	var report []gcReportLine
+
+	for _, containerName := range getNanoContainers() {
+		if line, collect := gcCluster(containerName); collect {
+			report = append(report, line)
+		}
+	}
+	report = append(report, gcOrphans()...)
+
+	if len(report) == 0 {
+		log.Println("Nothing to collect.")
+		return
+	}
+
+	table := termtables.CreateTable()
+	table.AddHeaders("ACTION", "TARGET", "REASON", "RESULT")
+	for _, line := range report {
+		table.AddRow(line.action, line.target, line.reason, line.result)
+	}
+	fmt.Println(table.Render())
+}
+
+// gcCluster stops or purges a cluster if it's expired or idle
+func gcCluster(containerName string) (gcReportLine, bool) {
+	containerNameToShow := containerName[len(containerNamePrefix):]
+	inspect, err := getDocker().ContainerInspect(ctx, containerName)
+	if err != nil {
+		return gcReportLine{"none", containerNameToShow, "inspect failed", err.Error()}, true
+	}
+
+	reason := ""
+	if expiresAt, ok := inspect.Config.Labels["expires_at"]; ok {
+		expiry, err := time.Parse(time.RFC3339, expiresAt)
+		if err == nil && time.Now().After(expiry) {
+			reason = "expired at " + expiresAt
+		}
+	}
+
+	running := inspect.State != nil && inspect.State.Running
+	if len(reason) == 0 && gcIdleHours > 0 && running {
+		lastActivity := getLastS3Activity(containerName, inspect.State.StartedAt)
+		if time.Since(lastActivity) > time.Duration(gcIdleHours)*time.Hour {
+			reason = "idle since " + lastActivity.UTC().Format(time.RFC3339)
+		}
+	}
+
+	if len(reason) == 0 {
+		return gcReportLine{}, false
+	}
+
+	line := gcReportLine{target: containerNameToShow, reason: reason, result: "done"}
+	if gcPurge {
+		line.action = "purge"
+	} else if running {
+		line.action = "stop"
+	} else {
+		// Already stopped and we are not asked to purge
+		return gcReportLine{}, false
+	}
+
+	if gcDryRun {
+		line.result = "dry-run"
+		return line, true
+	}
+
+	if gcPurge {
+		removeContainer(containerName)
+	} else {
+		timeout := 5 * time.Second
+		if err := getDocker().ContainerStop(ctx, containerName, &timeout); err != nil {
+			line.result = err.Error()
+		}
+	}
+	return line, true
+}
+
+// gcOrphans removes what deleted clusters left behind: data directories, volumes and certificates
+func gcOrphans() []gcReportLine {
+	var report []gcReportLine
+
+	existing := make(map[string]bool)
+	for _, containerName := range getNanoContainers() {
+		existing[containerName[len(containerNamePrefix):]] = true
+	}
+
+	for _, record := range readClusterRecords() {
+		if existing[record.Name] {
+			continue
+		}
+		if len(record.Data) > 0 {
+			if _, err := os.Stat(record.Data); err == nil {
+				report = append(report, gcRemove("remove data", record.Data, record.Name, func() error {
+					return os.RemoveAll(record.Data)
+				}))
+			}
+		}
+		if len(record.DataFile) > 0 {
+			if _, err := os.Stat(record.DataFile); err == nil {
+				action := "remove data file"
+				if !record.DataFileCreated {
+					action = "detach data file"
+				}
+				report = append(report, gcRemove(action, record.DataFile, record.Name, func() error {
+					removeDataFile(record.LoopDevice, record.DataFile, record.DataFileCreated)
+					return nil
+				}))
+			}
+		}
+		for _, volume := range record.Volumes {
+			volume := volume
+			if _, err := getDocker().VolumeInspect(ctx, volume); err != nil {
+				continue
+			}
+			report = append(report, gcRemove("remove volume", volume, record.Name, func() error {
+				return getDocker().VolumeRemove(ctx, volume, true)
+			}))
+		}
+		report = append(report, gcRemove("forget", makeClusterRecordPath(record.Name), record.Name, func() error {
+			return os.Remove(makeClusterRecordPath(record.Name))
+		}))
+	}
+
+	// Certificates issued for clusters that don't exist anymore
+	pkiClusters, _ := ioutil.ReadDir(makePKIPath("clusters"))
+	for _, pkiCluster := range pkiClusters {
+		name := pkiCluster.Name()
+		if existing[name] {
+			continue
+		}
+		report = append(report, gcRemove("remove certificate", getClusterPKIPath(name), name, func() error {
+			return os.RemoveAll(getClusterPKIPath(name))
+		}))
+	}
+	return report
+}
+
+// gcRemove runs a removal unless we are in dry-run mode and reports it
+func gcRemove(action string, target string, clusterName string, remove func() error) gcReportLine {
+	line := gcReportLine{action, target, "cluster " + clusterName + " is gone", "done"}
+	if gcDryRun {
+		line.result = "dry-run"
+	} else if err := remove(); err != nil {
+		line.result = err.Error()
+	}
+	return line
+}
+
+// getLastS3Activity returns the time of the last S3 request served by a cluster
+// If there was none, the start time of the container is returned
+func getLastS3Activity(containerName string, startedAt string) time.Time {
+	lastActivity, err := time.Parse(time.RFC3339Nano, startedAt)
+	if err != nil {
+		lastActivity = time.Now()
+	}
+
+	c := []string{"sh", "-c", "grep 'req done' " + getRGWLogPath(containerName) + " | tail -n 1"}
+	output := strings.TrimSpace(execContainer(containerName, c))
+	if len(output) == 0 {
+		return lastActivity
+	}
+	requestTime, err := parseCephLogTimestamp(output)
+	if err == nil && requestTime.After(lastActivity) {
+		return requestTime
+	}
+	return lastActivity
+}
+
+// parseCephLogTimestamp returns the time of a Ceph log line
+// Ceph moved from '2019-03-01 10:23:45.123456' to '2021-03-01T10:23:45.123+0000' in Octopus
+// The old format has no zone, it's the one of the container which is UTC, not the one of the host
+func parseCephLogTimestamp(line string) (time.Time, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return time.Time{}, errors.New("empty log line")
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05.000-0700", fields[0]); err == nil {
+		return t, nil
+	}
+	if len(fields) > 1 {
+		if t, err := time.Parse("2006-01-02 15:04:05.999999", fields[0]+" "+fields[1]); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unable to find a timestamp in: " + line)
+}
@@ -0,0 +1,47 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCephLogTimestamp(t *testing.T) {
+	// Octopus and later
+	ts, err := parseCephLogTimestamp("2021-03-01T10:23:45.123+0000 7f2b1a7fc700  1 ====== req done req=0x7f2b1a7f2650 op status=0 http_status=200 ======")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 3, 1, 10, 23, 45, 123000000, time.UTC).Unix(), ts.Unix())
+
+	// Luminous, Mimic and Nautilus
+	ts, err = parseCephLogTimestamp("2019-03-01 10:23:45.123456 7f2b1a7fc700  1 ====== req done req=0x7f2b1a7f2650 op status=0 http_status=200 ======")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2019, 3, 1, 10, 23, 45, 123456000, time.UTC), ts)
+
+	_, err = parseCephLogTimestamp("")
+	assert.NotNil(t, err)
+	_, err = parseCephLogTimestamp("cat: /var/log/ceph/client.rgw.log: No such file or directory")
+	assert.NotNil(t, err)
+}
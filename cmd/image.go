@@ -39,5 +39,9 @@ func init() {
 		CliImageUpdate(),
 		CliImageList(),
 		cliShowAliases(),
+		cliImageSave(),
+		cliImageLoad(),
+		cliImageLock(),
+		cmdImageAlias,
 	)
 }
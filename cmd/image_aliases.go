@@ -24,11 +24,32 @@ package cmd
 
 import (
 	"fmt"
+	"log"
+	"strings"
 
 	"github.com/apcera/termtables"
+	"github.com/ceph/cn/pkg/registry"
 	"github.com/spf13/cobra"
 )
 
+var (
+	// aliasForce replaces an existing alias
+	aliasForce bool
+
+	cmdImageAlias = &cobra.Command{
+		Use:   "alias [command]",
+		Short: "Add or remove image aliases in the configuration file",
+		Args:  cobra.NoArgs,
+	}
+)
+
+func init() {
+	cmdImageAlias.AddCommand(
+		cliImageAliasAdd(),
+		cliImageAliasRemove(),
+	)
+}
+
 func cliShowAliases() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:                   "show-aliases",
@@ -49,5 +70,93 @@ func listAliases(cmd *cobra.Command, args []string) {
 			table.AddRow(image, getImageName(image))
 		}
 	}
+	// Aliases loaded from a bundle come after the configured ones
+	for _, image := range readLoadedImages() {
+		if len(image.Alias) > 0 && !isEntryExist(IMAGES, image.Alias) {
+			table.AddRow(image.Alias, image.Name)
+		}
+	}
 	fmt.Println(table.Render())
 }
+
+// cliImageAliasAdd is the Cobra CLI call
+func cliImageAliasAdd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add ALIAS IMAGE",
+		Short: "Add an image alias to the configuration file",
+		Args:  cobra.ExactArgs(2),
+		Run:   addAlias,
+		Example: "cn image alias add nautilus ceph/daemon:latest-nautilus\n" +
+			"cn image alias add --force mimic ceph/daemon:v3.2.1-stable-3.2-mimic-centos-7\n",
+	}
+	cmd.Flags().BoolVar(&aliasForce, "force", false, "Replace the alias if it already exists")
+	return cmd
+}
+
+// cliImageAliasRemove is the Cobra CLI call
+func cliImageAliasRemove() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "rm ALIAS",
+		Short:                 "Remove an image alias from the configuration file",
+		Args:                  cobra.ExactArgs(1),
+		Run:                   removeAlias,
+		Example:               "cn image alias rm nautilus\n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+func addAlias(cmd *cobra.Command, args []string) {
+	alias, image := args[0], args[1]
+	if !itemNameRegexp.MatchString(alias) {
+		log.Fatal("Wrong alias name " + alias + ", only letters, digits, '_' and '-' are allowed.")
+	}
+	if alias == "default" {
+		log.Fatal("The default image can't be an alias, change images.default.image_name with 'cn config set'.")
+	}
+	if _, err := registry.ParseReference(image); err != nil {
+		log.Fatal("Wrong image name " + image + ": " + err.Error())
+	}
+	if isEntryExist(IMAGES, alias) && !aliasForce {
+		log.Fatal("The alias " + alias + " already exists (" + getImageNameFromConfig(alias) + "), use --force to replace it.")
+	}
+
+	err := updateConfigFile(getWritableConfigFile(), func(doc *tomlDocument) error {
+		return doc.Set(IMAGES+"."+alias+".image_name", fmt.Sprintf("%q", image))
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Alias " + alias + " of " + image + " added to " + getWritableConfigFile())
+}
+
+func removeAlias(cmd *cobra.Command, args []string) {
+	alias := args[0]
+	if alias == "default" {
+		log.Fatal("The default image can't be removed.")
+	}
+	if !isEntryExist(IMAGES, alias) {
+		if image := findLoadedImage(readLoadedImages(), alias); image != nil && image.Alias == alias {
+			log.Fatal("The alias " + alias + " comes from a loaded bundle, it is not in the configuration file.")
+		}
+		log.Fatal("The alias " + alias + " doesn't exist.")
+	}
+	if len(configurationFile) == 0 {
+		log.Fatal("There is no configuration file, " + alias + " is a builtin alias.")
+	}
+
+	// Clusters started before the image_alias label existed are found by their image
+	clusters := getClustersWithLabel("image_alias", alias)
+	image := getImageNameFromConfig(alias)
+	for _, containerName := range getNanoContainers() {
+		name := strings.TrimPrefix(containerName, containerNamePrefix)
+		if !isStringInSlice(name, clusters) && dockerInspect(containerName, "Image") == image {
+			clusters = append(clusters, name)
+		}
+	}
+	if len(clusters) > 0 {
+		log.Fatal("The alias " + alias + " can't be removed, it is used by the cluster(s) " + strings.Join(clusters, ", ") + ".")
+	}
+
+	removeConfigTable(IMAGES, alias, isStringInSlice(alias, builtinImages))
+}
@@ -0,0 +1,343 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// bundleManifestName is the entry of a bundle mapping the cn aliases to the saved images
+	bundleManifestName = "cn-images.json"
+
+	// loadedImagesFile remembers the images loaded from bundles, relative to ~/.cn
+	loadedImagesFile = "images.json"
+)
+
+var (
+	// bundleOutput is the file an image bundle is saved to
+	bundleOutput string
+)
+
+// bundleImage is an image saved in a bundle
+type bundleImage struct {
+	Alias  string `json:"alias,omitempty"`  // Alias is empty for an image saved by name
+	Name   string `json:"name"`             // Name is the image name the alias pointed to, e.g: ceph/daemon:latest-mimic
+	ID     string `json:"id"`               // ID is the image ID, what docker load restores for sure
+	Digest string `json:"digest,omitempty"` // Digest is the repository digest, e.g: ceph/daemon@sha256:...
+}
+
+// bundleManifest is the content of cn-images.json
+type bundleManifest struct {
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Images  []bundleImage `json:"images"`
+}
+
+// cliImageSave is the Cobra CLI call
+func cliImageSave() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "save [alias|image...]",
+		Short: "Save container images to a bundle to start clusters on a host without registry access",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   saveImageBundle,
+		Example: "cn image save mimic luminous -o nano-images.tar\n" +
+			"cn image save ceph/daemon:latest-master -o master.tar\n",
+	}
+	cmd.Flags().StringVarP(&bundleOutput, "output", "o", "nano-images.tar", "File to save the bundle to")
+
+	return cmd
+}
+
+// cliImageLoad is the Cobra CLI call
+func cliImageLoad() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "load BUNDLE",
+		Short: "Load container images from a bundle made by 'image save'",
+		Args:  cobra.ExactArgs(1),
+		Run:   loadImageBundle,
+		Example: "cn image load nano-images.tar\n" +
+			"cn cluster start mycluster -i mimic\n",
+		DisableFlagsInUseLine: true,
+	}
+
+	return cmd
+}
+
+// saveImageBundle saves images and the aliases pointing to them
+func saveImageBundle(cmd *cobra.Command, args []string) {
+	manifest := bundleManifest{Version: 1, Created: time.Now().UTC()}
+	var names []string
+	for _, arg := range args {
+		image := bundleImage{Name: getImageName(arg)}
+		if image.Name != arg {
+			image.Alias = arg
+		}
+		// Saving needs the images locally, that's the only time a registry is needed
+		pullImageNamed(image.Name)
+		inspect, _, err := getDocker().ImageInspectWithRaw(ctx, image.Name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		image.ID = inspect.ID
+		if len(inspect.RepoDigests) > 0 {
+			image.Digest = inspect.RepoDigests[0]
+		}
+		manifest.Images = append(manifest.Images, image)
+		names = append(names, image.Name)
+	}
+
+	log.Println("Saving " + fmt.Sprint(len(names)) + " image(s) to " + bundleOutput + ", this operation can take a few minutes.")
+	images, err := getDocker().ImageSave(ctx, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer images.Close()
+
+	// The bundle is written aside and renamed, an interrupted save doesn't leave a truncated bundle
+	tmp, err := ioutil.TempFile(filepath.Dir(bundleOutput), filepath.Base(bundleOutput)+".")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeBundle(tmp, images, manifest); err != nil {
+		tmp.Close()
+		log.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp.Name(), bundleOutput); err != nil {
+		log.Fatal(err)
+	}
+	for _, image := range manifest.Images {
+		log.Println("Saved " + describeBundleImage(image))
+	}
+}
+
+// loadImageBundle loads the images of a bundle and remembers its aliases
+func loadImageBundle(cmd *cobra.Command, args []string) {
+	bundle, err := os.Open(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bundle.Close()
+	manifest, err := readBundleManifest(bundle)
+	if err != nil {
+		log.Fatal(args[0] + " is not a cn image bundle: " + err.Error())
+	}
+	if _, err := bundle.Seek(0, io.SeekStart); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Loading " + fmt.Sprint(len(manifest.Images)) + " image(s) from " + args[0] + ", this operation can take a few minutes.")
+	response, err := getDocker().ImageLoad(ctx, bundle, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer response.Body.Close()
+	if err := readLoadResponse(response.Body); err != nil {
+		log.Fatal(err)
+	}
+
+	// docker load restores the tags, not the repository digests the aliases may point to
+	for _, image := range manifest.Images {
+		if _, _, err := getDocker().ImageInspectWithRaw(ctx, image.ID); err != nil {
+			log.Fatal("Image " + image.Name + " is missing from the bundle: " + err.Error())
+		}
+		if err := getDocker().ImageTag(ctx, image.ID, image.Name); err != nil {
+			log.Println("Unable to tag " + image.ID + " as " + image.Name + ": " + err.Error())
+		}
+		log.Println("Loaded " + describeBundleImage(image))
+	}
+	if err := saveLoadedImages(mergeLoadedImages(readLoadedImages(), manifest.Images)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeBundle writes a bundle: the manifest followed by the entries of a docker save archive
+func writeBundle(w io.Writer, images io.Reader, manifest bundleManifest) error {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	// docker load ignores the files it doesn't know, the manifest comes first for load to find it fast
+	header := &tar.Header{Name: bundleManifestName, Mode: 0644, Size: int64(len(content)), ModTime: manifest.Created, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(content); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(images)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read the saved images: %s", err)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readBundleManifest returns the manifest of a bundle
+func readBundleManifest(r io.Reader) (*bundleManifest, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("no " + bundleManifestName + " found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name != bundleManifestName {
+			continue
+		}
+		manifest := &bundleManifest{}
+		if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", bundleManifestName, err)
+		}
+		if manifest.Version != 1 {
+			return nil, fmt.Errorf("unsupported bundle version %d, upgrade cn", manifest.Version)
+		}
+		return manifest, nil
+	}
+}
+
+// readLoadResponse reads the messages of docker load and returns the error it reports, if any
+func readLoadResponse(body io.Reader) error {
+	d := json.NewDecoder(body)
+	for {
+		var message struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := d.Decode(&message); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if len(message.Error) > 0 {
+			return errors.New(message.Error)
+		}
+	}
+}
+
+// describeBundleImage returns a one line description of an image of a bundle
+func describeBundleImage(image bundleImage) string {
+	description := image.Name
+	if len(image.Alias) > 0 {
+		description = image.Alias + " (" + image.Name + ")"
+	}
+	if len(image.Digest) > 0 {
+		return description + " " + image.Digest
+	}
+	return description + " " + image.ID
+}
+
+// readLoadedImages returns the images loaded from bundles on this host
+func readLoadedImages() []bundleImage {
+	var images []bundleImage
+	content, err := ioutil.ReadFile(makeCephNanoPath(loadedImagesFile))
+	if err != nil {
+		return images
+	}
+	if err := json.Unmarshal(content, &images); err != nil {
+		log.Println("Ignoring invalid " + makeCephNanoPath(loadedImagesFile) + ": " + err.Error())
+		return nil
+	}
+	return images
+}
+
+// saveLoadedImages writes the images loaded from bundles on disk
+func saveLoadedImages(images []bundleImage) error {
+	content, err := json.MarshalIndent(images, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(makeCephNanoPath(loadedImagesFile), content, 0644)
+}
+
+// mergeLoadedImages adds freshly loaded images, replacing the ones with the same alias or name
+func mergeLoadedImages(loaded []bundleImage, images []bundleImage) []bundleImage {
+	var merged []bundleImage
+	for _, old := range loaded {
+		replaced := false
+		for _, image := range images {
+			if (len(old.Alias) > 0 && old.Alias == image.Alias) || (len(old.Alias) == 0 && old.Name == image.Name) {
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, old)
+		}
+	}
+	return append(merged, images...)
+}
+
+// findLoadedImage returns the loaded image matching an alias, a name or a digest
+func findLoadedImage(loaded []bundleImage, image string) *bundleImage {
+	for i := range loaded {
+		if loaded[i].Alias == image || loaded[i].Name == image || loaded[i].Digest == image {
+			return &loaded[i]
+		}
+	}
+	return nil
+}
+
+// getLocalImage returns the image a cluster is created from
+// A loaded image is used by ID when docker doesn't know its name, e.g: a digest docker load doesn't restore
+func getLocalImage(name string) string {
+	if _, _, err := getDocker().ImageInspectWithRaw(ctx, name); err == nil {
+		return name
+	}
+	if image := findLoadedImage(readLoadedImages(), name); image != nil {
+		if _, _, err := getDocker().ImageInspectWithRaw(ctx, image.ID); err == nil {
+			return image.ID
+		}
+	}
+	return name
+}
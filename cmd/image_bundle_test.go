@@ -0,0 +1,94 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundle(t *testing.T) {
+	// A docker save archive stand-in
+	var images bytes.Buffer
+	tw := tar.NewWriter(&images)
+	for name, content := range map[string]string{"manifest.json": "[]", "0123/layer.tar": "layer"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+
+	manifest := bundleManifest{Version: 1, Created: time.Unix(0, 0).UTC(), Images: []bundleImage{
+		{Alias: "mimic", Name: "ceph/daemon:latest-mimic", ID: "sha256:aaa", Digest: "ceph/daemon@sha256:bbb"},
+	}}
+	var bundle bytes.Buffer
+	assert.Nil(t, writeBundle(&bundle, &images, manifest))
+
+	read, err := readBundleManifest(bytes.NewReader(bundle.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, &manifest, read)
+
+	// The docker entries are kept as is
+	tr := tar.NewReader(bytes.NewReader(bundle.Bytes()))
+	var names []string
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		names = append(names, header.Name)
+		if header.Name == "0123/layer.tar" {
+			content, _ := ioutil.ReadAll(tr)
+			assert.Equal(t, "layer", string(content))
+		}
+	}
+	assert.Equal(t, bundleManifestName, names[0])
+	assert.Len(t, names, 3)
+
+	// A plain docker save archive is not a bundle
+	_, err = readBundleManifest(bytes.NewReader(images.Bytes()))
+	assert.NotNil(t, err)
+}
+
+func TestLoadedImages(t *testing.T) {
+	loaded := []bundleImage{
+		{Alias: "mimic", Name: "ceph/daemon:latest-mimic", ID: "sha256:old"},
+		{Name: "ceph/daemon:v3.1.0", ID: "sha256:v31"},
+	}
+	merged := mergeLoadedImages(loaded, []bundleImage{{Alias: "mimic", Name: "ceph/daemon:latest-mimic", ID: "sha256:new", Digest: "ceph/daemon@sha256:ddd"}})
+	assert.Len(t, merged, 2)
+
+	assert.Equal(t, "sha256:new", findLoadedImage(merged, "mimic").ID)
+	assert.Equal(t, "sha256:new", findLoadedImage(merged, "ceph/daemon@sha256:ddd").ID)
+	assert.Equal(t, "sha256:v31", findLoadedImage(merged, "ceph/daemon:v3.1.0").ID)
+	assert.Nil(t, findLoadedImage(merged, "luminous"))
+}
+
+func TestReadLoadResponse(t *testing.T) {
+	assert.Nil(t, readLoadResponse(strings.NewReader(`{"stream":"Loaded image: ceph/daemon:latest-mimic\n"}`)))
+	err := readLoadResponse(strings.NewReader(`{"stream":"Loading layer"}{"errorDetail":{"message":"no space left"},"error":"no space left"}`))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "no space left", err.Error())
+	}
+}
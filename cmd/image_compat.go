@@ -0,0 +1,175 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// imageCompatibility is how cn runs the images of a Ceph release
+// All the supported releases start the same demo daemons with the same CEPH_DEMO_UID, only the variables naming the ports differ
+type imageCompatibility struct {
+	release      string // release is the Ceph release codename, empty when unknown
+	incompatible string // incompatible explains why cn can't run the release, empty if it can
+	legacyEnv    bool   // legacyEnv also sets the variables older ceph-container images read, e.g: RGW_CIVETWEB_PORT
+	tls          bool   // tls reports if the RGW frontend of the release serves https
+}
+
+// imageCompatibilities is what cn knows about the Ceph releases, from the oldest to the newest
+var imageCompatibilities = []imageCompatibility{
+	{release: "hammer", incompatible: "Hammer has no ceph-mgr, cn needs Luminous or later"},
+	{release: "infernalis", incompatible: "Infernalis has no ceph-mgr, cn needs Luminous or later"},
+	{release: "jewel", incompatible: "Jewel has no ceph-mgr, cn needs Luminous or later"},
+	{release: "kraken", incompatible: "Kraken is not supported, cn needs Luminous or later"},
+	{release: "luminous", legacyEnv: true},
+	{release: "mimic", legacyEnv: true},
+	{release: "nautilus", tls: true},
+	{release: "octopus", tls: true},
+}
+
+// defaultCompatibility is used for the images whose release is unknown or newer, e.g: master builds
+// Legacy variables are set as well, they are harmless for the images not reading them
+var defaultCompatibility = imageCompatibility{legacyEnv: true, tls: true}
+
+// cephMajorReleases maps the major version of CEPH_POINT_RELEASE to the release codename
+var cephMajorReleases = map[int]string{
+	0:  "hammer",
+	9:  "infernalis",
+	10: "jewel",
+	11: "kraken",
+	12: "luminous",
+	13: "mimic",
+	14: "nautilus",
+	15: "octopus",
+}
+
+// redHatCephReleases maps the version of the Red Hat Ceph Storage images to the release codename
+var redHatCephReleases = map[string]string{
+	"2": "jewel",
+	"3": "luminous",
+	"4": "nautilus",
+}
+
+// pointReleaseRegexp matches the major version of a point release, e.g: -13.2.5 or 12.2.10-0
+var pointReleaseRegexp = regexp.MustCompile(`^-?(\d+)\.`)
+
+// getImageCompatibility checks an image can run a cn cluster and returns how to run it
+// The default compatibility comes with the error of an incompatible image, for --force to use it
+func getImageCompatibility(config *container.Config) (imageCompatibility, error) {
+	if config == nil {
+		return defaultCompatibility, errors.New("the image has no configuration")
+	}
+	// ceph-container images all start the daemons from their entrypoint.sh, the demo daemon included
+	if len(config.Entrypoint) == 0 || !strings.HasSuffix(config.Entrypoint[len(config.Entrypoint)-1], "entrypoint.sh") {
+		return defaultCompatibility, errors.New("the image is not a ceph-container image, its entrypoint " + strconv.Quote(strings.Join(config.Entrypoint, " ")) + " can't start the demo daemon")
+	}
+
+	release := detectCephRelease(config)
+	for _, compatibility := range imageCompatibilities {
+		if compatibility.release != release {
+			continue
+		}
+		if len(compatibility.incompatible) > 0 {
+			return defaultCompatibility, errors.New("the image runs Ceph " + strings.Title(release) + ": " + compatibility.incompatible)
+		}
+		return compatibility, nil
+	}
+	compatibility := defaultCompatibility
+	compatibility.release = release
+	return compatibility, nil
+}
+
+// detectCephRelease returns the Ceph release codename of an image, empty if it can't be found
+func detectCephRelease(config *container.Config) string {
+	env := map[string]string{}
+	for _, variable := range config.Env {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	// ceph-container images set the release they were built for
+	if isCephRelease(env["CEPH_VERSION"]) {
+		return env["CEPH_VERSION"]
+	}
+	for _, pointRelease := range []string{env["CEPH_POINT_RELEASE"], config.Labels["CEPH_POINT_RELEASE"]} {
+		if match := pointReleaseRegexp.FindStringSubmatch(pointRelease); match != nil {
+			major, _ := strconv.Atoi(match[1])
+			if release, ok := cephMajorReleases[major]; ok {
+				return release
+			}
+		}
+	}
+	// Tags like master-0b3eb04-mimic-centos-7 end up in the RELEASE label
+	for _, part := range strings.Split(config.Labels["RELEASE"], "-") {
+		if isCephRelease(part) {
+			return part
+		}
+	}
+	if config.Labels["com.redhat.component"] == "rhceph-container" {
+		return redHatCephReleases[strings.SplitN(config.Labels["version"], ".", 2)[0]]
+	}
+	return ""
+}
+
+// isCephRelease reports if a name is a release codename cn knows
+func isCephRelease(name string) bool {
+	for _, compatibility := range imageCompatibilities {
+		if compatibility.release == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImageCompatibility inspects an image before a cluster is created from it
+// An incompatible image is refused unless --force is set
+func checkImageCompatibility(image string) imageCompatibility {
+	inspect, _, err := getDocker().ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		log.Fatal(err)
+	}
+	compatibility, err := getImageCompatibility(inspect.Config)
+	if err != nil {
+		refuseImage(image, err.Error())
+	}
+	if err == nil && len(compatibility.release) == 0 {
+		log.Println("Warning: unable to find the Ceph release of " + image + ", assuming it runs a recent one.")
+	}
+	return compatibility
+}
+
+// refuseImage stops cn from starting an incompatible image unless --force is set
+func refuseImage(image string, reason string) {
+	if forceImage {
+		log.Println("Warning: " + image + " is not compatible with cn, " + reason + ". Starting it anyway as --force is set.")
+		return
+	}
+	log.Fatal(image + " is not compatible with cn, " + reason + ".\nUse --force to start it anyway.")
+}
@@ -0,0 +1,80 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectCephRelease(t *testing.T) {
+	tests := []struct {
+		env     []string
+		labels  map[string]string
+		release string
+	}{
+		{[]string{"PATH=/usr/bin", "CEPH_VERSION=mimic"}, nil, "mimic"},
+		{[]string{"CEPH_VERSION=master", "CEPH_POINT_RELEASE=-14.1.0"}, nil, "nautilus"},
+		{nil, map[string]string{"CEPH_POINT_RELEASE": "12.2.10-0"}, "luminous"},
+		{nil, map[string]string{"RELEASE": "master-0b3eb04-jewel-centos-7"}, "jewel"},
+		{nil, map[string]string{"com.redhat.component": "rhceph-container", "version": "3"}, "luminous"},
+		{[]string{"CEPH_VERSION=master"}, map[string]string{"RELEASE": "master-77e3d8d"}, ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.release, detectCephRelease(&container.Config{Env: test.env, Labels: test.labels}), test.release)
+	}
+}
+
+func TestGetImageCompatibility(t *testing.T) {
+	entrypoint := []string{"/opt/ceph-container/bin/entrypoint.sh"}
+
+	compatibility, err := getImageCompatibility(&container.Config{Entrypoint: entrypoint, Env: []string{"CEPH_VERSION=luminous"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "luminous", compatibility.release)
+	assert.True(t, compatibility.legacyEnv)
+	assert.False(t, compatibility.tls)
+
+	compatibility, err = getImageCompatibility(&container.Config{Entrypoint: []string{"/entrypoint.sh"}, Env: []string{"CEPH_VERSION=nautilus"}})
+	assert.Nil(t, err)
+	assert.False(t, compatibility.legacyEnv)
+	assert.True(t, compatibility.tls)
+
+	// Unknown releases get the default settings
+	compatibility, err = getImageCompatibility(&container.Config{Entrypoint: entrypoint, Env: []string{"CEPH_VERSION=master"}})
+	assert.Nil(t, err)
+	assert.Equal(t, defaultCompatibility, compatibility)
+
+	// Incompatible images come with the default settings for --force
+	compatibility, err = getImageCompatibility(&container.Config{Entrypoint: entrypoint, Env: []string{"CEPH_VERSION=jewel"}})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "the image runs Ceph Jewel: Jewel has no ceph-mgr, cn needs Luminous or later", err.Error())
+	}
+	assert.Equal(t, defaultCompatibility, compatibility)
+
+	_, err = getImageCompatibility(&container.Config{Entrypoint: []string{"/bin/sh", "-c"}, Env: []string{"CEPH_VERSION=mimic"}})
+	assert.NotNil(t, err)
+	_, err = getImageCompatibility(&container.Config{Env: []string{"CEPH_VERSION=mimic"}})
+	assert.NotNil(t, err)
+}
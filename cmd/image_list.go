@@ -23,34 +23,200 @@
 package cmd
 
 import (
+	"fmt"
+	"log"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 
+	"github.com/apcera/termtables"
+	"github.com/ceph/cn/pkg/registry"
+	dockerClient "github.com/docker/docker/client"
 	"github.com/spf13/cobra"
 )
 
+const (
+	// defaultTagsToList is the number of tags listed without --all
+	defaultTagsToList = 100
+
+	// registryWorkers is the number of tags inspected in parallel
+	registryWorkers = 8
+)
+
 var (
 	// ListAllTags whether or not to list all the image tags
 	ListAllTags bool
+
+	// tagFilter is a regular expression the listed tags must match
+	tagFilter string
+
+	// listDigests whether or not to print the digest of the tags
+	listDigests bool
+
+	// listDetails whether or not to inspect the tags to print their digest, size and Ceph release
+	listDetails bool
 )
 
 // CliImageList is the Cobra CLI call
 func CliImageList() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "ls",
+		Use:   "ls [alias|repository]",
 		Short: "List container image tags (default print the first 100 tags)",
-		Args:  cobra.NoArgs,
+		Args:  cobra.MaximumNArgs(1),
 		Run:   listImageTags,
+		Example: "cn image ls\n" +
+			"cn image ls mimic --filter '^latest-' --details\n" +
+			"cn image ls quay.io/ceph/daemon --all\n",
 	}
 	cmd.Flags().BoolVarP(&ListAllTags, "all", "a", false, "List all the tags of the container image (can be verbose)")
+	cmd.Flags().StringVar(&tagFilter, "filter", "", "Only list the tags matching a regular expression")
+	cmd.Flags().BoolVar(&listDigests, "digests", false, "Print the digest of the tags (one request per tag)")
+	cmd.Flags().BoolVar(&listDetails, "details", false, "Print the digest, size and Ceph release of the tags (several requests per tag, prefer using --filter)")
 
 	return cmd
 }
 
-// listImageTags lists container image tags
+// listImageTags lists container image tags, with their digest, size and Ceph release if asked
+// The registry is only queried for each tag with --digests or --details, the Docker Hub limits the pulls
 func listImageTags(cmd *cobra.Command, args []string) {
-	if os.Getenv("CN_REGISTRY") == "redhat" {
-		listRedHatRegistryImageTags()
-	} else {
-		listDockerRegistryImageTags()
+	ref, err := registry.ParseReference(getRepositoryToList(args))
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter, err := regexp.Compile(tagFilter)
+	if err != nil {
+		log.Fatal("Invalid filter: ", err)
+	}
+
+	client, err := registry.NewClient(ref.Registry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	allTags, err := client.Tags(ref.Repository)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tags []string
+	for _, tag := range allTags {
+		if filter.MatchString(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if !ListAllTags && len(tags) > defaultTagsToList {
+		tags = tags[:defaultTagsToList]
+	}
+
+	table := termtables.CreateTable()
+	switch {
+	case listDetails:
+		setDaemonPlatform(client)
+		images := inspectImageTags(client, ref.Repository, tags)
+		table.AddHeaders("IMAGE", "DIGEST", "SIZE", "RELEASE")
+		for i, tag := range tags {
+			if images[i] == nil {
+				table.AddRow(ref.Name()+":"+tag, "-", "-", "-")
+				continue
+			}
+			release := images[i].Labels["RELEASE"]
+			if len(release) == 0 {
+				release = "-"
+			}
+			table.AddRow(ref.Name()+":"+tag, shortDigest(images[i].Digest), humanBytes(uint64(images[i].Size)), release)
+		}
+	case listDigests:
+		digests := digestImageTags(client, ref.Repository, tags)
+		table.AddHeaders("IMAGE", "DIGEST")
+		for i, tag := range tags {
+			table.AddRow(ref.Name()+":"+tag, shortDigest(digests[i]))
+		}
+	default:
+		table.AddHeaders("IMAGE")
+		for _, tag := range tags {
+			table.AddRow(ref.Name() + ":" + tag)
+		}
+	}
+	fmt.Println(table.Render())
+}
+
+// getRepositoryToList returns the image name of an alias, the repository given or the default image
+func getRepositoryToList(args []string) string {
+	if len(args) == 0 {
+		// CN_REGISTRY=redhat used to be the only way to list another registry
+		if os.Getenv("CN_REGISTRY") == "redhat" {
+			return getImageNameFromConfig("redhat")
+		}
+		return getImageNameFromConfig("default")
+	}
+	if isEntryExist(IMAGES, args[0]) {
+		return getImageNameFromConfig(args[0])
+	}
+	return args[0]
+}
+
+// setDaemonPlatform makes the client inspect the images of the platform of the Docker daemon, what a cluster would run
+// The client keeps inspecting linux images of the local architecture when the daemon can't be reached
+func setDaemonPlatform(client *registry.Client) {
+	cli, err := dockerClient.NewEnvClient()
+	if err != nil {
+		return
+	}
+	version, err := cli.ServerVersion(ctx)
+	if err != nil || len(version.Os) == 0 || len(version.Arch) == 0 {
+		return
+	}
+	client.OS, client.Architecture = version.Os, version.Arch
+}
+
+// inspectImageTags inspects the tags of a repository in parallel, a tag failing to be inspected is nil
+func inspectImageTags(client *registry.Client, repository string, tags []string) []*registry.Image {
+	images := make([]*registry.Image, len(tags))
+	forEachTag(tags, func(i int, tag string) {
+		image, err := client.Inspect(repository, tag)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		images[i] = image
+	})
+	return images
+}
+
+// digestImageTags returns the digests of the tags of a repository, a tag failing to be resolved is "-"
+func digestImageTags(client *registry.Client, repository string, tags []string) []string {
+	digests := make([]string, len(tags))
+	forEachTag(tags, func(i int, tag string) {
+		digest, err := client.Digest(repository, tag)
+		if err != nil {
+			log.Println(err)
+			digest = "-"
+		}
+		digests[i] = digest
+	})
+	return digests
+}
+
+// forEachTag calls f for each tag, registryWorkers tags at a time
+func forEachTag(tags []string, f func(i int, tag string)) {
+	workers := make(chan struct{}, registryWorkers)
+	var wg sync.WaitGroup
+	for i, tag := range tags {
+		wg.Add(1)
+		go func(i int, tag string) {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			f(i, tag)
+		}(i, tag)
+	}
+	wg.Wait()
+}
+
+// shortDigest truncates a digest the way docker truncates IDs, e.g: sha256:0123456789ab
+func shortDigest(digest string) string {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || len(parts[1]) <= 12 {
+		return digest
 	}
+	return parts[0] + ":" + parts[1][:12]
 }
@@ -0,0 +1,279 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ceph/cn/pkg/registry"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// lockFileName is the file pinning the aliases to digests, next to the configuration file
+	lockFileName = "cn.lock"
+
+	// lockMismatchWarn starts the pinned image when the local one differs
+	lockMismatchWarn = "warn"
+
+	// lockMismatchFail refuses to start when the local image differs from the pinned one
+	lockMismatchFail = "fail"
+)
+
+var (
+	// updateLock refreshes all the pins of the lock file
+	updateLock bool
+
+	// lockLocal pins the aliases to the digests of the local images instead of the registry ones
+	lockLocal bool
+)
+
+// imageLock is an alias pinned to a digest
+type imageLock struct {
+	ImageName string `json:"image_name"` // ImageName is what the alias pointed to when it was pinned
+	Digest    string `json:"digest"`
+}
+
+// lockFile is the content of cn.lock
+type lockFile struct {
+	Version int                  `json:"version"`
+	Images  map[string]imageLock `json:"images"`
+}
+
+// cliImageLock is the Cobra CLI call
+func cliImageLock() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Pin the image aliases to content digests in " + lockFileName,
+		Args:  cobra.NoArgs,
+		Run:   lockImages,
+		Long: "Resolves every alias of the [images] section to a content digest and writes " + lockFileName + " next to the configuration file.\n" +
+			"'cluster start' then uses the pinned digests, everyone sharing the lock file gets the same Ceph build.",
+		Example: "cn image lock\n" +
+			"cn image lock --update\n" +
+			"cn image lock --local\n",
+	}
+	cmd.Flags().BoolVar(&updateLock, "update", false, "Refresh all the pins, not only the new or changed aliases")
+	cmd.Flags().BoolVar(&lockLocal, "local", false, "Pin all the aliases to the images pulled locally instead of the registry ones")
+
+	return cmd
+}
+
+// lockImages writes the lock file
+func lockImages(cmd *cobra.Command, args []string) {
+	lock, err := readLockFile(getLockFilePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	aliases := map[string]string{}
+	for alias := range getItemsFromGroup(IMAGES) {
+		aliases[alias] = getImageNameFromConfig(alias)
+	}
+
+	clients := map[string]*registry.Client{}
+	resolve := func(imageName string) (string, error) {
+		ref, err := registry.ParseReference(imageName)
+		if err != nil {
+			return "", err
+		}
+		if len(ref.Digest) > 0 {
+			return ref.Digest, nil
+		}
+		if lockLocal {
+			inspect, _, err := getDocker().ImageInspectWithRaw(ctx, imageName)
+			if err != nil {
+				return "", err
+			}
+			return getLocalDigest(inspect.RepoDigests, ref.Name())
+		}
+		if clients[ref.Registry] == nil {
+			if clients[ref.Registry], err = registry.NewClient(ref.Registry); err != nil {
+				return "", err
+			}
+		}
+		tag := ref.Tag
+		if len(tag) == 0 {
+			tag = "latest"
+		}
+		return clients[ref.Registry].Digest(ref.Repository, tag)
+	}
+
+	for _, alias := range pinImages(lock, aliases, updateLock || lockLocal, resolve) {
+		log.Println("Pinned " + alias + " to " + getPinnedImageName(lock.Images[alias]))
+	}
+	if err := writeLockFile(getLockFilePath(), lock); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Wrote " + getLockFilePath())
+}
+
+// pinImages pins the new and changed aliases, or all of them on update, and forgets the removed ones
+// It returns the aliases pinned, an alias failing to resolve keeps its previous pin
+func pinImages(lock *lockFile, aliases map[string]string, update bool, resolve func(imageName string) (string, error)) []string {
+	for alias := range lock.Images {
+		if _, ok := aliases[alias]; !ok {
+			delete(lock.Images, alias)
+		}
+	}
+
+	var sorted []string
+	for alias := range aliases {
+		sorted = append(sorted, alias)
+	}
+	sort.Strings(sorted)
+
+	var pinned []string
+	for _, alias := range sorted {
+		imageName := aliases[alias]
+		if current, ok := lock.Images[alias]; ok && current.ImageName == imageName && !update {
+			continue
+		}
+		digest, err := resolve(imageName)
+		if err != nil {
+			log.Println("Unable to pin " + alias + ": " + err.Error())
+			continue
+		}
+		lock.Images[alias] = imageLock{ImageName: imageName, Digest: digest}
+		pinned = append(pinned, alias)
+	}
+	return pinned
+}
+
+// getLockFilePath returns where the lock file is, next to the configuration file or in ~/.cn
+func getLockFilePath() string {
+	if len(configurationFile) > 0 {
+		return filepath.Join(filepath.Dir(configurationFile), lockFileName)
+	}
+	return makeCephNanoPath(lockFileName)
+}
+
+// readLockFile reads a lock file, a missing one is an empty lock
+func readLockFile(path string) (*lockFile, error) {
+	lock := &lockFile{Version: 1, Images: map[string]imageLock{}}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, lock); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", path, err)
+	}
+	if lock.Version != 1 {
+		return nil, fmt.Errorf("unsupported %s version %d, upgrade cn", path, lock.Version)
+	}
+	if lock.Images == nil {
+		lock.Images = map[string]imageLock{}
+	}
+	return lock, nil
+}
+
+// writeLockFile writes a lock file
+func writeLockFile(path string, lock *lockFile) error {
+	content, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// getPinnedImageName returns the name pulling exactly the pinned image, e.g: ceph/daemon@sha256:...
+func getPinnedImageName(pin imageLock) string {
+	ref, err := registry.ParseReference(pin.ImageName)
+	if err != nil {
+		return pin.ImageName
+	}
+	return ref.Name() + "@" + pin.Digest
+}
+
+// findPin returns the pin of an image name, whatever the alias used to start the cluster
+func findPin(lock *lockFile, imageName string) (imageLock, bool) {
+	for _, pin := range lock.Images {
+		if pin.ImageName == imageName {
+			return pin, true
+		}
+	}
+	return imageLock{}, false
+}
+
+// getLockMismatch returns what to do when the local image differs from the pinned one
+func getLockMismatch() string {
+	return getStringFromConfig(LOCK, "config", "on_mismatch")
+}
+
+// isPinnedDigest reports if a local image is the pinned one, from the repository digests docker knows
+func isPinnedDigest(repoDigests []string, pinnedImageName string) bool {
+	for _, repoDigest := range repoDigests {
+		if repoDigest == pinnedImageName {
+			return true
+		}
+	}
+	return false
+}
+
+// getLocalDigest returns the digest a local image was pulled with from the repository digests docker knows
+// An image built or loaded locally has none, it can't be pinned
+func getLocalDigest(repoDigests []string, name string) (string, error) {
+	for _, repoDigest := range repoDigests {
+		if strings.HasPrefix(repoDigest, name+"@") {
+			return strings.TrimPrefix(repoDigest, name+"@"), nil
+		}
+	}
+	return "", fmt.Errorf("the local image of %s has no digest, push it to a registry to pin it", name)
+}
+
+// getImageToRun returns the image a new cluster is created from, the pinned digest when the image is locked
+func getImageToRun() string {
+	imageName := getImageName()
+	lock, err := readLockFile(getLockFilePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	pin, ok := findPin(lock, imageName)
+	if !ok {
+		return imageName
+	}
+	pinned := getPinnedImageName(pin)
+
+	// The local image of the tag is likely what the user expects to run, tell them it's not
+	if inspect, _, err := getDocker().ImageInspectWithRaw(ctx, imageName); err == nil && !isPinnedDigest(inspect.RepoDigests, pinned) {
+		message := "The local image " + imageName + " differs from " + pinned + " pinned in " + getLockFilePath()
+		switch getLockMismatch() {
+		case lockMismatchFail:
+			log.Fatal(message + ", run 'cn image lock --local' to pin the local one.")
+		case lockMismatchWarn:
+			log.Println("Warning: " + message + ", using the pinned one.")
+		default:
+			log.Fatal("on_mismatch in [" + LOCK + ".config] must be " + lockMismatchWarn + " or " + lockMismatchFail + ".")
+		}
+	}
+	return pinned
+}
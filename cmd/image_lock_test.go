@@ -0,0 +1,105 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinImages(t *testing.T) {
+	lock := &lockFile{Version: 1, Images: map[string]imageLock{
+		"mimic":   {ImageName: "ceph/daemon:latest-mimic", Digest: "sha256:old-mimic"},
+		"removed": {ImageName: "ceph/daemon:v3.0.0", Digest: "sha256:removed"},
+		"custom":  {ImageName: "ceph/daemon:v3.1.0", Digest: "sha256:v31"},
+	}}
+	aliases := map[string]string{
+		"mimic":    "ceph/daemon:latest-mimic",
+		"luminous": "ceph/daemon:latest-luminous",
+		"custom":   "ceph/daemon:v3.2.0",
+		"redhat":   "registry.access.redhat.com/rhceph/rhceph-3-rhel7",
+	}
+	resolve := func(imageName string) (string, error) {
+		if imageName == aliases["redhat"] {
+			return "", errors.New("unreachable")
+		}
+		return "sha256:" + imageName, nil
+	}
+
+	// New and changed aliases only, a failing alias is skipped
+	pinned := pinImages(lock, aliases, false, resolve)
+	assert.Equal(t, []string{"custom", "luminous"}, pinned)
+	assert.Equal(t, "sha256:old-mimic", lock.Images["mimic"].Digest)
+	assert.Equal(t, imageLock{ImageName: "ceph/daemon:v3.2.0", Digest: "sha256:ceph/daemon:v3.2.0"}, lock.Images["custom"])
+	assert.NotContains(t, lock.Images, "removed")
+	assert.NotContains(t, lock.Images, "redhat")
+
+	// Everything on update
+	pinned = pinImages(lock, aliases, true, resolve)
+	assert.Equal(t, []string{"custom", "luminous", "mimic"}, pinned)
+	assert.Equal(t, "sha256:ceph/daemon:latest-mimic", lock.Images["mimic"].Digest)
+}
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cn-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, lockFileName)
+
+	// A missing lock file is an empty lock
+	lock, err := readLockFile(path)
+	assert.Nil(t, err)
+	assert.Empty(t, lock.Images)
+
+	lock.Images["mimic"] = imageLock{ImageName: "ceph/daemon:latest-mimic", Digest: "sha256:aaa"}
+	assert.Nil(t, writeLockFile(path, lock))
+	read, err := readLockFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, lock, read)
+
+	pin, ok := findPin(read, "ceph/daemon:latest-mimic")
+	assert.True(t, ok)
+	assert.Equal(t, "ceph/daemon@sha256:aaa", getPinnedImageName(pin))
+	_, ok = findPin(read, "ceph/daemon:latest-luminous")
+	assert.False(t, ok)
+
+	assert.True(t, isPinnedDigest([]string{"ceph/daemon@sha256:bbb", "ceph/daemon@sha256:aaa"}, "ceph/daemon@sha256:aaa"))
+	assert.False(t, isPinnedDigest(nil, "ceph/daemon@sha256:aaa"))
+
+	digest, err := getLocalDigest([]string{"quay.io/ceph/daemon@sha256:ccc", "ceph/daemon@sha256:bbb"}, "ceph/daemon")
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:bbb", digest)
+	_, err = getLocalDigest(nil, "ceph/daemon")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"version": 2}`), 0644))
+	_, err = readLockFile(path)
+	assert.NotNil(t, err)
+}
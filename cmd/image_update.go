@@ -23,13 +23,8 @@
 package cmd
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
 	"log"
-	"strings"
 
-	"github.com/docker/docker/api/types"
 	"github.com/spf13/cobra"
 )
 
@@ -49,44 +44,46 @@ func CliImageUpdate() *cobra.Command {
 
 // updateNano updates the container image
 func updateNano(cmd *cobra.Command, args []string) {
-	imageName := args[0]
+	imageName := getImageName(args[0])
 
-	if !pullImage() {
-		events, err := getDocker().ImagePull(ctx, imageName, types.ImagePullOptions{})
-		if err != nil {
-			log.Fatal(err)
-		}
+	oldImageID := ""
+	if inspect, _, err := getDocker().ImageInspectWithRaw(ctx, imageName); err == nil {
+		oldImageID = inspect.ID
+	}
 
-		d := json.NewDecoder(events)
+	if _, err := pullImageWithProgress(imageName); err != nil {
+		log.Fatal(err)
+	}
+	inspect, _, err := getDocker().ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if inspect.ID == oldImageID {
+		log.Println("Image " + imageName + " is up to date.")
+		return
+	}
+	log.Println("New image " + imageName + " downloaded.")
 
-		type Event struct {
-			Status         string `json:"status"`
-			Error          string `json:"error"`
-			Progress       string `json:"progress"`
-			ProgressDetail struct {
-				Current int `json:"current"`
-				Total   int `json:"total"`
-			} `json:"progressDetail"`
-		}
+	// Containers keep the image they were created from, an update only applies to new clusters
+	if len(oldImageID) == 0 {
+		return
+	}
+	for _, containerName := range getClustersUsingImage(oldImageID) {
+		log.Println("Cluster " + containerName[len(containerNamePrefix):] + " still runs the old image " + shortDigest(oldImageID) + ", purge and start it again to use the new one.")
+	}
+}
 
-		var event *Event
-		for {
-			if err := d.Decode(&event); err != nil {
-				if err == io.EOF {
-					break
-				}
-				log.Fatal(err)
-			}
+// getClustersUsingImage returns the running clusters created from an image ID
+func getClustersUsingImage(imageID string) []string {
+	var containerNames []string
+	for _, containerName := range getNanoContainers() {
+		inspect, err := getDocker().ContainerInspect(ctx, containerName)
+		if err != nil {
+			continue
 		}
-
-		if event != nil {
-			if strings.Contains(event.Status, fmt.Sprintf("Downloaded newer image for %s", imageName)) {
-				log.Println("New image " + imageName + " downloaded.")
-			}
-
-			if strings.Contains(event.Status, fmt.Sprintf("Image is up to date for %s", imageName)) {
-				log.Println("Image " + imageName + " is up to date.")
-			}
+		if inspect.Image == imageID && inspect.State != nil && inspect.State.Running {
+			containerNames = append(containerNames, containerName)
 		}
 	}
+	return containerNames
 }
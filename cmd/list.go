@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"strings"
 
 	"github.com/apcera/termtables"
 	"github.com/docker/docker/api/types"
@@ -79,3 +80,26 @@ func showNanoClusters() {
 	}
 	fmt.Println(table.Render())
 }
+
+// getNanoContainers returns the names of all the containers managed by cn
+func getNanoContainers() []string {
+	listOptions := types.ContainerListOptions{
+		All:   true,
+		Quiet: true,
+	}
+	containers, err := getDocker().ContainerList(ctx, listOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var containerNames []string
+	for _, container := range containers {
+		for i := range container.Names {
+			// container names returned by Docker start with a '/'
+			if strings.HasPrefix(container.Names[i], "/"+containerNamePrefix) {
+				containerNames = append(containerNames, container.Names[i][1:])
+			}
+		}
+	}
+	return containerNames
+}
@@ -23,12 +23,86 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/spf13/cobra"
 )
 
+var (
+	// logsDaemon is the daemon to show the logs of
+	logsDaemon string
+
+	// logsFollow keeps streaming the logs
+	logsFollow bool
+
+	// logsSince only shows the logs newer than a duration
+	logsSince string
+
+	// logsGrep only shows the lines matching a regular expression
+	logsGrep string
+
+	// logDaemons are the Ceph daemons running inside a cluster
+	logDaemons = []string{"mon", "mgr", "osd", "rgw"}
+)
+
+// logFilter selects the log lines to print
+type logFilter struct {
+	since   time.Time
+	pattern *regexp.Regexp
+}
+
+// lineWriter serializes the lines written by concurrent log streams
+type lineWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (lw *lineWriter) writeLine(line string) {
+	lw.Lock()
+	defer lw.Unlock()
+	fmt.Fprintln(lw.w, line)
+}
+
+// tailProcesses are the PIDs of the tail processes following the logs inside a container
+// Docker doesn't kill an exec'd process when the client goes away, cn kills them on exit
+type tailProcesses struct {
+	sync.Mutex
+	pids []string
+}
+
+func (tp *tailProcesses) add(pid string) {
+	if _, err := strconv.Atoi(pid); err != nil {
+		return
+	}
+	tp.Lock()
+	defer tp.Unlock()
+	tp.pids = append(tp.pids, pid)
+}
+
+// kill stops the tail processes still running inside the container
+func (tp *tailProcesses) kill(containerName string) {
+	tp.Lock()
+	defer tp.Unlock()
+	if len(tp.pids) > 0 {
+		execContainer(containerName, append([]string{"kill"}, tp.pids...))
+	}
+}
+
 // cliClusterLogs is the Cobra CLI call
 func cliClusterLogs() *cobra.Command {
 	cmd := &cobra.Command{
@@ -36,24 +110,185 @@ func cliClusterLogs() *cobra.Command {
 		Short: "Print an object storage server logs",
 		Args:  cobra.ExactArgs(1),
 		Run:   logsNano,
-		DisableFlagsInUseLine: true,
+		Example: "cn cluster logs mycluster \n" +
+			"cn cluster logs mycluster --daemon all --follow \n" +
+			"cn cluster logs mycluster --daemon osd --since 10m --grep 'slow request' \n",
 	}
+	cmd.Flags().SortFlags = false
+	cmd.Flags().StringVar(&logsDaemon, "daemon", "rgw", "Daemon to show the logs of: "+strings.Join(logDaemons, ", ")+", container (the entrypoint) or all")
+	cmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep streaming the logs")
+	cmd.Flags().StringVar(&logsSince, "since", "", "Only show the logs newer than a duration (e.g: 10m, 2h)")
+	cmd.Flags().StringVar(&logsGrep, "grep", "", "Only show the lines matching a regular expression")
 
 	return cmd
 }
 
-// logsNano prints rgw logs
+// logsNano prints the logs of the Ceph daemons
 func logsNano(cmd *cobra.Command, args []string) {
 	containerName := containerNamePrefix + args[0]
-	showS3Logs(containerName)
+	notExistCheck(containerName)
+
+	daemons, err := getLogDaemons(logsDaemon)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var filter logFilter
+	if len(logsSince) > 0 {
+		since, err := time.ParseDuration(logsSince)
+		if err != nil {
+			log.Fatal("Wrong duration passed to --since: " + logsSince + ". Please use a duration like 10m or 2h.")
+		}
+		filter.since = time.Now().Add(-since)
+	}
+	if len(logsGrep) > 0 {
+		if filter.pattern, err = regexp.Compile(logsGrep); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Daemon log files can only be read from a running container
+	if logsDaemon != "container" {
+		notRunningCheck(containerName)
+	}
+
+	out := &lineWriter{w: os.Stdout}
+	if !logsFollow {
+		// Print the logs one daemon after the other so they don't interleave
+		for _, daemon := range daemons {
+			streamDaemonLogs(containerName, daemon, false, filter, out, nil)
+		}
+		return
+	}
+
+	tails := &tailProcesses{}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		tails.kill(containerName)
+		os.Exit(130)
+	}()
+
+	var wg sync.WaitGroup
+	for _, daemon := range daemons {
+		wg.Add(1)
+		go func(daemon string) {
+			defer wg.Done()
+			streamDaemonLogs(containerName, daemon, true, filter, out, tails)
+		}(daemon)
+	}
+	wg.Wait()
+}
+
+// getLogDaemons validates the --daemon value and returns the daemons to show the logs of
+func getLogDaemons(daemon string) ([]string, error) {
+	if daemon == "all" {
+		return logDaemons, nil
+	}
+	if daemon == "container" {
+		return []string{daemon}, nil
+	}
+	for _, d := range logDaemons {
+		if d == daemon {
+			return []string{daemon}, nil
+		}
+	}
+	return nil, errors.New("Unknown daemon " + daemon + ", please use one of: " + strings.Join(logDaemons, ", ") + ", container or all")
+}
+
+// getDaemonLogPath returns the path of the log file of a daemon inside the container
+func getDaemonLogPath(containerName string, daemon string) string {
+	switch daemon {
+	case "rgw":
+		return getRGWLogPath(containerName)
+	case "osd":
+		return "/var/log/ceph/ceph-osd.0.log"
+	default:
+		return "/var/log/ceph/ceph-" + daemon + "." + containerName + "-faa32aebf00b.log"
+	}
+}
+
+// streamDaemonLogs prints the log lines of a daemon prefixed with its name
+// The tail processes following the daemon log files are recorded in tails
+func streamDaemonLogs(containerName string, daemon string, follow bool, filter logFilter, out *lineWriter, tails *tailProcesses) {
+	var stream io.ReadCloser
+	var stderr bytes.Buffer
+	var err error
+
+	if daemon == "container" {
+		options := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: follow}
+		if len(logsSince) > 0 {
+			options.Since = logsSince
+		}
+		var logs io.ReadCloser
+		if logs, err = getDocker().ContainerLogs(ctx, containerName, options); err == nil {
+			stream = demultiplexStream(logs, &stderr)
+		}
+		// Docker already filtered on the date
+		filter.since = time.Time{}
+	} else if follow {
+		// The shell prints the PID tail gets, for cn to kill it on exit
+		c := []string{"sh", "-c", `echo $$; exec tail -n +1 -F "$0"`, getDaemonLogPath(containerName, daemon)}
+		stream, err = execContainerStream(containerName, c, &stderr)
+	} else {
+		stream, err = execContainerStream(containerName, []string{"cat", getDaemonLogPath(containerName, daemon)}, &stderr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	if follow && daemon != "container" {
+		pid, _ := reader.ReadString('\n')
+		tails.add(strings.TrimSpace(pid))
+	}
+	filterLogLines(reader, daemon, filter, out)
+
+	// A daemon which never logged anything has no log file yet, that's not an error
+	if stderr.Len() > 0 && !strings.Contains(stderr.String(), "No such file or directory") {
+		fmt.Fprint(os.Stderr, stderr.String())
+	}
+}
+
+// filterLogLines prints the lines of a log matching the filter prefixed with the daemon name
+// Lines without a timestamp (e.g: stack traces) belong to the previous line
+func filterLogLines(r io.Reader, daemon string, filter logFilter, out *lineWriter) {
+	keep := filter.since.IsZero()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !filter.since.IsZero() {
+			if timestamp, err := parseCephLogTimestamp(line); err == nil {
+				keep = !timestamp.Before(filter.since)
+			}
+		}
+		if !keep || (filter.pattern != nil && !filter.pattern.MatchString(line)) {
+			continue
+		}
+		out.writeLine("[" + daemon + "] " + line)
+	}
 }
 
 func showS3Logs(containerName string) {
 	notExistCheck(containerName)
-	c := []string{"cat", "/var/log/ceph/client.rgw." + containerName + "-faa32aebf00b.log"}
-	output := execContainer(containerName, c)
-	if strings.Contains("No such file or directory", output) {
-		return
-	}
-	fmt.Printf("%s", output)
+	streamDaemonLogs(containerName, "rgw", false, logFilter{}, &lineWriter{w: os.Stdout}, nil)
+}
+
+// getRGWLogPath returns the path of the RGW log file inside the container
+func getRGWLogPath(containerName string) string {
+	return "/var/log/ceph/client.rgw." + containerName + "-faa32aebf00b.log"
+}
+
+// demultiplexStream splits a Docker multiplexed stream, stdout is returned as a reader
+func demultiplexStream(multiplexed io.ReadCloser, stderr io.Writer) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(writer, stderr, multiplexed)
+		multiplexed.Close()
+		writer.CloseWithError(err)
+	}()
+	return reader
 }
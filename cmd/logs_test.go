@@ -0,0 +1,104 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLogDaemons(t *testing.T) {
+	tests := []struct {
+		daemon   string
+		expected []string
+	}{
+		{"rgw", []string{"rgw"}},
+		{"mon", []string{"mon"}},
+		{"mgr", []string{"mgr"}},
+		{"osd", []string{"osd"}},
+		{"container", []string{"container"}},
+		{"all", []string{"mon", "mgr", "osd", "rgw"}},
+		{"mds", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		daemons, err := getLogDaemons(test.daemon)
+		assert.Equal(t, test.expected, daemons, test.daemon)
+		assert.Equal(t, test.expected == nil, err != nil, test.daemon)
+	}
+}
+
+func TestFilterLogLines(t *testing.T) {
+	log := "2019-03-01 10:00:00.000000 7f2b 1 starting\n" +
+		"2019-03-01 10:20:00.000000 7f2b -1 slow request\n" +
+		" 1: (ceph::__ceph_assert_fail()+0x12) [0x55d]\n" +
+		"2021-03-01T10:30:00.000+0000 7f2b 1 req done\n"
+	tests := []struct {
+		name     string
+		filter   logFilter
+		expected []string
+	}{
+		{"no filter", logFilter{}, []string{
+			"[osd] 2019-03-01 10:00:00.000000 7f2b 1 starting",
+			"[osd] 2019-03-01 10:20:00.000000 7f2b -1 slow request",
+			"[osd]  1: (ceph::__ceph_assert_fail()+0x12) [0x55d]",
+			"[osd] 2021-03-01T10:30:00.000+0000 7f2b 1 req done",
+		}},
+		// Continuation lines of a kept entry are kept, older entries are dropped
+		{"since", logFilter{since: time.Date(2019, 3, 1, 10, 10, 0, 0, time.UTC)}, []string{
+			"[osd] 2019-03-01 10:20:00.000000 7f2b -1 slow request",
+			"[osd]  1: (ceph::__ceph_assert_fail()+0x12) [0x55d]",
+			"[osd] 2021-03-01T10:30:00.000+0000 7f2b 1 req done",
+		}},
+		{"since everything", logFilter{since: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}, nil},
+		{"grep", logFilter{pattern: regexp.MustCompile("slow|done")}, []string{
+			"[osd] 2019-03-01 10:20:00.000000 7f2b -1 slow request",
+			"[osd] 2021-03-01T10:30:00.000+0000 7f2b 1 req done",
+		}},
+		{"since and grep", logFilter{since: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), pattern: regexp.MustCompile("slow|done")}, []string{
+			"[osd] 2021-03-01T10:30:00.000+0000 7f2b 1 req done",
+		}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		filterLogLines(strings.NewReader(log), "osd", test.filter, &lineWriter{w: &buf})
+		var lines []string
+		if buf.Len() > 0 {
+			lines = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		}
+		assert.Equal(t, test.expected, lines, test.name)
+	}
+}
+
+func TestTailProcesses(t *testing.T) {
+	tails := &tailProcesses{}
+	tails.add("42")
+	tails.add("")
+	tails.add("sh: no such file")
+	assert.Equal(t, []string{"42"}, tails.pids)
+}
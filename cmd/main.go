@@ -53,10 +53,12 @@ const (
                  /(((.  /(((((  /(((((
                         .((((/ (/
 `
-	cephNanoUID         = "nano"                                          // cephNanoUID is the uid of the S3 user
-	containerNamePrefix = "ceph-nano-"                                    // containerNamePrefix is name of the container
-	tempPath            = "/tmp/"                                         // tempPath is the temporary path inside the container
-	githubCNReleasesURL = "https://api.github.com/repos/ceph/cn/releases" // githubCNReleasesURL is the GitHub URL of cn releases
+	cephNanoUID            = "nano"                                          // cephNanoUID is the uid of the S3 user
+	containerNamePrefix    = "ceph-nano-"                                    // containerNamePrefix is name of the container
+	tempPath               = "/tmp/"                                         // tempPath is the temporary path inside the container
+	githubCNReleasesURL    = "https://api.github.com/repos/ceph/cn/releases" // githubCNReleasesURL is the GitHub URL of cn releases
+	rgwInternalPort        = "7480"                                          // rgwInternalPort is the plain text RGW port inside the container when TLS is enabled
+	prometheusInternalPort = "9283"                                          // prometheusInternalPort is the port of the mgr prometheus module inside the container
 )
 
 var (
@@ -127,30 +129,47 @@ func getDocker() *client.Client {
 // Main is the main function calling the whole program
 func Main(version string) {
 	cnVersion = version
-	if enableUpdateNotification {
-		checkUpdateNotification()
+	// The latest release is checked while the command runs, it never delays nor fails it
+	notify := enableUpdateNotification && startUpdateCheck(os.Args[1:])
+	err := rootCmd.Execute()
+	if notify {
+		printUpdateNotification()
 	}
-	if err := rootCmd.Execute(); err != nil {
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
 func init() {
-	if configurationFile = readConfigFile(); len(configurationFile) > 0 {
+	// --config replaces the search of a configuration file
+	if configFlag = getConfigFlag(os.Args[1:]); len(configFlag) > 0 {
+		configurationFile = readConfigFile(configFlag)
+	} else {
+		configurationFile = readConfigFile()
+	}
+	if len(configurationFile) > 0 {
 		fmt.Fprintf(os.Stderr, "Using %s as configuration file\n", configurationFile)
 	}
 
 	rootCmd.AddCommand(
 		cmdCluster,
+		cliCephNano(),
+		cliRadosgwAdminNano(),
 		cmdS3,
 		cmdImage,
 		cliVersionNano(),
 		cliKubeNano(),
 		cliUpdateCheckNano(),
+		cliSelfUpdate(),
+		cliUpdateCheckBackground(),
 		cmdFlavors,
+		cmdPKI,
+		cmdMetrics,
+		cmdConfig,
 		cmdCompletion,
 	)
+	rootCmd.PersistentFlags().StringVar(&configFlag, "config", "", "Configuration file to use instead of searching for cn.toml")
 	rootCmd.SetHelpCommand(&cobra.Command{
 		Use:    "no-help",
 		Hidden: true,
@@ -0,0 +1,95 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// memoryStoragePrefix marks a data store living in memory, e.g: memory:4GB
+	memoryStoragePrefix = "memory:"
+
+	// memoryStorage is the value of the storage flavor item keeping Ceph's data in memory
+	memoryStorage = "memory"
+
+	// memoryStorageHeadroom is the memory the Ceph daemons need next to the tmpfs
+	memoryStorageHeadroom = 384 * 1024 * 1024
+
+	// cephConfigTmpfsSize is the size of the tmpfs holding /etc/ceph, keys and ceph.conf are tiny
+	cephConfigTmpfsSize = "16m"
+)
+
+// getStorage returns how a flavor stores Ceph's data, an empty string means on disk
+func getStorage(containerFlavor string) string {
+	// -b memory:SIZE or a data = "memory:SIZE" flavor
+	if strings.HasPrefix(getUnderlyingStorage(containerFlavor), memoryStoragePrefix) {
+		return memoryStorage
+	}
+
+	// Flavors not inheriting from default may not define it
+	if !isParameterExist(FLAVORS, containerFlavor, "storage") {
+		return ""
+	}
+	return getStringFromConfig(FLAVORS, containerFlavor, "storage")
+}
+
+// getMemoryStorageSizeInBytes returns the size of the tmpfs of a memory flavor
+// It comes from -b memory:SIZE or the data item, else from the size item, else it's what the Ceph daemons leave
+func getMemoryStorageSizeInBytes(containerFlavor string) int64 {
+	if data := getUnderlyingStorage(containerFlavor); strings.HasPrefix(data, memoryStoragePrefix) {
+		return toBytes(strings.TrimPrefix(data, memoryStoragePrefix))
+	}
+	if size := getSize(containerFlavor); len(size) > 0 {
+		return toBytes(size)
+	}
+	return getMemorySizeInBytes(containerFlavor) - memoryStorageHeadroom
+}
+
+// checkMemoryStorageSize ensures the tmpfs fits in the memory of the container
+// tmpfs pages are charged to the container, the OSD would be killed before the tmpfs gets full otherwise
+func checkMemoryStorageSize(storageSize int64, memorySize int64) error {
+	if storageSize <= 0 {
+		return fmt.Errorf("the memory storage size must be positive")
+	}
+	if storageSize+memoryStorageHeadroom > memorySize {
+		return fmt.Errorf("a memory storage of %d bytes needs a memory_size of at least %d bytes (%d bytes are needed by the Ceph daemons), use a bigger flavor or a smaller storage",
+			storageSize, storageSize+memoryStorageHeadroom, memoryStorageHeadroom)
+	}
+	return nil
+}
+
+// isMemoryStorageCluster reports if a cluster keeps its data in memory
+func isMemoryStorageCluster(containerName string) bool {
+	return dockerInspect(containerName, "storage") == memoryStorage
+}
+
+// getMemoryStorageTmpfs returns the tmpfs mounts backing /var/lib/ceph and /etc/ceph of a memory flavor
+func getMemoryStorageTmpfs(storageSize int64) map[string]string {
+	return map[string]string{
+		"/var/lib/ceph": "rw,size=" + strconv.FormatInt(storageSize, 10),
+		"/etc/ceph":     "rw,size=" + cephConfigTmpfsSize,
+	}
+}
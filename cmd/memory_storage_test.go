@@ -0,0 +1,59 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	defer func(bck string) { dataOsd = bck }(dataOsd)
+
+	dataOsd = "memory:2GB"
+	assert.Equal(t, memoryStorage, getStorage("default"))
+	assert.Equal(t, int64(2*1024*1024*1024), getMemoryStorageSizeInBytes("default"))
+
+	// Without a size, the storage gets what the Ceph daemons leave
+	dataOsd = ""
+	assert.Equal(t, int64(512*1024*1024-memoryStorageHeadroom), getMemoryStorageSizeInBytes("default"))
+
+	gb := int64(1024 * 1024 * 1024)
+
+	// The flavor's data item works like -b
+	readConfigFile(configFile)
+	assert.Equal(t, memoryStorage, getStorage("test_memory_data"))
+	assert.Equal(t, gb, getMemoryStorageSizeInBytes("test_memory_data"))
+	dataOsd = "memory:512MB"
+	assert.Equal(t, int64(512*1024*1024), getMemoryStorageSizeInBytes("test_memory_data"))
+	dataOsd = ""
+
+	assert.Nil(t, checkMemoryStorageSize(2*gb, 4*gb))
+	assert.NotNil(t, checkMemoryStorageSize(4*gb, 4*gb))
+	assert.NotNil(t, checkMemoryStorageSize(0, 4*gb))
+
+	tmpfs := getMemoryStorageTmpfs(gb)
+	assert.Equal(t, "rw,size=1073741824", tmpfs["/var/lib/ceph"])
+	assert.Contains(t, tmpfs, "/etc/ceph")
+}
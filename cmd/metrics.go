@@ -0,0 +1,215 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	cmdMetrics = &cobra.Command{
+		Use:   "metrics [command]",
+		Short: "Expose metrics of Ceph Nano clusters",
+		Args:  cobra.NoArgs,
+	}
+
+	// metricsListenAddress is the address the exporter listens on
+	metricsListenAddress string
+
+	// clusterStates are the container states reported by cn_cluster_state
+	clusterStates = []string{"created", "running", "paused", "restarting", "exited", "dead"}
+)
+
+// metricSample is one value of a metric family
+type metricSample struct {
+	labels map[string]string
+	value  float64
+}
+
+// metricFamily is a metric with its samples, as exposed to Prometheus
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []metricSample
+}
+
+func init() {
+	cmdMetrics.AddCommand(
+		cliMetricsServe(),
+	)
+}
+
+// cliMetricsServe is the Cobra CLI call
+func cliMetricsServe() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve cn metrics of all clusters to Prometheus",
+		Args:  cobra.NoArgs,
+		Run:   metricsServeNano,
+		Example: "cn metrics serve \n" +
+			"cn metrics serve --listen 0.0.0.0:9284 \n",
+	}
+	cmd.Flags().StringVar(&metricsListenAddress, "listen", "127.0.0.1:9284", "Address to listen on")
+
+	return cmd
+}
+
+// metricsServeNano runs the exporter until it's interrupted
+func metricsServeNano(cmd *cobra.Command, args []string) {
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, collectClusterMetrics())
+	})
+	log.Println("Serving metrics on http://" + metricsListenAddress + "/metrics")
+	log.Fatal(http.ListenAndServe(metricsListenAddress, nil))
+}
+
+// collectClusterMetrics gathers the metrics of all nano clusters
+func collectClusterMetrics() []metricFamily {
+	info := metricFamily{name: "cn_cluster_info", help: "Flavor and image of a cluster.", kind: "gauge"}
+	state := metricFamily{name: "cn_cluster_state", help: "Current state of a cluster container.", kind: "gauge"}
+	startDuration := metricFamily{name: "cn_cluster_start_duration_seconds", help: "Time the last start of a cluster took to be healthy.", kind: "gauge"}
+	healthFailures := metricFamily{name: "cn_cluster_health_check_failures_total", help: "Number of times a cluster failed to become healthy.", kind: "counter"}
+	buckets := metricFamily{name: "cn_cluster_buckets", help: "Number of S3 buckets of a cluster.", kind: "gauge"}
+	objects := metricFamily{name: "cn_cluster_objects", help: "Number of S3 objects of a cluster.", kind: "gauge"}
+	scrapeErrors := metricFamily{name: "cn_cluster_scrape_errors", help: "Number of cluster metrics that couldn't be collected.", kind: "gauge"}
+
+	for _, containerName := range getNanoContainers() {
+		containerNameToShow := containerName[len(containerNamePrefix):]
+		cluster := map[string]string{"cluster": containerNameToShow}
+		errorCount := 0
+
+		inspect, err := getDocker().ContainerInspect(ctx, containerName)
+		if err != nil {
+			log.Println("Unable to inspect cluster " + containerNameToShow + ": " + err.Error())
+			scrapeErrors.samples = append(scrapeErrors.samples, metricSample{cluster, 1})
+			continue
+		}
+		info.samples = append(info.samples, metricSample{
+			map[string]string{"cluster": containerNameToShow, "flavor": inspect.Config.Labels["flavor"], "image": inspect.Config.Image},
+			1,
+		})
+		for _, s := range clusterStates {
+			value := 0.0
+			if inspect.State != nil && inspect.State.Status == s {
+				value = 1
+			}
+			state.samples = append(state.samples, metricSample{map[string]string{"cluster": containerNameToShow, "state": s}, value})
+		}
+
+		record := readClusterRecord(containerNameToShow)
+		if record.StartDuration > 0 {
+			startDuration.samples = append(startDuration.samples, metricSample{cluster, record.StartDuration})
+		}
+		healthFailures.samples = append(healthFailures.samples, metricSample{cluster, float64(record.HealthCheckFailures)})
+
+		if inspect.State != nil && inspect.State.Running {
+			bucketCount, objectCount, err := getBucketAndObjectCounts(containerName)
+			if err != nil {
+				log.Println("Unable to get the bucket stats of cluster " + containerNameToShow + ": " + err.Error())
+				errorCount++
+			} else {
+				buckets.samples = append(buckets.samples, metricSample{cluster, float64(bucketCount)})
+				objects.samples = append(objects.samples, metricSample{cluster, float64(objectCount)})
+			}
+		}
+		scrapeErrors.samples = append(scrapeErrors.samples, metricSample{cluster, float64(errorCount)})
+	}
+
+	return []metricFamily{info, state, startDuration, healthFailures, buckets, objects, scrapeErrors}
+}
+
+// getBucketAndObjectCounts returns the number of buckets and objects stored in a cluster
+func getBucketAndObjectCounts(containerName string) (int, int64, error) {
+	var stats []struct {
+		Usage map[string]struct {
+			NumObjects int64 `json:"num_objects"`
+		} `json:"usage"`
+	}
+	if err := execContainerJSON(containerName, []string{"radosgw-admin", "bucket", "stats"}, &stats); err != nil {
+		return 0, 0, err
+	}
+	var objectCount int64
+	for _, bucket := range stats {
+		for _, usage := range bucket.Usage {
+			objectCount += usage.NumObjects
+		}
+	}
+	return len(stats), objectCount, nil
+}
+
+// writeMetrics writes metric families in the Prometheus text format
+func writeMetrics(w io.Writer, families []metricFamily) {
+	var buf bytes.Buffer
+	for _, family := range families {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", family.name, family.kind)
+		for _, sample := range family.samples {
+			fmt.Fprintf(&buf, "%s%s %v\n", family.name, formatMetricLabels(sample.labels), sample.value)
+		}
+	}
+	w.Write(buf.Bytes())
+}
+
+// formatMetricLabels returns the labels of a sample sorted by name, e.g: {cluster="foo",state="running"}
+func formatMetricLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var pairs []string
+	for _, name := range names {
+		pairs = append(pairs, name+`="`+escaper.Replace(labels[name])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// enablePrometheusModule enables the mgr prometheus module of clusters publishing its port
+// Metrics are a convenience, failing to enable them doesn't fail the start
+func enablePrometheusModule(containerName string) {
+	if len(dockerInspect(containerName, "prometheus_port")) == 0 {
+		return
+	}
+	var stdout, stderr bytes.Buffer
+	exitCode, err := execContainerWithStdio(containerName, []string{"ceph", "mgr", "module", "enable", "prometheus"}, nil, &stdout, &stderr)
+	if err != nil {
+		log.Println("Warning: unable to enable the mgr prometheus module: " + err.Error())
+	} else if exitCode != 0 {
+		log.Println("Warning: unable to enable the mgr prometheus module: " + strings.TrimSpace(stderr.String()))
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, []metricFamily{
+		{
+			name: "cn_cluster_state",
+			help: "Current state of a cluster container.",
+			kind: "gauge",
+			samples: []metricSample{
+				{map[string]string{"state": "running", "cluster": "foo"}, 1},
+				{map[string]string{"state": "exited", "cluster": "foo"}, 0},
+			},
+		},
+		{
+			name:    "cn_cluster_start_duration_seconds",
+			help:    "Time the last start of a cluster took to be healthy.",
+			kind:    "gauge",
+			samples: []metricSample{{map[string]string{"cluster": "foo"}, 42.5}},
+		},
+	})
+
+	expected := `# HELP cn_cluster_state Current state of a cluster container.
+# TYPE cn_cluster_state gauge
+cn_cluster_state{cluster="foo",state="running"} 1
+cn_cluster_state{cluster="foo",state="exited"} 0
+# HELP cn_cluster_start_duration_seconds Time the last start of a cluster took to be healthy.
+# TYPE cn_cluster_start_duration_seconds gauge
+cn_cluster_start_duration_seconds{cluster="foo"} 42.5
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestFormatMetricLabels(t *testing.T) {
+	assert.Equal(t, "", formatMetricLabels(nil))
+	assert.Equal(t, `{image="a\"b\\c"}`, formatMetricLabels(map[string]string{"image": `a"b\c`}))
+}
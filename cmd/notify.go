@@ -23,47 +23,176 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"os"
+	"os/exec"
+	"syscall"
 	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// updateCheckTimeout bounds the check of the latest release
+	updateCheckTimeout = 10 * time.Second
+
+	// updateCheckCommand is the hidden command checking the latest release in the background
+	updateCheckCommand = "update-check-background"
 )
 
 var (
-	timeLayout              = time.RFC1123
-	lastUpdateCheckFilePath = makeCephNanoPath("last_update_check")
+	// updateCheckFilePath caches the result of the last check of the latest release
+	updateCheckFilePath = makeCephNanoPath("update_check.json")
+
+	// updateNoticeFilePath holds the time the update notice was last printed
+	updateNoticeFilePath = makeCephNanoPath("update_notice")
+
+	// updateCheckSkippedCommands already deal with the releases
+	updateCheckSkippedCommands = []string{"update-check", "self-update", "completion", updateCheckCommand}
 )
 
-func checkUpdateNotification() {
-	if !shouldCheckURLVersion(lastUpdateCheckFilePath) {
-		return
+// updateCheck is the result of the last check of the latest release
+type updateCheck struct {
+	CheckedAt     time.Time `json:"checked_at"`     // CheckedAt is when the releases were last checked
+	LatestVersion string    `json:"latest_version"` // LatestVersion is the latest release found, empty if the releases were never reached
+}
+
+// cliUpdateCheckBackground is the Cobra CLI call
+func cliUpdateCheckBackground() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    updateCheckCommand,
+		Short:  "Cache the latest release for the update notice",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkLatestRelease(updateCheckFilePath, getReleasesURL(), getStringFromConfig(UPDATE, "config", "channel"), updateCheckTimeout)
+		},
+	}
+	return cmd
+}
+
+// startUpdateCheck looks for the latest release in the background when the last check is old enough
+// It reports if the notification is wanted, printUpdateNotification() prints it once the command is done
+func startUpdateCheck(args []string) bool {
+	if !isUpdateNotificationWanted(args) {
+		return false
+	}
+	period := getFloat64FromConfig(UPDATE, "config", "reminder_wait_period_in_hours")
+	if isUpdatePeriodOver(readUpdateCheck(updateCheckFilePath).CheckedAt, time.Now(), period) {
+		spawnUpdateCheck()
 	}
-	updateCheckNano(nil, nil)
-	writeTimeToFile(lastUpdateCheckFilePath, time.Now().UTC())
+	return true
 }
 
-func shouldCheckURLVersion(filePath string) bool {
-	if !getBoolFromConfig("update", "config", "want_update_notification") {
+// isUpdateNotificationWanted tells if the latest release has to be checked and notified
+// CI jobs and scripts reading the output of cn are never bothered with it
+func isUpdateNotificationWanted(args []string) bool {
+	if !getBoolFromConfig(UPDATE, "config", "want_update_notification") {
 		return false
 	}
-	lastUpdateTime := getTimeFromFileIfExists(filePath)
-	return time.Since(lastUpdateTime).Hours() >= getFloat64FromConfig("update", "config", "reminder_wait_period_in_hours")
+	if len(os.Getenv("CI")) > 0 || !terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return false
+	}
+	return len(args) == 0 || !isStringInSlice(args[0], updateCheckSkippedCommands)
 }
 
-func writeTimeToFile(path string, inputTime time.Time) {
-	err := ioutil.WriteFile(path, []byte(inputTime.Format(timeLayout)), 0644)
+// isUpdatePeriodOver tells if a check or a notice is older than the reminder period
+func isUpdatePeriodOver(last time.Time, now time.Time, periodInHours float64) bool {
+	return now.Sub(last).Hours() >= periodInHours
+}
+
+// spawnUpdateCheck runs the check in a process of its own, it outlives quick commands like 'cn version'
+// Its output goes nowhere and a failure to start it is ignored, the next command tries again
+func spawnUpdateCheck() {
+	binary, err := os.Executable()
 	if err != nil {
-		log.Fatalf("Error writing current update time to file: %v", err)
+		return
+	}
+	args := []string{updateCheckCommand}
+	if len(configFlag) > 0 {
+		args = append([]string{"--config", configFlag}, args...)
+	}
+	check := exec.Command(binary, args...)
+	// A session of its own keeps it running when the terminal of the command goes away
+	check.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := check.Start(); err == nil {
+		check.Process.Release()
+	}
+}
+
+// checkLatestRelease caches the latest release of a channel
+// Any failure, like being offline or rate limited by GitHub, keeps the version known so far
+func checkLatestRelease(path string, url string, channel string, timeout time.Duration) {
+	check := readUpdateCheck(path)
+	if releases, err := getReleases(url, timeout); err == nil {
+		if release, err := selectRelease(releases, "", channel); err == nil {
+			check.LatestVersion = release.TagName
+		}
+	}
+	check.CheckedAt = time.Now().UTC()
+	writeUpdateCheck(path, check)
+}
+
+// printUpdateNotification prints the notice of the cached latest release after the output of the command
+func printUpdateNotification() {
+	notifyUpdate(updateCheckFilePath, updateNoticeFilePath, getCurrentVersion(), time.Now(),
+		getFloat64FromConfig(UPDATE, "config", "reminder_wait_period_in_hours"), os.Stderr)
+}
+
+// notifyUpdate writes the notice when the cached latest release differs from the current version
+// The notice is written at most once per reminder period
+func notifyUpdate(checkPath string, noticePath string, currentVersion string, now time.Time, periodInHours float64, w io.Writer) {
+	notice := getUpdateNotice(currentVersion, readUpdateCheck(checkPath).LatestVersion)
+	if len(notice) == 0 || !isUpdatePeriodOver(readUpdateNotice(noticePath), now, periodInHours) {
+		return
+	}
+	fmt.Fprintln(w, notice)
+	writeFileAtomically(noticePath, []byte(now.UTC().Format(time.RFC3339)), 0644)
+}
+
+// getUpdateNotice returns the notice telling a newer release exists, an empty string otherwise
+func getUpdateNotice(currentVersion string, latestVersion string) string {
+	if len(latestVersion) == 0 || latestVersion == currentVersion {
+		return ""
+	}
+	return fmt.Sprintf("cn %s is available, this is %s. 'cn self-update' installs it.", latestVersion, currentVersion)
+}
+
+// readUpdateCheck returns the cached check, a missing or broken cache means nothing was checked yet
+func readUpdateCheck(path string) updateCheck {
+	var check updateCheck
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return updateCheck{}
+	}
+	if err := json.Unmarshal(content, &check); err != nil {
+		return updateCheck{}
+	}
+	return check
+}
+
+// writeUpdateCheck saves the check, a failure only means checking again next time
+func writeUpdateCheck(path string, check updateCheck) {
+	content, err := json.Marshal(check)
+	if err != nil {
+		return
 	}
+	writeFileAtomically(path, content, 0644)
 }
 
-func getTimeFromFileIfExists(path string) time.Time {
-	lastUpdateCheckTime, err := ioutil.ReadFile(path)
+// readUpdateNotice returns the time the notice was last printed, the zero time if never
+func readUpdateNotice(path string) time.Time {
+	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		return time.Time{}
 	}
-	timeInFile, err := time.Parse(timeLayout, string(lastUpdateCheckTime))
+	notifiedAt, err := time.Parse(time.RFC3339, string(content))
 	if err != nil {
 		return time.Time{}
 	}
-	return timeInFile
+	return notifiedAt
 }
@@ -0,0 +1,133 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tempUpdateCheckFile returns the path of a cache in a temporary directory and a function removing it
+func tempUpdateCheckFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "cn-update-check-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "update_check.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestUpdateCheckCache(t *testing.T) {
+	path, cleanup := tempUpdateCheckFile(t)
+	defer cleanup()
+
+	// A missing or broken cache means nothing was checked yet
+	assert.True(t, readUpdateCheck(path).CheckedAt.IsZero())
+	ioutil.WriteFile(path, []byte("Mon, 02 Jan 2006 15:04:05 MST"), 0644)
+	assert.True(t, readUpdateCheck(path).CheckedAt.IsZero())
+
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeUpdateCheck(path, updateCheck{CheckedAt: now, LatestVersion: "v2.3.1"})
+	check := readUpdateCheck(path)
+	assert.True(t, now.Equal(check.CheckedAt))
+	assert.Equal(t, "v2.3.1", check.LatestVersion)
+
+	assert.False(t, isUpdatePeriodOver(check.CheckedAt, now.Add(23*time.Hour), 24))
+	assert.True(t, isUpdatePeriodOver(check.CheckedAt, now.Add(24*time.Hour), 24))
+	assert.True(t, isUpdatePeriodOver(time.Time{}, now, 24))
+}
+
+func TestCheckLatestRelease(t *testing.T) {
+	path, cleanup := tempUpdateCheckFile(t)
+	defer cleanup()
+	server := newTestReleases(t, nil)
+	defer server.Close()
+
+	checkLatestRelease(path, server.URL+"/releases", releaseChannelStable, time.Second)
+	check := readUpdateCheck(path)
+	assert.Equal(t, "v2.0.0", check.LatestVersion)
+	assert.False(t, check.CheckedAt.IsZero())
+	checkLatestRelease(path, server.URL+"/releases", releaseChannelPreRelease, time.Second)
+	assert.Equal(t, "v3.0.0-rc1", readUpdateCheck(path).LatestVersion)
+
+	// A rate limit answer is not a list of releases, the version known so far is kept
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message":"API rate limit exceeded"}`))
+	}))
+	defer limited.Close()
+	checkLatestRelease(path, limited.URL, releaseChannelStable, time.Second)
+	check = readUpdateCheck(path)
+	assert.Equal(t, "v3.0.0-rc1", check.LatestVersion)
+	assert.False(t, check.CheckedAt.IsZero())
+
+	// A server which doesn't answer in time
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer hanging.Close()
+	os.Remove(path)
+	checkLatestRelease(path, hanging.URL, releaseChannelStable, 100*time.Millisecond)
+	assert.Equal(t, "", readUpdateCheck(path).LatestVersion)
+}
+
+func TestNotifyUpdate(t *testing.T) {
+	path, cleanup := tempUpdateCheckFile(t)
+	defer cleanup()
+	noticePath := filepath.Join(filepath.Dir(path), "update_notice")
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	var notice bytes.Buffer
+
+	// Nothing is known yet
+	notifyUpdate(path, noticePath, "v2.3.1", now, 24, &notice)
+	assert.Equal(t, "", notice.String())
+
+	// The cached version is notified once per period
+	writeUpdateCheck(path, updateCheck{CheckedAt: now, LatestVersion: "v2.4.0"})
+	notifyUpdate(path, noticePath, "v2.3.1", now, 24, &notice)
+	assert.Equal(t, "cn v2.4.0 is available, this is v2.3.1. 'cn self-update' installs it.\n", notice.String())
+	assert.True(t, now.Equal(readUpdateNotice(noticePath)))
+	notice.Reset()
+	notifyUpdate(path, noticePath, "v2.3.1", now.Add(time.Hour), 24, &notice)
+	assert.Equal(t, "", notice.String())
+	notifyUpdate(path, noticePath, "v2.3.1", now.Add(24*time.Hour), 24, &notice)
+	assert.NotEqual(t, "", notice.String())
+
+	// Up to date
+	notice.Reset()
+	notifyUpdate(path, noticePath, "v2.4.0", now.Add(48*time.Hour), 24, &notice)
+	assert.Equal(t, "", notice.String())
+}
+
+func TestGetUpdateNotice(t *testing.T) {
+	assert.Equal(t, "", getUpdateNotice("v2.3.1", "v2.3.1"))
+	assert.Equal(t, "", getUpdateNotice("v2.3.1", ""))
+	assert.Equal(t, "cn v2.4.0 is available, this is v2.3.1. 'cn self-update' installs it.", getUpdateNotice("v2.3.1", "v2.4.0"))
+}
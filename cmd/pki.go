@@ -0,0 +1,415 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/cobra"
+)
+
+const (
+	pkiDirectory       = "pki"                // pkiDirectory is where cn keeps its CA, relative to ~/.cn
+	pkiCACertificate   = "ca.crt"             // pkiCACertificate is the file name of the CA certificate
+	pkiCAKey           = "ca.key"             // pkiCAKey is the file name of the CA private key
+	pkiClusterCert     = "rgw.crt"            // pkiClusterCert is the file name of a cluster certificate
+	pkiClusterKey      = "rgw.key"            // pkiClusterKey is the file name of a cluster private key
+	pkiContainerPath   = "/etc/ceph-nano/pki" // pkiContainerPath is where the cluster certificate is copied inside the container
+	pkiContainerOwner  = 167                  // pkiContainerOwner is the uid and gid of the ceph user running RGW in the container
+	pkiCAValidity      = 10 * 365 * 24 * time.Hour
+	pkiClusterValidity = 365 * 24 * time.Hour
+	pkiCACommonName    = "Ceph Nano local CA"
+	pkiOrganization    = "Ceph Nano"
+)
+
+var (
+	cmdPKI = &cobra.Command{
+		Use:   "pki [command]",
+		Short: "Interact with cn's local certificate authority",
+		Args:  cobra.NoArgs,
+	}
+)
+
+func init() {
+	cmdPKI.AddCommand(
+		cliPKIExport(),
+	)
+}
+
+// cliPKIExport is the Cobra CLI call
+func cliPKIExport() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export [TRUST_STORE_FILE]",
+		Short: "Add cn's CA certificate to a trust store file (default prints it)",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   exportPKI,
+		Example: "cn pki export \n" +
+			"cn pki export /etc/pki/ca-trust/source/anchors/ceph-nano.crt \n" +
+			"cn pki export ~/my-ca-bundle.pem \n",
+		DisableFlagsInUseLine: true,
+	}
+	return cmd
+}
+
+// exportPKI prints or appends the CA certificate to a trust store file
+func exportPKI(cmd *cobra.Command, args []string) {
+	caCertPEM, _, err := getOrCreateCA()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(args) == 0 {
+		fmt.Print(string(caCertPEM))
+		return
+	}
+
+	trustStore := args[0]
+	content, err := ioutil.ReadFile(trustStore)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	if bytes.Contains(content, caCertPEM) {
+		log.Println("CA certificate is already present in " + trustStore + ".")
+		return
+	}
+
+	f, err := os.OpenFile(trustStore, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	// Make sure we don't glue our certificate to the last line of an existing bundle
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		f.WriteString("\n")
+	}
+	if _, err := f.Write(caCertPEM); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("CA certificate added to " + trustStore + ".")
+}
+
+// makePKIPath is a utility to calculate a path inside the pki directory
+func makePKIPath(fileName ...string) string {
+	args := []string{pkiDirectory}
+	args = append(args, fileName...)
+	return makeCephNanoPath(args...)
+}
+
+// getCABundlePath returns the path of the CA certificate
+func getCABundlePath() string {
+	return makePKIPath(pkiCACertificate)
+}
+
+// getClusterPKIPath returns the directory holding the certificate of a cluster
+func getClusterPKIPath(containerNameToShow string) string {
+	return makePKIPath("clusters", containerNameToShow)
+}
+
+// getOrCreateCA loads the local CA or creates it on first use
+// A CA with a missing or unreadable file is an error, a new CA would invalidate every trust store and cluster certificate
+func getOrCreateCA() ([]byte, []byte, error) {
+	certPath := makePKIPath(pkiCACertificate)
+	keyPath := makePKIPath(pkiCAKey)
+
+	certPEM, certErr := ioutil.ReadFile(certPath)
+	keyPEM, keyErr := ioutil.ReadFile(keyPath)
+	if certErr == nil && keyErr == nil {
+		return certPEM, keyPEM, nil
+	}
+	if !os.IsNotExist(certErr) || !os.IsNotExist(keyErr) {
+		for _, err := range []error{certErr, keyErr} {
+			if err != nil && !os.IsNotExist(err) {
+				return nil, nil, fmt.Errorf("unable to read the local certificate authority: %s", err)
+			}
+		}
+		missing := certPath
+		if certErr == nil {
+			missing = keyPath
+		}
+		return nil, nil, fmt.Errorf("the local certificate authority is incomplete, %s is missing: restore it or remove %s to create a new one (the clusters and trust stores using the current one won't trust it)", missing, makePKIPath())
+	}
+
+	if err := os.MkdirAll(makePKIPath(), 0700); err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: pkiCACommonName, Organization: []string{pkiOrganization}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(pkiCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM, err = encodeECKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := ioutil.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, nil, err
+	}
+	log.Println("Created a local certificate authority in " + makePKIPath())
+	return certPEM, keyPEM, nil
+}
+
+// issueClusterCertificate signs a certificate for a cluster with the given SANs
+// The certificate and its key are written in the cluster's pki directory which is returned
+func issueClusterCertificate(containerNameToShow string, ips []net.IP, hostnames []string) (string, error) {
+	caCertPEM, caKeyPEM, err := getOrCreateCA()
+	if err != nil {
+		return "", err
+	}
+
+	caCert, caKey, err := parseCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return "", err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: containerNamePrefix + containerNameToShow, Organization: []string{pkiOrganization}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(pkiClusterValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  ips,
+		DNSNames:     hostnames,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", err
+	}
+
+	keyPEM, err := encodeECKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	// Only the user running cn reads the key on the host, the container gets its own copy
+	clusterPath := getClusterPKIPath(containerNameToShow)
+	if err := os.MkdirAll(clusterPath, 0700); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(clusterPath, 0700); err != nil {
+		return "", err
+	}
+
+	// RGW expects the full chain in the certificate file
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certPEM = append(certPEM, caCertPEM...)
+	if err := ioutil.WriteFile(filepath.Join(clusterPath, pkiClusterCert), certPEM, 0644); err != nil {
+		return "", err
+	}
+	keyPath := filepath.Join(clusterPath, pkiClusterKey)
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return "", err
+	}
+	// WriteFile keeps the mode of a key written by an older cn
+	if err := os.Chmod(keyPath, 0600); err != nil {
+		return "", err
+	}
+	return clusterPath, nil
+}
+
+// copyClusterCertificate copies the certificate and the key of a cluster into its created container
+// The files belong to the ceph user of the container, the key doesn't need to be readable by anyone on the host
+func copyClusterCertificate(containerID string, clusterPath string) error {
+	archive, err := makeClusterCertificateArchive(clusterPath)
+	if err != nil {
+		return err
+	}
+	return getDocker().CopyToContainer(ctx, containerID, "/", archive, types.CopyToContainerOptions{})
+}
+
+// makeClusterCertificateArchive returns a tar archive of pkiContainerPath owned by the ceph user of the container
+func makeClusterCertificateArchive(clusterPath string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dir := strings.TrimPrefix(pkiContainerPath, "/")
+	headers := []*tar.Header{{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0700}}
+	files := map[string][]byte{}
+	for _, file := range []struct {
+		name string
+		mode int64
+	}{{pkiClusterCert, 0644}, {pkiClusterKey, 0600}} {
+		content, err := ioutil.ReadFile(filepath.Join(clusterPath, file.name))
+		if err != nil {
+			return nil, err
+		}
+		name := dir + "/" + file.name
+		files[name] = content
+		headers = append(headers, &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: file.mode, Size: int64(len(content))})
+	}
+	for _, header := range headers {
+		header.Uid = pkiContainerOwner
+		header.Gid = pkiContainerOwner
+		header.ModTime = time.Now()
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(files[header.Name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// removeClusterCertificate removes the certificate of a cluster
+func removeClusterCertificate(containerNameToShow string) {
+	clusterPath := getClusterPKIPath(containerNameToShow)
+	if _, err := os.Stat(clusterPath); os.IsNotExist(err) {
+		return
+	}
+	if err := os.RemoveAll(clusterPath); err != nil {
+		log.Println("Unable to remove " + clusterPath + ": " + err.Error())
+	}
+}
+
+// getCertificateSANs returns the IPs and host names a cluster certificate must be valid for
+func getCertificateSANs(containerName string, endpointHost string) ([]net.IP, []string) {
+	ips := getHostIPs()
+	ips = append(ips, net.ParseIP("127.0.0.1"), net.ParseIP("::1"))
+
+	hostnames := []string{"localhost", containerName + "-faa32aebf00b"}
+	if hostname, err := os.Hostname(); err == nil && len(hostname) > 0 {
+		hostnames = append(hostnames, hostname)
+	}
+
+	// The advertised address can be a remote Docker host or a DNS name
+	if ip := net.ParseIP(endpointHost); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		hostnames = append(hostnames, endpointHost)
+	}
+	return ips, hostnames
+}
+
+// parseCA decodes the PEM encoded CA certificate and key
+func parseCA(certPEM []byte, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, errors.New("unable to decode CA certificate " + makePKIPath(pkiCACertificate))
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, errors.New("unable to decode CA key " + makePKIPath(pkiCAKey))
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// encodeECKey PEM encodes an ECDSA private key
+func encodeECKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// newSerialNumber returns a random certificate serial number
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// getTLSHTTPClient returns an HTTP client trusting cn's local CA
+func getTLSHTTPClient() *http.Client {
+	caCertPEM, err := ioutil.ReadFile(getCABundlePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCertPEM)
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+}
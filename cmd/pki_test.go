@@ -0,0 +1,149 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/stretchr/testify/assert"
+)
+
+// tempCephNanoHome points ~/.cn to a temporary directory, the returned function restores it
+func tempCephNanoHome(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "cn-home-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	homedir.DisableCache = true
+	restoreHome := patchEnvVar("HOME", dir)
+	return func() {
+		restoreHome()
+		homedir.DisableCache = false
+		os.RemoveAll(dir)
+	}
+}
+
+func TestIssueClusterCertificate(t *testing.T) {
+	defer tempCephNanoHome(t)()
+
+	ips, hostnames := getCertificateSANs(containerNamePrefix+"mycluster", "nano.example.com")
+	ips = append(ips, net.ParseIP("192.0.2.10"))
+	clusterPath, err := issueClusterCertificate("mycluster", ips, hostnames)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	finfo, err := os.Stat(clusterPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0700), finfo.Mode().Perm())
+	finfo, err = os.Stat(filepath.Join(clusterPath, pkiClusterKey))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), finfo.Mode().Perm())
+
+	// The certificate chains to the local CA for the advertised IPs and host names
+	caPEM, err := ioutil.ReadFile(getCABundlePath())
+	assert.Nil(t, err)
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(caPEM))
+	certPEM, err := ioutil.ReadFile(filepath.Join(clusterPath, pkiClusterCert))
+	assert.Nil(t, err)
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, name := range []string{"nano.example.com", "localhost", "127.0.0.1", "::1", "192.0.2.10", containerNamePrefix + "mycluster-faa32aebf00b"} {
+		_, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: name, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+		assert.Nil(t, err, name)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "other.example.com"})
+	assert.NotNil(t, err)
+
+	// The CA is reused
+	_, err = issueClusterCertificate("other", ips, hostnames)
+	assert.Nil(t, err)
+	sameCA, _ := ioutil.ReadFile(getCABundlePath())
+	assert.Equal(t, caPEM, sameCA)
+}
+
+func TestGetOrCreateCAIncomplete(t *testing.T) {
+	defer tempCephNanoHome(t)()
+
+	caCert, _, err := getOrCreateCA()
+	assert.Nil(t, err)
+
+	// A CA missing its key is not replaced, trust stores would stop trusting the clusters
+	assert.Nil(t, os.Remove(makePKIPath(pkiCAKey)))
+	_, _, err = getOrCreateCA()
+	assert.NotNil(t, err)
+	current, _ := ioutil.ReadFile(getCABundlePath())
+	assert.Equal(t, caCert, current)
+
+	// Once both files are gone, a new CA is created
+	assert.Nil(t, os.Remove(getCABundlePath()))
+	_, _, err = getOrCreateCA()
+	assert.Nil(t, err)
+}
+
+func TestMakeClusterCertificateArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cn-pki-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The key is needed
+	ioutil.WriteFile(filepath.Join(dir, pkiClusterCert), []byte("cert"), 0644)
+	_, err = makeClusterCertificateArchive(dir)
+	assert.NotNil(t, err)
+
+	ioutil.WriteFile(filepath.Join(dir, pkiClusterKey), []byte("key"), 0600)
+	archive, err := makeClusterCertificateArchive(dir)
+	if !assert.Nil(t, err) {
+		return
+	}
+	modes := map[string]int64{}
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		assert.Equal(t, pkiContainerOwner, header.Uid)
+		assert.Equal(t, pkiContainerOwner, header.Gid)
+		modes[header.Name] = header.Mode
+	}
+	assert.Equal(t, map[string]int64{
+		"etc/ceph-nano/pki/":        0700,
+		"etc/ceph-nano/pki/rgw.crt": 0644,
+		"etc/ceph-nano/pki/rgw.key": 0600,
+	}, modes)
+}
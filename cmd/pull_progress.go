@@ -0,0 +1,289 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// pullAttempts is how many times an interrupted pull is restarted, the layers already downloaded are kept by docker
+	pullAttempts = 3
+
+	// pullRenderInterval throttles the refresh of the progress on a terminal
+	pullRenderInterval = 100 * time.Millisecond
+
+	// progressBarWidth is the number of characters of a layer progress bar
+	progressBarWidth = 30
+)
+
+// errPullInterrupted is returned when the user interrupts a pull
+var errPullInterrupted = errors.New("pull interrupted, run the command again to resume it")
+
+// pullEvent is a message of the JSON stream of an image pull
+type pullEvent struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Error          string `json:"error"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+}
+
+// layerProgress is where a layer is in the pull
+type layerProgress struct {
+	status     string
+	downloaded int64
+	extracted  int64
+	size       int64
+}
+
+// pullProgress renders the progress of a pull
+// On a terminal all the layers are redrawn in place, otherwise a line is printed when a layer changes status
+type pullProgress struct {
+	out        io.Writer
+	tty        bool
+	start      time.Time
+	layers     map[string]*layerProgress
+	order      []string
+	status     string // status is the last message not about a layer, e.g: Status: Downloaded newer image for ceph/daemon:latest-mimic
+	lines      int    // lines is the number of lines drawn on the terminal the last time
+	lastRender time.Time
+}
+
+// newPullProgress returns a renderer writing to out
+func newPullProgress(out io.Writer, tty bool) *pullProgress {
+	return &pullProgress{out: out, tty: tty, start: time.Now(), layers: map[string]*layerProgress{}}
+}
+
+// update takes an event of the pull stream into account, the error reported by the stream is returned
+func (p *pullProgress) update(event pullEvent) error {
+	if len(event.Error) > 0 {
+		p.render(true)
+		return errors.New(event.Error)
+	}
+	// Messages about the image are either without ID or with the tag as ID (e.g: Pulling from ceph/daemon)
+	if len(event.ID) == 0 || strings.HasPrefix(event.Status, "Pulling from") {
+		p.status = event.Status
+		if !p.tty {
+			fmt.Fprintln(p.out, event.Status)
+		}
+		return nil
+	}
+
+	layer, ok := p.layers[event.ID]
+	if !ok {
+		layer = &layerProgress{}
+		p.layers[event.ID] = layer
+		p.order = append(p.order, event.ID)
+	}
+	changed := layer.status != event.Status
+	layer.status = event.Status
+	switch event.Status {
+	case "Downloading":
+		layer.downloaded, layer.size = event.ProgressDetail.Current, event.ProgressDetail.Total
+	case "Download complete", "Verifying Checksum":
+		layer.downloaded = layer.size
+	case "Extracting":
+		layer.downloaded = layer.size
+		layer.extracted = event.ProgressDetail.Current
+	case "Pull complete":
+		layer.downloaded, layer.extracted = layer.size, layer.size
+	}
+
+	if !p.tty {
+		if changed {
+			fmt.Fprintln(p.out, event.ID+": "+event.Status)
+		}
+		return nil
+	}
+	p.render(false)
+	return nil
+}
+
+// totals returns the bytes downloaded and to download of the layers whose size is known
+func (p *pullProgress) totals() (int64, int64) {
+	var downloaded, size int64
+	for _, layer := range p.layers {
+		downloaded += layer.downloaded
+		size += layer.size
+	}
+	return downloaded, size
+}
+
+// eta estimates the remaining download time from the average rate since the start
+func (p *pullProgress) eta(now time.Time) time.Duration {
+	downloaded, size := p.totals()
+	elapsed := now.Sub(p.start)
+	if downloaded <= 0 || size <= downloaded || elapsed <= 0 {
+		return 0
+	}
+	rate := float64(downloaded) / elapsed.Seconds()
+	return time.Duration(float64(size-downloaded) / rate * float64(time.Second))
+}
+
+// render redraws the layers on the terminal, at most every pullRenderInterval unless forced
+func (p *pullProgress) render(force bool) {
+	if !p.tty || (!force && time.Since(p.lastRender) < pullRenderInterval) {
+		return
+	}
+	p.lastRender = time.Now()
+	var b strings.Builder
+	// Going back to the first line drawn last time
+	if p.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", p.lines)
+	}
+	for _, id := range p.order {
+		fmt.Fprintf(&b, "\x1b[2K%s: %s\n", id, formatLayerProgress(p.layers[id]))
+	}
+	downloaded, size := p.totals()
+	summary := "Total " + humanBytes(uint64(downloaded)) + " / " + humanBytes(uint64(size))
+	if eta := p.eta(time.Now()); eta > 0 {
+		summary += ", ETA " + eta.Round(time.Second).String()
+	}
+	fmt.Fprintf(&b, "\x1b[2K%s\n", summary)
+	p.lines = len(p.order) + 1
+	fmt.Fprint(p.out, b.String())
+}
+
+// finish draws the final state of the pull
+func (p *pullProgress) finish() {
+	p.render(true)
+	if p.tty && len(p.status) > 0 {
+		fmt.Fprintln(p.out, p.status)
+	}
+}
+
+// formatLayerProgress returns the status of a layer, with a progress bar while it's downloaded or extracted
+func formatLayerProgress(layer *layerProgress) string {
+	switch {
+	case layer.status == "Downloading" && layer.size > 0:
+		return fmt.Sprintf("%-12s %s %s / %s", layer.status, progressBar(layer.downloaded, layer.size), humanBytes(uint64(layer.downloaded)), humanBytes(uint64(layer.size)))
+	case layer.status == "Extracting" && layer.size > 0:
+		return fmt.Sprintf("%-12s %s %s / %s", layer.status, progressBar(layer.extracted, layer.size), humanBytes(uint64(layer.extracted)), humanBytes(uint64(layer.size)))
+	}
+	return layer.status
+}
+
+// progressBar returns a bar like [=====>     ]
+func progressBar(current int64, total int64) string {
+	filled := 0
+	if total > 0 {
+		filled = int(current * progressBarWidth / total)
+	}
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return "[" + bar + "]"
+}
+
+// readPullStream renders the events of a pull stream and returns the last status about the image
+func readPullStream(stream io.Reader, progress *pullProgress) (string, error) {
+	d := json.NewDecoder(stream)
+	for {
+		var event pullEvent
+		if err := d.Decode(&event); err != nil {
+			if err == io.EOF {
+				progress.finish()
+				return progress.status, nil
+			}
+			progress.render(true)
+			return "", err
+		}
+		if err := progress.update(event); err != nil {
+			return "", err
+		}
+	}
+}
+
+// isTransientPullError reports if a pull failed because of the network, restarting it should succeed
+func isTransientPullError(err error) bool {
+	if err == io.ErrUnexpectedEOF {
+		return true
+	}
+	message := err.Error()
+	for _, transient := range []string{"unexpected EOF", "connection reset by peer", "i/o timeout", "TLS handshake timeout", "broken pipe", "connection refused"} {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// pullImageWithProgress pulls an image and renders its progress, the last status of the pull is returned
+// A pull interrupted by the network is restarted, docker keeps the layers already downloaded
+func pullImageWithProgress(name string) (string, error) {
+	pullCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer func() {
+		signal.Stop(interrupt)
+		close(interrupt)
+	}()
+	go func() {
+		if _, ok := <-interrupt; ok {
+			cancel()
+		}
+	}()
+
+	tty := terminal.IsTerminal(int(os.Stdout.Fd()))
+	var err error
+	for attempt := 1; attempt <= pullAttempts; attempt++ {
+		var stream io.ReadCloser
+		stream, err = getDocker().ImagePull(pullCtx, name, types.ImagePullOptions{})
+		if err == nil {
+			var status string
+			status, err = readPullStream(stream, newPullProgress(os.Stdout, tty))
+			stream.Close()
+			if err == nil {
+				return status, nil
+			}
+		}
+		if pullCtx.Err() != nil {
+			return "", errPullInterrupted
+		}
+		if !isTransientPullError(err) || attempt == pullAttempts {
+			break
+		}
+		fmt.Fprintf(os.Stdout, "Pull of %s interrupted (%s), restarting it...\n", name, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	return "", err
+}
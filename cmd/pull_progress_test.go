@@ -0,0 +1,109 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPullStream = `{"status":"Pulling from ceph/daemon","id":"latest-mimic"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"a1"}
+{"status":"Already exists","progressDetail":{},"id":"b2"}
+{"status":"Downloading","progressDetail":{"current":100,"total":400},"progress":"[===>   ]","id":"a1"}
+{"status":"Downloading","progressDetail":{"current":400,"total":400},"progress":"[======>]","id":"a1"}
+{"status":"Download complete","progressDetail":{},"id":"a1"}
+{"status":"Extracting","progressDetail":{"current":200,"total":400},"id":"a1"}
+{"status":"Pull complete","progressDetail":{},"id":"a1"}
+{"status":"Digest: sha256:5f44af9d2e61"}
+{"status":"Status: Downloaded newer image for ceph/daemon:latest-mimic"}
+`
+
+func TestPullProgressPlain(t *testing.T) {
+	var out bytes.Buffer
+	status, err := readPullStream(strings.NewReader(testPullStream), newPullProgress(&out, false))
+	assert.Nil(t, err)
+	assert.Equal(t, "Status: Downloaded newer image for ceph/daemon:latest-mimic", status)
+	// A line per status change, progress updates of the same status are not repeated
+	assert.Equal(t, `Pulling from ceph/daemon
+a1: Pulling fs layer
+b2: Already exists
+a1: Downloading
+a1: Download complete
+a1: Extracting
+a1: Pull complete
+Digest: sha256:5f44af9d2e61
+Status: Downloaded newer image for ceph/daemon:latest-mimic
+`, out.String())
+}
+
+func TestPullProgressTerminal(t *testing.T) {
+	var out bytes.Buffer
+	progress := newPullProgress(&out, true)
+	_, err := readPullStream(strings.NewReader(testPullStream), progress)
+	assert.Nil(t, err)
+	// Drawings are throttled, the final one moves back over the first layer and the total drawn before
+	assert.True(t, strings.HasPrefix(out.String(), "\x1b[2Ka1: Pulling fs layer\n\x1b[2KTotal 0B / 0B\n\x1b[2A"))
+	assert.Contains(t, out.String(), "a1: Pull complete\n")
+	assert.Contains(t, out.String(), "Total 400B / 400B\n")
+	assert.True(t, strings.HasSuffix(out.String(), "Status: Downloaded newer image for ceph/daemon:latest-mimic\n"))
+}
+
+func TestPullStreamErrors(t *testing.T) {
+	var out bytes.Buffer
+	stream := `{"status":"Pulling from ceph/daemon","id":"latest-nawak"}
+{"errorDetail":{"message":"manifest for ceph/daemon:latest-nawak not found"},"error":"manifest for ceph/daemon:latest-nawak not found"}
+`
+	_, err := readPullStream(strings.NewReader(stream), newPullProgress(&out, false))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "manifest for ceph/daemon:latest-nawak not found", err.Error())
+		assert.False(t, isTransientPullError(err))
+	}
+
+	// A stream cut in the middle of an event
+	_, err = readPullStream(strings.NewReader(`{"status":"Downloading","id":"a1","progressDetail":{"curr`), newPullProgress(&out, false))
+	if assert.NotNil(t, err) {
+		assert.True(t, isTransientPullError(err))
+	}
+	assert.True(t, isTransientPullError(errors.New("read tcp 10.0.0.1:443: read: connection reset by peer")))
+	assert.True(t, isTransientPullError(io.ErrUnexpectedEOF))
+}
+
+func TestPullProgressETA(t *testing.T) {
+	progress := newPullProgress(&bytes.Buffer{}, false)
+	progress.start = time.Now().Add(-10 * time.Second)
+	progress.update(pullEvent{ID: "a1", Status: "Downloading", ProgressDetail: struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	}{Current: 100, Total: 300}})
+	// 100 bytes in 10 seconds, 200 bytes to go
+	assert.InDelta(t, float64(20*time.Second), float64(progress.eta(progress.start.Add(10*time.Second))), float64(time.Millisecond))
+	assert.Equal(t, "[==========>                   ]", progressBar(100, 300))
+	assert.Equal(t, "[==============================]", progressBar(300, 300))
+}
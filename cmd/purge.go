@@ -26,7 +26,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/ceph/cn/pkg/blockdev"
 	"github.com/docker/docker/api/types"
 	"github.com/spf13/cobra"
 )
@@ -75,6 +77,10 @@ func purgeNano(cmd *cobra.Command, args []string) {
 
 func removeContainer(containerName string) {
 	dataOsd := dockerInspect(containerName, "BindsData")
+	dataFile := dockerInspect(containerName, "data_file")
+	loopDevice := dockerInspect(containerName, "loop_device")
+	dataFileCreated := dockerInspect(containerName, "data_file_created") == "true"
+	partition := dockerInspect(containerName, "partition")
 
 	if DeleteAll {
 		imageName = dockerInspect(containerName, "image")
@@ -88,6 +94,16 @@ func removeContainer(containerName string) {
 	// it's not an issue if the container does not exist
 	getDocker().ContainerRemove(ctx, containerName, options)
 
+	// The certificate and the record of the cluster are useless once the container is gone
+	removeClusterCertificate(containerName[len(containerNamePrefix):])
+	removeClusterRecord(containerName[len(containerNamePrefix):])
+
+	// The loop device must be released once the OSD is gone for the image file to be freed
+	removeDataFile(loopDevice, dataFile, dataFileCreated)
+
+	// A partition is wiped for the next cluster to use it, the rest of the disk is not touched
+	wipePartition(partition)
+
 	if dataOsd != "noDataDir" && dataOsd != "/dev" {
 		testDev, err := getFileType(dataOsd)
 		if err != nil {
@@ -112,3 +128,29 @@ func removeContainer(containerName string) {
 		getDocker().ImageRemove(ctx, imageName, options)
 	}
 }
+
+// wipePartition erases the signatures left by the OSD on a partition, the container must be gone
+func wipePartition(partition string) {
+	if len(partition) == 0 {
+		return
+	}
+	// The logical volume of the OSD stays active on the host until its device mapper is removed
+	holders, err := blockdev.Holders(partition)
+	if err == nil && len(holders) != 0 {
+		log.Println(partition + " is still used by " + strings.Join(holders, ", ") + ", remove it with 'dmsetup remove' before reusing the partition.")
+	}
+	wipeSignatures(partition)
+}
+
+// wipeSignatures wipes the signatures an OSD left on a partition or an image file for the next cluster to use it
+func wipeSignatures(path string) {
+	wiped, err := blockdev.Wipe(path)
+	if err != nil {
+		log.Println("Something went wrong while wiping " + path + ", you need to wipe it manually (e.g: with 'wipefs -a').")
+		log.Println(err)
+		return
+	}
+	for _, signature := range wiped {
+		log.Printf("Wiped the %s signature of %s at offset %d", signature.Type, path, signature.Offset)
+	}
+}
@@ -0,0 +1,355 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/openpgp"
+)
+
+const (
+	// releaseChannelStable only considers the releases which are not pre-releases
+	releaseChannelStable = "stable"
+
+	// releaseChannelPreRelease considers the pre-releases too
+	releaseChannelPreRelease = "pre-release"
+
+	// releaseChecksumAsset is the checksum file published with a release, formatted like the output of sha256sum
+	releaseChecksumAsset = "SHA256SUMS"
+
+	// releaseSignatureAsset is the armored OpenPGP signature of the checksum file, it is optional
+	releaseSignatureAsset = "SHA256SUMS.asc"
+
+	// rollbackSuffix names the copy of the binary replaced by self-update
+	rollbackSuffix = ".rollback"
+
+	// releaseDownloadTimeout bounds each request to the releases server
+	releaseDownloadTimeout = 5 * time.Minute
+)
+
+var (
+	// selfUpdateVersion is the release to install instead of the latest one
+	selfUpdateVersion string
+
+	// selfUpdateChannel overrides update.config.channel
+	selfUpdateChannel string
+
+	// selfUpdateRollback restores the binary replaced by the last self-update
+	selfUpdateRollback bool
+
+	// releaseChannels lists the release channels
+	releaseChannels = []string{releaseChannelStable, releaseChannelPreRelease}
+)
+
+// githubRelease is a release returned by the GitHub releases API
+type githubRelease struct {
+	TagName    string        `json:"tag_name"`
+	Prerelease bool          `json:"prerelease"`
+	Draft      bool          `json:"draft"`
+	HTMLURL    string        `json:"html_url"`
+	Assets     []githubAsset `json:"assets"`
+}
+
+// githubAsset is a file attached to a release
+type githubAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// cliSelfUpdate is the Cobra CLI call
+func cliSelfUpdate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Replace cn with its latest release",
+		Args:  cobra.NoArgs,
+		Run:   selfUpdate,
+		Example: "cn self-update\n" +
+			"cn self-update --version v2.3.1\n" +
+			"cn self-update --channel pre-release\n" +
+			"cn self-update --rollback\n",
+	}
+	cmd.Flags().StringVar(&selfUpdateVersion, "version", "", "Release to install, e.g: v2.3.1")
+	cmd.Flags().StringVar(&selfUpdateChannel, "channel", "", "Releases to consider: "+strings.Join(releaseChannels, " or ")+", overrides update.config.channel")
+	cmd.Flags().BoolVar(&selfUpdateRollback, "rollback", false, "Restore the binary replaced by the last self-update")
+	return cmd
+}
+
+// selfUpdate replaces the running binary with a verified release
+func selfUpdate(cmd *cobra.Command, args []string) {
+	binary, err := getExecutablePath()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if selfUpdateRollback {
+		// A broken update can be rolled back by running the copy, e.g: cn.rollback self-update --rollback
+		binary = strings.TrimSuffix(binary, rollbackSuffix)
+		if err := rollbackBinary(binary); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Restored the previous cn binary in " + binary + ", 'cn self-update --rollback' undoes it")
+		return
+	}
+
+	channel := getStringFromConfig(UPDATE, "config", "channel")
+	if len(selfUpdateChannel) > 0 {
+		channel = selfUpdateChannel
+	}
+	if !isStringInSlice(channel, releaseChannels) {
+		log.Fatal("Wrong channel " + channel + ", it must be " + strings.Join(releaseChannels, " or ") + ".")
+	}
+
+	releases, err := getReleases(getReleasesURL(), releaseDownloadTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	release, err := selectRelease(releases, selfUpdateVersion, channel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	currentVersion := getCurrentVersion()
+	if release.TagName == currentVersion {
+		fmt.Println("cn " + currentVersion + " is already installed")
+		return
+	}
+
+	fmt.Println("Downloading cn " + release.TagName + "...")
+	content, err := downloadRelease(release, runtime.GOOS, runtime.GOARCH, getStringFromConfig(UPDATE, "config", "signing_key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := replaceBinary(binary, content); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("cn " + currentVersion + " replaced by " + release.TagName + " in " + binary + ", 'cn self-update --rollback' restores it")
+}
+
+// getReleasesURL returns the URL of the releases API, a mirror can replace GitHub
+func getReleasesURL() string {
+	return getStringFromConfig(UPDATE, "config", "releases_url")
+}
+
+// getCurrentVersion returns the tag cn was built from, e.g: v2.3.1
+func getCurrentVersion() string {
+	fields := strings.Fields(cnVersion)
+	if len(fields) == 0 {
+		return cnVersion
+	}
+	return fields[0]
+}
+
+// getExecutablePath returns the path of the running binary, symlinks resolved
+func getExecutablePath() (string, error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(binary)
+}
+
+// httpGet returns the body of a URL, a status other than 200 is an error
+func httpGet(url string, timeout time.Duration) ([]byte, error) {
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", url, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// getReleases returns the releases, the most recent first
+func getReleases(url string, timeout time.Duration) ([]githubRelease, error) {
+	content, err := httpGet(url, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var releases []githubRelease
+	if err := json.Unmarshal(content, &releases); err != nil {
+		return nil, fmt.Errorf("unable to read the releases from %s: %s", url, err)
+	}
+	return releases, nil
+}
+
+// selectRelease returns the release with a given tag, the latest release of a channel otherwise
+// The 'v' of a tag is optional, e.g: 2.3.1 selects v2.3.1
+func selectRelease(releases []githubRelease, version string, channel string) (*githubRelease, error) {
+	for i := range releases {
+		release := &releases[i]
+		if release.Draft {
+			continue
+		}
+		if len(version) > 0 {
+			if strings.TrimPrefix(release.TagName, "v") == strings.TrimPrefix(version, "v") {
+				return release, nil
+			}
+			continue
+		}
+		if !release.Prerelease || channel == releaseChannelPreRelease {
+			return release, nil
+		}
+	}
+	if len(version) > 0 {
+		return nil, fmt.Errorf("there is no release %s", version)
+	}
+	return nil, fmt.Errorf("there is no release in the %s channel", channel)
+}
+
+// getReleaseAsset returns the download URL of an asset of a release, an empty string if there is none
+func getReleaseAsset(release *githubRelease, name string) string {
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			return asset.BrowserDownloadURL
+		}
+	}
+	return ""
+}
+
+// getReleaseBinaryName returns the name of the binary of a release built by 'make release'
+func getReleaseBinaryName(tag string, localOS string, localArch string) string {
+	name := "cn-" + tag + "-" + localOS + "-" + localArch
+	if localOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// downloadRelease returns the binary of a release once it matches the checksum file
+// The checksum file is verified with the armored public key in signingKey when the release is signed
+func downloadRelease(release *githubRelease, localOS string, localArch string, signingKey string) ([]byte, error) {
+	name := getReleaseBinaryName(release.TagName, localOS, localArch)
+	binaryURL := getReleaseAsset(release, name)
+	if len(binaryURL) == 0 {
+		return nil, fmt.Errorf("release %s has no %s binary, see %s", release.TagName, name, release.HTMLURL)
+	}
+	checksumURL := getReleaseAsset(release, releaseChecksumAsset)
+	if len(checksumURL) == 0 {
+		return nil, fmt.Errorf("release %s has no %s file, its binary can't be verified, see %s", release.TagName, releaseChecksumAsset, release.HTMLURL)
+	}
+
+	checksums, err := httpGet(checksumURL, releaseDownloadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// Once a key is trusted, an unsigned release is refused: a mirror could drop the signature and serve its own checksums
+	signatureURL := getReleaseAsset(release, releaseSignatureAsset)
+	switch {
+	case len(signingKey) > 0 && len(signatureURL) == 0:
+		return nil, fmt.Errorf("release %s has no %s while update.config.signing_key is set, its binary can't be verified", release.TagName, releaseSignatureAsset)
+	case len(signingKey) > 0:
+		signature, err := httpGet(signatureURL, releaseDownloadTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSignature(checksums, signature, signingKey); err != nil {
+			return nil, fmt.Errorf("the signature of %s of release %s is wrong: %s", releaseChecksumAsset, release.TagName, err)
+		}
+	case len(signatureURL) > 0:
+		log.Println("Warning: release " + release.TagName + " is signed but update.config.signing_key is not set, only the checksum is verified.")
+	}
+	expected, err := getChecksum(checksums, name)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := httpGet(binaryURL, releaseDownloadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return nil, fmt.Errorf("the checksum of %s is %s while %s expects %s", name, actual, releaseChecksumAsset, expected)
+	}
+	return content, nil
+}
+
+// getChecksum returns the checksum of a file from the output of sha256sum, e.g: 'e3b0c442...  cn-v2.3.1-linux-amd64'
+func getChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// A '*' marks the files checksummed in binary mode
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", releaseChecksumAsset, name)
+}
+
+// checkSignature verifies an armored detached signature against the armored public key(s) of update.config.signing_key
+func checkSignature(content []byte, signature []byte, signingKey string) error {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(signingKey))
+	if err != nil {
+		return fmt.Errorf("unable to read update.config.signing_key: %s", err)
+	}
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature))
+	return err
+}
+
+// replaceBinary writes a new binary in place of the current one, which is kept as the rollback copy
+// Both files are replaced atomically, a failure leaves a working binary
+func replaceBinary(binary string, content []byte) error {
+	current, err := ioutil.ReadFile(binary)
+	if err != nil {
+		return err
+	}
+	finfo, err := os.Stat(binary)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(binary+rollbackSuffix, current, finfo.Mode()); err != nil {
+		return fmt.Errorf("unable to keep a copy of %s, is its directory writable? %s", binary, err)
+	}
+	return writeFileAtomically(binary, content, finfo.Mode())
+}
+
+// rollbackBinary swaps the binary and its rollback copy, rolling back twice restores the update
+func rollbackBinary(binary string) error {
+	previous, err := ioutil.ReadFile(binary + rollbackSuffix)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("there is no %s, cn self-update didn't replace %s yet", binary+rollbackSuffix, binary)
+	}
+	if err != nil {
+		return err
+	}
+	return replaceBinary(binary, previous)
+}
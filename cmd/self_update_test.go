@@ -0,0 +1,234 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// newTestReleases returns a stand-in of the GitHub releases API serving the given files
+// Every release gets the assets among files whose name starts with its tag, e.g: v2.0.0/SHA256SUMS
+func newTestReleases(t *testing.T, files map[string][]byte) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/releases", func(w http.ResponseWriter, r *http.Request) {
+		var releases []githubRelease
+		for _, release := range []githubRelease{
+			{TagName: "v3.0.0-rc1", Prerelease: true},
+			{TagName: "v3.0.0-draft", Draft: true},
+			{TagName: "v2.0.0"},
+			{TagName: "v1.0.0"},
+		} {
+			release.HTMLURL = server.URL + "/tag/" + release.TagName
+			for file := range files {
+				if filepath.Dir(file) == release.TagName {
+					release.Assets = append(release.Assets, githubAsset{Name: filepath.Base(file), BrowserDownloadURL: server.URL + "/download/" + file})
+				}
+			}
+			releases = append(releases, release)
+		}
+		json.NewEncoder(w).Encode(releases)
+	})
+	mux.HandleFunc("/download/", func(w http.ResponseWriter, r *http.Request) {
+		content, found := files[r.URL.Path[len("/download/"):]]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(content)
+	})
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func sha256Line(content []byte, name string) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]) + "  " + name + "\n"
+}
+
+func TestSelectRelease(t *testing.T) {
+	server := newTestReleases(t, nil)
+	defer server.Close()
+	releases, err := getReleases(server.URL+"/releases", releaseDownloadTimeout)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	release, err := selectRelease(releases, "", releaseChannelStable)
+	assert.Nil(t, err)
+	assert.Equal(t, "v2.0.0", release.TagName)
+
+	release, err = selectRelease(releases, "", releaseChannelPreRelease)
+	assert.Nil(t, err)
+	assert.Equal(t, "v3.0.0-rc1", release.TagName)
+
+	release, err = selectRelease(releases, "1.0.0", releaseChannelStable)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1.0.0", release.TagName)
+
+	// Drafts are never installed
+	_, err = selectRelease(releases, "v3.0.0-draft", releaseChannelPreRelease)
+	assert.NotNil(t, err)
+	_, err = selectRelease(releases, "v4.0.0", releaseChannelStable)
+	assert.NotNil(t, err)
+
+	_, err = getReleases(server.URL+"/missing", releaseDownloadTimeout)
+	assert.NotNil(t, err)
+}
+
+func TestGetChecksum(t *testing.T) {
+	checksums := []byte("AB12  cn-v2.0.0-darwin-amd64\ncd34 *cn-v2.0.0-linux-amd64\n")
+	checksum, err := getChecksum(checksums, "cn-v2.0.0-darwin-amd64")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab12", checksum)
+	checksum, err = getChecksum(checksums, "cn-v2.0.0-linux-amd64")
+	assert.Nil(t, err)
+	assert.Equal(t, "cd34", checksum)
+	_, err = getChecksum(checksums, "cn-v2.0.0-linux-arm64")
+	assert.NotNil(t, err)
+}
+
+func TestDownloadRelease(t *testing.T) {
+	binary := []byte("cn v2.0.0 for linux")
+	server := newTestReleases(t, map[string][]byte{
+		"v2.0.0/cn-v2.0.0-linux-amd64": binary,
+		"v2.0.0/SHA256SUMS":            []byte(sha256Line(binary, "cn-v2.0.0-linux-amd64")),
+		"v2.0.0/cn-v2.0.0-linux-arm64": []byte("tampered"),
+		"v1.0.0/cn-v1.0.0-linux-amd64": []byte("cn v1.0.0 for linux"),
+	})
+	defer server.Close()
+	releases, err := getReleases(server.URL+"/releases", releaseDownloadTimeout)
+	if !assert.Nil(t, err) {
+		return
+	}
+	release, _ := selectRelease(releases, "v2.0.0", releaseChannelStable)
+
+	content, err := downloadRelease(release, "linux", "amd64", "")
+	assert.Nil(t, err)
+	assert.Equal(t, binary, content)
+
+	// The checksum file doesn't list it
+	_, err = downloadRelease(release, "linux", "arm64", "")
+	assert.NotNil(t, err)
+	// There is no binary
+	_, err = downloadRelease(release, "darwin", "amd64", "")
+	assert.NotNil(t, err)
+	// There is no checksum file
+	release, _ = selectRelease(releases, "v1.0.0", releaseChannelStable)
+	_, err = downloadRelease(release, "linux", "amd64", "")
+	assert.NotNil(t, err)
+}
+
+func TestDownloadSignedRelease(t *testing.T) {
+	entity, err := openpgp.NewEntity("cn", "release", "cn@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key bytes.Buffer
+	keyWriter, _ := armor.Encode(&key, openpgp.PublicKeyType, nil)
+	entity.Serialize(keyWriter)
+	keyWriter.Close()
+
+	binary := []byte("cn v2.0.0 for linux")
+	checksums := []byte(sha256Line(binary, "cn-v2.0.0-linux-amd64"))
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader(checksums), nil); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestReleases(t, map[string][]byte{
+		"v2.0.0/cn-v2.0.0-linux-amd64":         binary,
+		"v2.0.0/SHA256SUMS":                    checksums,
+		"v2.0.0/SHA256SUMS.asc":                signature.Bytes(),
+		"v1.0.0/cn-v1.0.0-linux-amd64":         binary,
+		"v1.0.0/SHA256SUMS":                    []byte(sha256Line(binary, "cn-v1.0.0-linux-amd64")),
+		"v1.0.0/SHA256SUMS.asc":                signature.Bytes(),
+		"v3.0.0-rc1/cn-v3.0.0-rc1-linux-amd64": binary,
+		"v3.0.0-rc1/SHA256SUMS":                []byte(sha256Line(binary, "cn-v3.0.0-rc1-linux-amd64")),
+	})
+	defer server.Close()
+	releases, _ := getReleases(server.URL+"/releases", releaseDownloadTimeout)
+
+	release, _ := selectRelease(releases, "v2.0.0", releaseChannelStable)
+	content, err := downloadRelease(release, "linux", "amd64", key.String())
+	assert.Nil(t, err)
+	assert.Equal(t, binary, content)
+
+	// The key is the armored key itself, not the path of a file holding it
+	_, err = downloadRelease(release, "linux", "amd64", "/etc/cn/release.asc")
+	assert.NotNil(t, err)
+
+	// The signature doesn't match the checksum file
+	release, _ = selectRelease(releases, "v1.0.0", releaseChannelStable)
+	_, err = downloadRelease(release, "linux", "amd64", key.String())
+	assert.NotNil(t, err)
+
+	// An unsigned release is refused once a key is set, it is only checksummed otherwise
+	release, _ = selectRelease(releases, "v3.0.0-rc1", releaseChannelPreRelease)
+	_, err = downloadRelease(release, "linux", "amd64", key.String())
+	assert.NotNil(t, err)
+	content, err = downloadRelease(release, "linux", "amd64", "")
+	assert.Nil(t, err)
+	assert.Equal(t, binary, content)
+}
+
+func TestReplaceBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cn-self-update-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	binary := filepath.Join(dir, "cn")
+	ioutil.WriteFile(binary, []byte("v1"), 0755)
+
+	// Nothing to roll back yet
+	assert.NotNil(t, rollbackBinary(binary))
+
+	assert.Nil(t, replaceBinary(binary, []byte("v2")))
+	content, _ := ioutil.ReadFile(binary)
+	assert.Equal(t, "v2", string(content))
+	content, _ = ioutil.ReadFile(binary + rollbackSuffix)
+	assert.Equal(t, "v1", string(content))
+	finfo, _ := os.Stat(binary)
+	assert.Equal(t, os.FileMode(0755), finfo.Mode())
+
+	// Rolling back twice restores the update
+	assert.Nil(t, rollbackBinary(binary))
+	content, _ = ioutil.ReadFile(binary)
+	assert.Equal(t, "v1", string(content))
+	assert.Nil(t, rollbackBinary(binary))
+	content, _ = ioutil.ReadFile(binary)
+	assert.Equal(t, "v2", string(content))
+}
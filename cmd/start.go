@@ -24,11 +24,14 @@ package cmd
 
 import (
 	"log"
+	"net"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ceph/cn/pkg/blockdev"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/go-connections/nat"
@@ -47,6 +50,27 @@ var (
 
 	// the flavor name of a container
 	flavor string
+
+	// enableTLS publishes the S3 endpoint over https with a certificate signed by cn's local CA
+	enableTLS bool
+
+	// bindAddress is the host address the ports of a container are published on
+	bindAddress string
+
+	// advertiseAddress is the host name, IP or interface name printed in endpoints
+	advertiseAddress string
+
+	// clusterTTL is how long a cluster lives before 'cluster gc' collects it
+	clusterTTL string
+
+	// enablePrometheus enables the mgr prometheus module and publishes its port
+	enablePrometheus bool
+
+	// imageToRun is the image a new cluster is created from, pinned by cn.lock if the image is locked
+	imageToRun string
+
+	// forceImage starts an image cn knows is incompatible
+	forceImage bool
 )
 
 // cliClusterStart is the Cobra CLI call
@@ -61,14 +85,26 @@ func cliClusterStart() *cobra.Command {
 			"cn cluster start mycluster --work-dir /tmp \n" +
 			"cn cluster start mycluster --image ceph/daemon:latest-luminous \n" +
 			"cn cluster start mycluster -b /dev/sdb \n" +
-			"cn cluster start mycluster -b /srv/nano -s 20GB \n",
+			"cn cluster start mycluster -b /srv/nano -s 20GB \n" +
+			"cn cluster start mycluster -b file:/srv/nano.img -s 50GB \n" +
+			"cn cluster start mycluster -f huge -b memory:2GB \n" +
+			"cn cluster start mycluster --tls \n" +
+			"cn cluster start mycluster --bind-address 0.0.0.0 --advertise-address eth0 \n" +
+			"cn cluster start mycluster --ttl 2h \n" +
+			"cn cluster start mycluster --prometheus \n",
 	}
 	cmd.Flags().SortFlags = false
 	cmd.Flags().StringVarP(&workingDirectory, "work-dir", "d", DEFAULTWORKDIRECTORY, "Directory to work from")
-	cmd.Flags().StringVarP(&imageName, "image", "i", DEFAULTIMAGE, "USE AT YOUR OWN RISK. Ceph container image to use, format is 'registry/username/image:tag'.\nThe image name could also be an alias coming from the hardcoded values or the configuration file.\nUse 'image show-aliases' to list all existing aliases.")
-	cmd.Flags().StringVarP(&dataOsd, "data", "b", "", "Configure Ceph Nano underlying storage with a specific directory or physical block device.\nBlock device support only works on Linux running under 'root', only also directory might need running as 'root' if SeLinux is enabled.")
-	cmd.Flags().StringVarP(&sizeBluestoreBlock, "size", "s", "", "Configure Ceph Nano underlying storage size when using a specific directory")
+	cmd.Flags().StringVarP(&imageName, "image", "i", DEFAULTIMAGE, "Ceph container image to use, format is 'registry/username/image:tag'.\nThe image name could also be an alias coming from the hardcoded values or the configuration file.\nUse 'image show-aliases' to list all existing aliases. Images known to be incompatible are refused, see --force.")
+	cmd.Flags().StringVarP(&dataOsd, "data", "b", "", "Configure Ceph Nano underlying storage with a specific directory, physical block device, partition or image file (file:/path/to/image).\nUse memory:SIZE to keep the data in a tmpfs thrown away on stop and purge.\nBlock device and image file support only works on Linux running under 'root', only also directory might need running as 'root' if SeLinux is enabled.")
+	cmd.Flags().StringVarP(&sizeBluestoreBlock, "size", "s", "", "Configure Ceph Nano underlying storage size when using a specific directory or creating an image file")
 	cmd.Flags().StringVarP(&flavor, "flavor", "f", "default", "Select the container flavor. Use 'flavors ls' command to list available flavors.")
+	cmd.Flags().BoolVar(&enableTLS, "tls", false, "Publish the S3 endpoint over https with a certificate signed by cn's local CA. Use 'pki export' to trust it.")
+	cmd.Flags().StringVar(&bindAddress, "bind-address", "", "Host address to publish the ports on (default from the flavor, loopback). Use 0.0.0.0 or :: to publish on all interfaces.")
+	cmd.Flags().StringVar(&advertiseAddress, "advertise-address", "", "Host name, IP address or interface name printed in the endpoints (default is guessed)")
+	cmd.Flags().StringVar(&clusterTTL, "ttl", "", "Time to live of the cluster (e.g: 90m, 2h), expired clusters are collected by 'cluster gc'")
+	cmd.Flags().BoolVar(&enablePrometheus, "prometheus", false, "Enable the mgr prometheus module and publish its metrics endpoint")
+	cmd.Flags().BoolVar(&forceImage, "force", false, "USE AT YOUR OWN RISK. Start the image even if cn knows it's incompatible")
 	cmd.Flags().BoolVar(&Help, "help", false, "help for start")
 
 	return cmd
@@ -99,25 +135,62 @@ func startNano(cmd *cobra.Command, args []string) {
 
 	if status := containerStatus(containerName, false, "running"); status {
 		log.Println("Cluster " + containerNameToShow + " is already running!")
-	} else if status := containerStatus(containerName, true, "exited"); status {
+		echoInfo(containerName)
+		return
+	}
+
+	if status := containerStatus(containerName, true, "exited"); status {
 		log.Println("Starting cluster " + containerNameToShow + "...")
+		if isMemoryStorageCluster(containerName) {
+			log.Println("Cluster " + containerNameToShow + " keeps its data in memory, it starts from scratch.")
+		}
+		startTime := time.Now()
 		startContainer(containerName)
+		waitForCluster(containerName, startTime)
 	} else {
-		pullImage()
+		imageToRun = getImageToRun()
+		pullImageNamed(imageToRun)
+		startTime := time.Now()
 		runContainer(cmd, args)
+		waitForCluster(containerName, startTime)
 	}
-	echoInfo(containerName)
+	printClusterInfo(containerName)
+}
+
+// waitForCluster waits for a starting cluster to be ready and records how long it took
+// The cluster is ready once it returns, its information can be printed without probing it again
+func waitForCluster(containerName string, startTime time.Time) {
+	cephNanoHealth(containerName)
+	enablePrometheusModule(containerName)
+	cephNanoS3Health(containerName, dockerInspect(containerName, "PortBindingsRgw"))
+	recordStartDuration(containerName, time.Since(startTime))
 }
 
 // runContainer creates a new container when nothing exists
 func runContainer(cmd *cobra.Command, args []string) {
 	containerName := containerNamePrefix + args[0]
 	containerNameToShow := args[0]
-	rgwPort := generateRGWPortToUse()
+
+	hostBindAddress := getBindAddress(flavor)
+	if net.ParseIP(hostBindAddress) == nil {
+		log.Fatal("The bind address " + hostBindAddress + " is not an IP address.")
+	}
+	endpointHost, err := resolveAdvertiseAddress(getAdvertiseAddress(flavor), hostBindAddress, os.Getenv("DOCKER_HOST"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The extra settings of the flavor are checked before anything gets allocated on the host
+	flavorSettings, err := getFlavorContainerSettings(flavor)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rgwPort := generateRGWPortToUse(hostBindAddress)
 	if rgwPort == "notfound" {
 		log.Fatal("Unable to find a port between 8000 and 8100 for the S3 endpoint.")
 	}
-	cnBrowserPort := generateBrowserPortToUse()
+	cnBrowserPort := generateBrowserPortToUse(hostBindAddress)
 	if cnBrowserPort == "notfound" {
 		log.Fatal("Unable to find a port between 5000 and 5100 for the UI endpoint.")
 	}
@@ -132,32 +205,63 @@ func runContainer(cmd *cobra.Command, args []string) {
 	portBindings := nat.PortMap{
 		nat.Port(rgwNatPort): []nat.PortBinding{
 			{
-				HostIP:   "0.0.0.0",
+				HostIP:   hostBindAddress,
 				HostPort: rgwPort,
 			},
 		},
 		nat.Port(cnBrowserNatPort): []nat.PortBinding{
 			{
-				HostIP:   "0.0.0.0",
+				HostIP:   hostBindAddress,
 				HostPort: cnBrowserPort,
 			},
 		},
 	}
 
-	ips, _ := getInterfaceIPv4s()
+	// The mgr prometheus module always listens on its default port inside the container
+	prometheusPort := ""
+	if getPrometheus(flavor) {
+		prometheusPort = generatePrometheusPortToUse(hostBindAddress)
+		if prometheusPort == "notfound" {
+			log.Fatal("Unable to find a port between 9283 and 9383 for the Prometheus endpoint.")
+		}
+		exposedPorts[nat.Port(prometheusInternalPort+"/tcp")] = struct{}{}
+		portBindings[nat.Port(prometheusInternalPort+"/tcp")] = []nat.PortBinding{
+			{
+				HostIP:   hostBindAddress,
+				HostPort: prometheusPort,
+			},
+		}
+	}
+
+	// The image decides if it can run at all, if it needs the legacy variables and if it can serve TLS
+	compatibility := checkImageCompatibility(getLocalImage(imageToRun))
+	if getTLS(flavor) && !compatibility.tls {
+		refuseImage(imageToRun, "the RGW frontend of Ceph "+strings.Title(compatibility.release)+" doesn't serve https, TLS needs Nautilus or later")
+	}
+
+	// With TLS, the plain text frontend only listens inside the container
+	// while the published port is served by the SSL frontend
+	rgwFrontendPort := rgwPort
+	if getTLS(flavor) {
+		rgwFrontendPort = rgwInternalPort
+	}
 
 	envs := []string{
-		"RGW_FRONTEND_PORT=" + rgwPort, // DON'T TOUCH MY POSITION IN THE SLICE OR YOU WILL BREAK dockerInspect()
-		"SREE_PORT=" + cnBrowserPort,   // DON'T TOUCH MY POSITION IN THE SLICE OR YOU WILL BREAK dockerInspect()
-		"RGW_CIVETWEB_PORT=" + rgwPort, // Keep this for backward compatiblity, the option is gone since https://github.com/ceph/ceph-container/pull/1356
-		"EXPOSED_IP=" + ips[0].String(),
+		"RGW_FRONTEND_PORT=" + rgwFrontendPort, // DON'T TOUCH MY POSITION IN THE SLICE OR YOU WILL BREAK dockerInspect()
+		"SREE_PORT=" + cnBrowserPort,           // DON'T TOUCH MY POSITION IN THE SLICE OR YOU WILL BREAK dockerInspect()
+		"EXPOSED_IP=" + endpointHost,
 		"DEBUG=verbose",
 		"CEPH_DEMO_UID=" + cephNanoUID,
 		"MON_IP=127.0.0.1",
 		"CEPH_PUBLIC_NETWORK=0.0.0.0/0",
 		"CEPH_DAEMON=demo",
 		"DEMO_DAEMONS=mon,mgr,osd,rgw",
-		"SREE_VERSION=v0.1", // keep this for backward compatiblity, the option is gone since https://github.com/ceph/ceph-container/pull/1232
+	}
+	if compatibility.legacyEnv {
+		envs = append(envs,
+			"RGW_CIVETWEB_PORT="+rgwFrontendPort, // Keep this for backward compatiblity, the option is gone since https://github.com/ceph/ceph-container/pull/1356
+			"SREE_VERSION=v0.1",                  // keep this for backward compatiblity, the option is gone since https://github.com/ceph/ceph-container/pull/1232
+		)
 	}
 
 	volumeBindings := []string{
@@ -169,7 +273,42 @@ func runContainer(cmd *cobra.Command, args []string) {
 		"/var/lib/ceph": struct{}{},
 	}
 
-	if len(getUnderlyingStorage(flavor)) != 0 {
+	storage := getStorage(flavor)
+	var tmpfs map[string]string
+	if storage == memoryStorage {
+		// tmpfs mounts replace the volumes, nothing survives a stop
+		if len(getUnderlyingStorage(flavor)) != 0 && !strings.HasPrefix(getUnderlyingStorage(flavor), memoryStoragePrefix) {
+			log.Fatal("The flavor " + flavor + " keeps its data in memory, it can't use " + getUnderlyingStorage(flavor) + " as well.")
+		}
+		storageSize := getMemoryStorageSizeInBytes(flavor)
+		if err := checkMemoryStorageSize(storageSize, getMemorySizeInBytes(flavor)); err != nil {
+			log.Fatal(err)
+		}
+		tmpfs = getMemoryStorageTmpfs(storageSize)
+		for path := range tmpfs {
+			delete(volumes, path)
+		}
+		// Leave some room to BlueStore metadata
+		envs = append(envs, "BLUESTORE_BLOCK_SIZE="+strconv.FormatInt(storageSize*9/10, 10))
+	} else if len(storage) != 0 {
+		log.Fatal("Unknown storage " + storage + " in flavor " + flavor + ", the only supported one is " + memoryStorage + ".")
+	}
+
+	// An image file is attached to a loop device the OSD uses like a real block device
+	// It's attached right before creating the container, nothing is left behind if cn fails until then
+	useDataFile := false
+	// A partition is wiped on purge, a whole device is left as is
+	partition := ""
+	if storage == memoryStorage {
+		// The tmpfs holds the OSD
+	} else if isDataFile(getUnderlyingStorage(flavor)) {
+		checkDataFileUser()
+		useDataFile = true
+		setPrivileged(flavor, true)
+		volumeBindings = append(volumeBindings, "/dev:/dev")
+		volumeBindings = append(volumeBindings, "/var/run/udev/:/var/run/udev/:z")
+		volumeBindings = append(volumeBindings, "/run/lvm:/run/lvm")
+	} else if len(getUnderlyingStorage(flavor)) != 0 {
 		testDev, err := getFileType(getUnderlyingStorage(flavor))
 		if err != nil {
 			log.Fatal(err)
@@ -203,58 +342,50 @@ func runContainer(cmd *cobra.Command, args []string) {
 			if meID != "0" {
 				log.Fatal("Hey " + meUserName + "! Run me as 'root' when using a block device.")
 			}
-			// We don't have the logic to do the introspection without using blkid.
-			// Unfortunately, blkid is not available on macOS or Windows
+			// The OSD needs the /dev of a Linux host
 			if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
 				log.Fatal("Operating system: " + runtime.GOOS + " is not supported in the scenario")
 			}
 			// We run a couple of test here to ensure the device can be used:
 			// 1. test of the device is accessed by a process (open it with O_EXCL)
-			// 2. test if the device has a partition table and/or a filesystem
-			// 3. test if they are partitions in that partition table (you can have a partition table with 0 partitions)
+			// 2. test if the device has a partition table and/or a signature (filesystem, LVM, bluestore...)
+			// 3. test if a partition is used by another device (e.g: an LVM logical volume)
 
-			// First test: is the device opened by a process?!«
+			// First test: is the device opened by a process?!
 			testDevOpen, _ := exclusiveOpenFailsOnDevice(getUnderlyingStorage(flavor))
 			if testDevOpen {
 				log.Fatal(getUnderlyingStorage(flavor) + " is accessed by another process, doing nothing.")
 			}
 
-			// Second test: search for filesystem and partition table
-			diskFormat := getDiskFormat(getUnderlyingStorage(flavor))
-			lines := strings.Split(diskFormat, "\n")
-			var fstype, pttype string
-			for _, l := range lines {
-				if len(l) <= 0 {
-					// Ignore empty line.
-					continue
-				}
-				cs := strings.Split(l, "=")
-				if len(cs) != 2 {
-					log.Fatal("blkid returns invalid output: " + diskFormat + ". This potentially means no partition label on your disk.")
+			// Second test: read the partition table and the signatures of the device
+			report, err := blockdev.Inspect(getUnderlyingStorage(flavor))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(report.Signatures) > 0 {
+				log.Fatal(getUnderlyingStorage(flavor) + " has " + report.String() + ", doing nothing.\n" +
+					"If the disk was an OSD you need to zap it (e.g: with 'ceph-volume lvm zap').")
+			}
+			// An empty partition table is fine, partitions are not
+			if len(report.Partitions) != 0 {
+				log.Fatal(getUnderlyingStorage(flavor) + " has " + report.String() + ", doing nothing.\n" +
+					"Use one of its unused partitions instead, e.g: -b /dev/sdb3.")
+			}
+
+			// Third test: is something built on top of the partition?!
+			isPartition, err := blockdev.IsPartition(getUnderlyingStorage(flavor))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if isPartition {
+				holders, err := blockdev.Holders(getUnderlyingStorage(flavor))
+				if err != nil {
+					log.Fatal(err)
 				}
-				// TYPE is filesystem type, and PTTYPE is partition table type, according
-				// to https://www.kernel.org/pub/linux/utils/util-linux/v2.21/libblkid-docs/.
-				if cs[0] == "TYPE" {
-					fstype = cs[1]
-					log.Fatal(getUnderlyingStorage(flavor) + " has a filesystem: " + fstype + ", doing nothing.")
-				} else if cs[0] == "PTTYPE" {
-					// Third test: number of partitions
-					pttype = cs[1]
-					// Now we test if the disk has partition(s)
-					// We know parted will return 2 lines if there is a partition table:
-					//
-					// BYT;
-					// /dev/sdc:100GB:scsi:512:512:gpt:HP LOGICAL VOLUME:;
-					//
-					// So we remove the first 2 lines of the output
-					// The third one is always the partition number
-					partedUselessLinesCount := 2
-					num := getDiskPartitions(getUnderlyingStorage(flavor))
-					partCount := len(num) - partedUselessLinesCount
-					if partCount != 0 {
-						log.Fatal(getUnderlyingStorage(flavor) + " has a partition table type " + pttype + " and " + strconv.Itoa(partCount) + " partition(s) doing nothing.")
-					}
+				if len(holders) != 0 {
+					log.Fatal(getUnderlyingStorage(flavor) + " is used by " + strings.Join(holders, ", ") + ", doing nothing.")
 				}
+				partition = getUnderlyingStorage(flavor)
 			}
 			// If we arrive here, it should be safe to use the device.
 			envs = append(envs, "OSD_DEVICE="+getUnderlyingStorage(flavor))
@@ -265,13 +396,60 @@ func runContainer(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	labels := map[string]string{
+		"flavor":            flavor,
+		"rgw_port":          rgwPort,
+		"bind_address":      hostBindAddress,
+		"advertise_address": endpointHost,
+	}
+
+	if len(prometheusPort) > 0 {
+		labels["prometheus_port"] = prometheusPort
+	}
+
+	if len(partition) > 0 {
+		labels["partition"] = partition
+	}
+
+	if storage == memoryStorage {
+		labels["storage"] = memoryStorage
+	}
+
+	// The alias is kept so 'cn image alias rm' refuses to remove it while the cluster exists
+	if isEntryExist(IMAGES, imageName) {
+		labels["image_alias"] = imageName
+	}
+
+	if ttl := getTTL(flavor); len(ttl) > 0 {
+		ttlDuration, err := time.ParseDuration(ttl)
+		if err != nil || ttlDuration <= 0 {
+			log.Fatal("Wrong time to live passed: " + ttl + ". Please use a positive duration like 90m or 2h.")
+		}
+		labels["expires_at"] = time.Now().Add(ttlDuration).UTC().Format(time.RFC3339)
+	}
+
+	clusterPKIPath := ""
+	if getTLS(flavor) {
+		sanIPs, sanHostnames := getCertificateSANs(containerName, endpointHost)
+		var err error
+		if clusterPKIPath, err = issueClusterCertificate(containerNameToShow, sanIPs, sanHostnames); err != nil {
+			log.Fatal(err)
+		}
+		envs = append(envs,
+			"RGW_FRONTEND_TYPE=beast",
+			"RGW_FRONTEND_OPTIONS=ssl_port="+rgwPort+
+				" ssl_certificate="+pkiContainerPath+"/"+pkiClusterCert+
+				" ssl_private_key="+pkiContainerPath+"/"+pkiClusterKey)
+		labels["tls"] = "true"
+	}
+
 	config := &container.Config{
-		Image:        getImageName(),
+		Image:        getLocalImage(imageToRun),
 		Hostname:     containerName + "-faa32aebf00b",
 		ExposedPorts: exposedPorts,
 		Env:          envs,
 		Volumes:      volumes,
-		Labels:       map[string]string{"flavor": flavor},
+		Labels:       labels,
 	}
 
 	ressources := container.Resources{
@@ -284,15 +462,41 @@ func runContainer(cmd *cobra.Command, args []string) {
 		Binds:        volumeBindings,
 		Resources:    ressources,
 		Privileged:   getPrivileged(flavor),
+		Tmpfs:        tmpfs,
+	}
+
+	if err := flavorSettings.apply(config, hostConfig, hostBindAddress); err != nil {
+		log.Fatal(err)
+	}
+
+	dataFile, loopDevice, dataFileCreated := "", "", false
+	if useDataFile {
+		dataFile, loopDevice, dataFileCreated = attachDataFile(getUnderlyingStorage(flavor), getSize(flavor))
+		config.Env = setEnv(config.Env, "OSD_DEVICE="+loopDevice)
+		config.Labels["data_file"] = dataFile
+		config.Labels["loop_device"] = loopDevice
+		if dataFileCreated {
+			config.Labels["data_file_created"] = "true"
+		}
 	}
 
 	log.Printf("Running cluster %s | image %s | flavor %s {%s Memory, %d CPU} ...", containerNameToShow, getImageName(), flavor, getMemorySize(flavor), ressources.NanoCPUs)
 
 	resp, err := getDocker().ContainerCreate(ctx, config, hostConfig, nil, containerName)
 	if err != nil {
+		removeDataFile(loopDevice, dataFile, dataFileCreated)
 		log.Fatal(err)
 	}
 
+	// Remember what the cluster uses on the host so 'cluster gc' can clean it up if the container disappears
+	writeClusterRecord(containerName)
+
+	if len(clusterPKIPath) > 0 {
+		if err := copyClusterCertificate(resp.ID, clusterPKIPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	err = getDocker().ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
 	// The if removes the error:
 	//panic: runtime error: invalid memory address or nil pointer dereference
@@ -316,6 +520,7 @@ func runContainer(cmd *cobra.Command, args []string) {
 
 // startContainer starts a container that is stopped
 func startContainer(containerName string) {
+	ensureDataFileAttached(containerName)
 	if err := getDocker().ContainerStart(ctx, containerName, types.ContainerStartOptions{}); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,158 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// clusterRecordsDirectory is where cn remembers its clusters, relative to ~/.cn
+const clusterRecordsDirectory = "clusters"
+
+// clusterRecord is what cn remembers about a cluster on the host
+// It allows cleaning up what a cluster leaves behind once its container is gone
+type clusterRecord struct {
+	Name                string   `json:"name"`
+	Data                string   `json:"data,omitempty"`
+	Volumes             []string `json:"volumes,omitempty"`
+	DataFile            string   `json:"data_file,omitempty"`
+	LoopDevice          string   `json:"loop_device,omitempty"`
+	DataFileCreated     bool     `json:"data_file_created,omitempty"`
+	StartDuration       float64  `json:"start_duration_seconds,omitempty"`
+	HealthCheckFailures int      `json:"health_check_failures,omitempty"`
+}
+
+// makeClusterRecordPath returns the path of the record of a cluster
+func makeClusterRecordPath(containerNameToShow string) string {
+	return makeCephNanoPath(clusterRecordsDirectory, containerNameToShow+".json")
+}
+
+// writeClusterRecord saves the data directory and volumes of a freshly created cluster
+func writeClusterRecord(containerName string) {
+	containerNameToShow := containerName[len(containerNamePrefix):]
+	inspect, err := getDocker().ContainerInspect(ctx, containerName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	record := clusterRecord{Name: containerNameToShow}
+	if dataDir := dockerInspect(containerName, "BindsData"); dataDir != "noDataDir" && dataDir != "/dev" {
+		record.Data = dataDir
+	}
+	record.DataFile = inspect.Config.Labels["data_file"]
+	record.LoopDevice = inspect.Config.Labels["loop_device"]
+	record.DataFileCreated = inspect.Config.Labels["data_file_created"] == "true"
+	for _, m := range inspect.Mounts {
+		if string(m.Type) == "volume" && len(m.Name) > 0 {
+			record.Volumes = append(record.Volumes, m.Name)
+		}
+	}
+
+	if err := saveClusterRecord(record); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// saveClusterRecord writes a record on disk
+func saveClusterRecord(record clusterRecord) error {
+	content, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(makeCephNanoPath(clusterRecordsDirectory), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(makeClusterRecordPath(record.Name), content, 0644)
+}
+
+// readClusterRecord returns the record of a cluster, an empty one if cn doesn't know it
+func readClusterRecord(containerNameToShow string) clusterRecord {
+	record := clusterRecord{Name: containerNameToShow}
+	content, err := ioutil.ReadFile(makeClusterRecordPath(containerNameToShow))
+	if err != nil {
+		return record
+	}
+	if err := json.Unmarshal(content, &record); err != nil {
+		log.Println("Ignoring invalid cluster record " + containerNameToShow + ": " + err.Error())
+		return clusterRecord{Name: containerNameToShow}
+	}
+	return record
+}
+
+// recordStartDuration remembers how long the last start of a cluster took
+// Failing to save it must not fail the start, metrics are best effort
+func recordStartDuration(containerName string, duration time.Duration) {
+	record := readClusterRecord(containerName[len(containerNamePrefix):])
+	record.StartDuration = duration.Seconds()
+	if err := saveClusterRecord(record); err != nil {
+		log.Println("Unable to record the start duration: " + err.Error())
+	}
+}
+
+// recordHealthCheckFailure counts the times a cluster failed to become healthy
+func recordHealthCheckFailure(containerName string) {
+	record := readClusterRecord(containerName[len(containerNamePrefix):])
+	record.HealthCheckFailures++
+	if err := saveClusterRecord(record); err != nil {
+		log.Println("Unable to record the health check failure: " + err.Error())
+	}
+}
+
+// readClusterRecords returns all the records cn knows about
+func readClusterRecords() []clusterRecord {
+	var records []clusterRecord
+	files, err := ioutil.ReadDir(makeCephNanoPath(clusterRecordsDirectory))
+	if err != nil {
+		return records
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(makeCephNanoPath(clusterRecordsDirectory), file.Name()))
+		if err != nil {
+			continue
+		}
+		var record clusterRecord
+		if err := json.Unmarshal(content, &record); err != nil {
+			log.Println("Ignoring invalid cluster record " + file.Name() + ": " + err.Error())
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// removeClusterRecord forgets about a cluster
+func removeClusterRecord(containerNameToShow string) {
+	err := os.Remove(makeClusterRecordPath(containerNameToShow))
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("Unable to remove the record of cluster " + containerNameToShow + ": " + err.Error())
+	}
+}
@@ -57,6 +57,9 @@ func stopNano(cmd *cobra.Command, args []string) {
 		os.Exit(0)
 	} else {
 		log.Println("Stopping cluster " + containerNameToShow + "...")
+		if isMemoryStorageCluster(containerName) {
+			log.Println("Cluster " + containerNameToShow + " keeps its data in memory, it is thrown away.")
+		}
 		if err := getDocker().ContainerStop(ctx, containerName, &timeout); err != nil {
 			log.Fatal(err)
 		}
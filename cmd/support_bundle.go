@@ -0,0 +1,325 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// supportBundleOutput is the path of the support bundle to write
+	supportBundleOutput string
+
+	// secretPattern matches the names of environment variables and labels holding secrets
+	secretPattern = regexp.MustCompile(`(?i)(key|secret|password|passwd|token|credential)`)
+)
+
+// supportBundle is a tar.gz archive being filled with diagnostic data
+type supportBundle struct {
+	tw      *tar.Writer
+	prefix  string
+	errors  []string
+	modTime time.Time
+	source  supportBundleSource
+}
+
+// supportBundleSource is what a support bundle is collected from
+type supportBundleSource interface {
+	version() (interface{}, error)
+	info() (interface{}, error)
+	inspect(containerName string) (types.ContainerJSON, error)
+	logs(containerName string) (io.ReadCloser, error)
+	inspectImage(image string) (types.ImageInspect, error)
+	exec(containerName string, cmd []string, stderr io.Writer) (io.ReadCloser, error)
+}
+
+// dockerBundleSource collects a support bundle from the Docker daemon
+type dockerBundleSource struct{}
+
+func (dockerBundleSource) version() (interface{}, error) { return getDocker().ServerVersion(ctx) }
+
+func (dockerBundleSource) info() (interface{}, error) { return getDocker().Info(ctx) }
+
+func (dockerBundleSource) inspect(containerName string) (types.ContainerJSON, error) {
+	return getDocker().ContainerInspect(ctx, containerName)
+}
+
+func (dockerBundleSource) logs(containerName string) (io.ReadCloser, error) {
+	return getDocker().ContainerLogs(ctx, containerName, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Timestamps: true})
+}
+
+func (dockerBundleSource) inspectImage(image string) (types.ImageInspect, error) {
+	inspect, _, err := getDocker().ImageInspectWithRaw(ctx, image)
+	return inspect, err
+}
+
+func (dockerBundleSource) exec(containerName string, cmd []string, stderr io.Writer) (io.ReadCloser, error) {
+	return execContainerStream(containerName, cmd, stderr)
+}
+
+// cliClusterSupportBundle is the Cobra CLI call
+func cliClusterSupportBundle() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "support-bundle [cluster]",
+		Short: "Collect logs, status and configuration of a cluster to attach to a bug report",
+		Args:  cobra.ExactArgs(1),
+		Run:   supportBundleNano,
+		Example: "cn cluster support-bundle mycluster \n" +
+			"cn cluster support-bundle mycluster -o /tmp/bundle.tar.gz \n",
+	}
+	cmd.Flags().StringVarP(&supportBundleOutput, "output", "o", "", "Path of the bundle (default ./<cluster>-support-bundle.tar.gz)")
+
+	return cmd
+}
+
+// supportBundleNano writes the support bundle of a cluster
+func supportBundleNano(cmd *cobra.Command, args []string) {
+	containerNameToShow := args[0]
+	containerName := containerNamePrefix + containerNameToShow
+	notExistCheck(containerName)
+
+	output := supportBundleOutput
+	if len(output) == 0 {
+		output = containerNameToShow + "-support-bundle.tar.gz"
+	}
+	if err := writeSupportBundle(containerName, output); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Support bundle written to " + output)
+}
+
+// collectSupportBundleOnFailure writes a support bundle in ~/.cn when a cluster fails to start
+// It returns the path of the bundle or an empty string if it couldn't be written
+func collectSupportBundleOnFailure(containerName string) string {
+	containerNameToShow := containerName[len(containerNamePrefix):]
+	output := makeCephNanoPath("support-bundles", containerNameToShow+"-"+time.Now().Format("20060102-150405")+".tar.gz")
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		log.Println("Unable to write a support bundle: " + err.Error())
+		return ""
+	}
+	if err := writeSupportBundle(containerName, output); err != nil {
+		log.Println("Unable to write a support bundle: " + err.Error())
+		return ""
+	}
+	return output
+}
+
+// writeSupportBundle collects everything useful to debug a cluster in a tar.gz file
+func writeSupportBundle(containerName string, output string) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return collectSupportBundle(f, containerName, dockerBundleSource{})
+}
+
+// collectSupportBundle writes the support bundle of a cluster as a tar.gz stream
+// Failing to collect one item doesn't stop the collection, the failure is recorded in errors.txt
+func collectSupportBundle(w io.Writer, containerName string, source supportBundleSource) error {
+	containerNameToShow := containerName[len(containerNamePrefix):]
+
+	gz := gzip.NewWriter(w)
+	bundle := &supportBundle{
+		tw:      tar.NewWriter(gz),
+		prefix:  containerNameToShow + "-support-bundle/",
+		modTime: time.Now(),
+		source:  source,
+	}
+
+	bundle.addFile("cn-version.txt", []byte(cnVersion+"\n"))
+	bundle.addJSON("runtime/version.json", source.version)
+	bundle.addJSON("runtime/info.json", source.info)
+
+	running := false
+	inspect, err := source.inspect(containerName)
+	if err != nil {
+		bundle.recordError("container/inspect.json", err)
+	} else {
+		running = inspect.State != nil && inspect.State.Running
+		inspect.Config.Env = redactEnv(inspect.Config.Env)
+		inspect.Config.Labels = redactLabels(inspect.Config.Labels)
+		bundle.addJSON("container/inspect.json", func() (interface{}, error) { return inspect, nil })
+		bundle.addConfig(inspect)
+	}
+
+	logs, err := source.logs(containerName)
+	if err != nil {
+		bundle.recordError("container/logs.txt", err)
+	} else {
+		var stderr bytes.Buffer
+		content, err := ioutil.ReadAll(demultiplexStream(logs, &stderr))
+		if err != nil {
+			bundle.recordError("container/logs.txt", err)
+		}
+		bundle.addFile("container/logs.txt", append(content, stderr.Bytes()...))
+	}
+
+	if running {
+		for _, daemon := range logDaemons {
+			bundle.addExec("logs/"+daemon+".log", containerName, []string{"cat", getDaemonLogPath(containerName, daemon)})
+		}
+		bundle.addExec("ceph/status.json", containerName, []string{"ceph", "-s", "--format", "json"})
+		bundle.addExec("ceph/health-detail.json", containerName, []string{"ceph", "health", "detail", "--format", "json"})
+		bundle.addExec("ceph/osd-tree.json", containerName, []string{"ceph", "osd", "tree", "--format", "json"})
+		bundle.addExec("ceph/df.json", containerName, []string{"ceph", "df", "--format", "json"})
+	} else {
+		bundle.errors = append(bundle.errors, "cluster is not running, Ceph logs and status were not collected")
+	}
+
+	if len(bundle.errors) > 0 {
+		bundle.addFile("errors.txt", []byte(strings.Join(bundle.errors, "\n")+"\n"))
+	}
+
+	if err := bundle.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addConfig adds the effective flavor and image configuration of a cluster
+func (b *supportBundle) addConfig(inspect types.ContainerJSON) {
+	flavorName := inspect.Config.Labels["flavor"]
+	b.addJSON("config/flavor.json", func() (interface{}, error) {
+		return map[string]interface{}{
+			"name":               flavorName,
+			"configuration_file": configurationFile,
+			"settings":           getFlavorParameters(flavorName),
+		}, nil
+	})
+	b.addJSON("config/image.json", func() (interface{}, error) {
+		image, err := b.source.inspectImage(inspect.Image)
+		if err != nil {
+			return nil, err
+		}
+		imageConfig := map[string]interface{}{
+			"name":         inspect.Config.Image,
+			"id":           image.ID,
+			"repo_tags":    image.RepoTags,
+			"repo_digests": image.RepoDigests,
+			"created":      image.Created,
+		}
+		if image.Config != nil {
+			imageConfig["labels"] = image.Config.Labels
+		}
+		return imageConfig, nil
+	})
+}
+
+// addExec adds the stdout of a command run inside the container
+func (b *supportBundle) addExec(name string, containerName string, cmd []string) {
+	var stderr bytes.Buffer
+	stream, err := b.source.exec(containerName, cmd, &stderr)
+	if err != nil {
+		b.recordError(name, err)
+		return
+	}
+	defer stream.Close()
+	content, err := ioutil.ReadAll(stream)
+	if err != nil {
+		b.recordError(name, err)
+	}
+	if stderr.Len() > 0 {
+		b.errors = append(b.errors, name+": "+strings.TrimSpace(stderr.String()))
+	}
+	b.addFile(name, content)
+}
+
+// addJSON adds the JSON representation of what get returns
+func (b *supportBundle) addJSON(name string, get func() (interface{}, error)) {
+	value, err := get()
+	if err != nil {
+		b.recordError(name, err)
+		return
+	}
+	// <redacted> stays readable instead of being escaped for HTML
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		b.recordError(name, err)
+		return
+	}
+	b.addFile(name, content.Bytes())
+}
+
+// addFile adds a regular file in the bundle
+func (b *supportBundle) addFile(name string, content []byte) {
+	header := &tar.Header{
+		Name:    b.prefix + name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: b.modTime,
+	}
+	if err := b.tw.WriteHeader(header); err != nil {
+		b.recordError(name, err)
+		return
+	}
+	if _, err := b.tw.Write(content); err != nil {
+		b.recordError(name, err)
+	}
+}
+
+func (b *supportBundle) recordError(name string, err error) {
+	b.errors = append(b.errors, name+": "+err.Error())
+}
+
+// redactEnv hides the values of environment variables which look like secrets
+func redactEnv(envs []string) []string {
+	var redacted []string
+	for _, env := range envs {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) == 2 && secretPattern.MatchString(parts[0]) {
+			env = parts[0] + "=<redacted>"
+		}
+		redacted = append(redacted, env)
+	}
+	return redacted
+}
+
+// redactLabels hides the values of labels which look like secrets
+func redactLabels(labels map[string]string) map[string]string {
+	redacted := make(map[string]string)
+	for key, value := range labels {
+		if secretPattern.MatchString(key) {
+			value = "<redacted>"
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
@@ -0,0 +1,165 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBundleSource is a cluster whose Docker info and ceph df can't be collected
+type fakeBundleSource struct {
+	running bool
+}
+
+func (fakeBundleSource) version() (interface{}, error) {
+	return map[string]string{"Version": "18.09.0"}, nil
+}
+
+func (fakeBundleSource) info() (interface{}, error) {
+	return nil, errors.New("info unavailable")
+}
+
+func (s fakeBundleSource) inspect(containerName string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			Image: "sha256:image",
+			State: &types.ContainerState{Running: s.running},
+		},
+		Config: &container.Config{
+			Image: "ceph/daemon:latest-mimic",
+			Env:   []string{"CEPH_DEMO_UID=nano", "CEPH_DEMO_ACCESS_KEY=AKIAEXAMPLE", "RGW_SECRET=hunter2", "NETWORK_AUTO_DETECT=4"},
+			Labels: map[string]string{
+				"flavor":      "default",
+				"s3_password": "hunter3",
+			},
+		},
+	}, nil
+}
+
+func (fakeBundleSource) logs(containerName string) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	stdcopy.NewStdWriter(&buf, stdcopy.Stdout).Write([]byte("demo started\n"))
+	return ioutil.NopCloser(&buf), nil
+}
+
+func (fakeBundleSource) inspectImage(image string) (types.ImageInspect, error) {
+	return types.ImageInspect{ID: image, RepoTags: []string{"ceph/daemon:latest-mimic"}}, nil
+}
+
+func (fakeBundleSource) exec(containerName string, cmd []string, stderr io.Writer) (io.ReadCloser, error) {
+	if cmd[1] == "df" {
+		return nil, errors.New("exec failed")
+	}
+	return ioutil.NopCloser(strings.NewReader(strings.Join(cmd, " "))), nil
+}
+
+// readSupportBundle returns the files of a bundle by name
+func readSupportBundle(t *testing.T, bundle []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(content)
+	}
+	return files
+}
+
+func TestCollectSupportBundle(t *testing.T) {
+	readConfigFile(configFile)
+
+	var buf bytes.Buffer
+	assert.Nil(t, collectSupportBundle(&buf, containerNamePrefix+"mycluster", fakeBundleSource{running: true}))
+	files := readSupportBundle(t, buf.Bytes())
+
+	var names []string
+	for name := range files {
+		names = append(names, strings.TrimPrefix(name, "mycluster-support-bundle/"))
+	}
+	assert.ElementsMatch(t, []string{
+		"cn-version.txt",
+		"runtime/version.json",
+		"container/inspect.json",
+		"config/flavor.json",
+		"config/image.json",
+		"container/logs.txt",
+		"logs/mon.log", "logs/mgr.log", "logs/osd.log", "logs/rgw.log",
+		"ceph/status.json", "ceph/health-detail.json", "ceph/osd-tree.json",
+		"errors.txt",
+	}, names)
+
+	// What failed is recorded, the rest is collected
+	errorsTxt := files["mycluster-support-bundle/errors.txt"]
+	assert.Contains(t, errorsTxt, "runtime/info.json: info unavailable")
+	assert.Contains(t, errorsTxt, "ceph/df.json: exec failed")
+	assert.Equal(t, "demo started\n", files["mycluster-support-bundle/container/logs.txt"])
+
+	// Secrets never make it to the bundle
+	inspect := files["mycluster-support-bundle/container/inspect.json"]
+	for _, secret := range []string{"AKIAEXAMPLE", "hunter2", "hunter3"} {
+		assert.NotContains(t, inspect, secret)
+	}
+	assert.Contains(t, inspect, "CEPH_DEMO_ACCESS_KEY=<redacted>")
+	assert.Contains(t, inspect, "NETWORK_AUTO_DETECT=4")
+}
+
+func TestCollectSupportBundleStopped(t *testing.T) {
+	readConfigFile(configFile)
+
+	var buf bytes.Buffer
+	assert.Nil(t, collectSupportBundle(&buf, containerNamePrefix+"mycluster", fakeBundleSource{}))
+	files := readSupportBundle(t, buf.Bytes())
+	assert.NotContains(t, files, "mycluster-support-bundle/logs/rgw.log")
+	assert.Contains(t, files["mycluster-support-bundle/errors.txt"], "cluster is not running")
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t,
+		[]string{"CEPH_DEMO_UID=nano", "CEPH_DEMO_ACCESS_KEY=<redacted>", "CEPH_DEMO_SECRET_KEY=<redacted>", "DB_PASSWORD=<redacted>", "GITHUB_TOKEN=<redacted>", "NOVALUE"},
+		redactEnv([]string{"CEPH_DEMO_UID=nano", "CEPH_DEMO_ACCESS_KEY=a", "CEPH_DEMO_SECRET_KEY=b", "DB_PASSWORD=c", "GITHUB_TOKEN=d", "NOVALUE"}))
+	assert.Equal(t,
+		map[string]string{"flavor": "default", "api_key": "<redacted>", "Credentials": "<redacted>"},
+		redactLabels(map[string]string{"flavor": "default", "api_key": "x", "Credentials": "y"}))
+}
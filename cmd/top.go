@@ -0,0 +1,396 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/apcera/termtables"
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// topAll shows all the running clusters
+	topAll bool
+
+	// topOnce prints a single report instead of refreshing it
+	topOnce bool
+
+	// topOutput is the output format of the report
+	topOutput string
+
+	// topInterval is the time between two refreshes
+	topInterval time.Duration
+)
+
+// clusterUsage is what a cluster consumes on the host and inside Ceph
+type clusterUsage struct {
+	Name             string   `json:"name"`
+	CPUPercent       float64  `json:"cpu_percent"`
+	MemoryUsage      uint64   `json:"memory_usage_bytes"`
+	MemoryLimit      uint64   `json:"memory_limit_bytes"`
+	MemoryPercent    float64  `json:"memory_percent"`
+	NetworkRx        uint64   `json:"network_rx_bytes"`
+	NetworkTx        uint64   `json:"network_tx_bytes"`
+	BlockRead        uint64   `json:"block_read_bytes"`
+	BlockWrite       uint64   `json:"block_write_bytes"`
+	CephUsed         uint64   `json:"ceph_used_bytes"`
+	CephTotal        uint64   `json:"ceph_total_bytes"`
+	OSDUtilization   float64  `json:"osd_utilization_percent"`
+	RGWOpsPerSecond  float64  `json:"rgw_ops_per_second"`
+	Buckets          int      `json:"buckets"`
+	CollectionErrors []string `json:"errors,omitempty"`
+}
+
+// rgwRequestSample is the number of requests served by RGW at a given time
+type rgwRequestSample struct {
+	requests float64
+	at       time.Time
+}
+
+// cliClusterTop is the Cobra CLI call
+func cliClusterTop() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "top [cluster]",
+		Short: "Display the resources and the Ceph usage of clusters",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   topNano,
+		Example: "cn cluster top mycluster \n" +
+			"cn cluster top --all \n" +
+			"cn cluster top mycluster --once --output json \n",
+	}
+	cmd.Flags().SortFlags = false
+	cmd.Flags().BoolVar(&topAll, "all", false, "Show all running clusters")
+	cmd.Flags().BoolVar(&topOnce, "once", false, "Print a single report and exit")
+	cmd.Flags().StringVarP(&topOutput, "output", "o", "table", "Output format: table or json")
+	cmd.Flags().DurationVar(&topInterval, "interval", 2*time.Second, "Time between two refreshes")
+
+	return cmd
+}
+
+// topNano displays the usage of one or all clusters
+func topNano(cmd *cobra.Command, args []string) {
+	if len(args) == 0 && !topAll {
+		log.Fatal("Please give a cluster name or use --all.")
+	}
+	if len(args) == 1 && topAll {
+		log.Fatal("Please give either a cluster name or --all, not both.")
+	}
+	if topOutput != "table" && topOutput != "json" {
+		log.Fatal("Wrong output format " + topOutput + ". Please use table or json.")
+	}
+	if topInterval <= 0 {
+		log.Fatal("Wrong interval " + topInterval.String() + ". Please use a positive duration.")
+	}
+	if len(args) == 1 {
+		containerName := containerNamePrefix + args[0]
+		notExistCheck(containerName)
+		notRunningCheck(containerName)
+	}
+
+	rgwSamples := make(map[string]rgwRequestSample)
+	if topOnce {
+		// RGW ops/s are computed from two samples
+		for _, containerName := range getTopContainers(args) {
+			if sample, err := getRGWRequestSample(containerName); err == nil {
+				rgwSamples[containerName] = sample
+			}
+		}
+		time.Sleep(topInterval)
+	}
+
+	for {
+		var usages []clusterUsage
+		for _, containerName := range getTopContainers(args) {
+			usages = append(usages, getClusterUsage(containerName, rgwSamples))
+		}
+
+		if topOutput == "json" {
+			content, err := json.MarshalIndent(usages, "", "  ")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(string(content))
+		} else {
+			if !topOnce {
+				// Clear the screen and move the cursor to the top left corner
+				fmt.Print("\033[H\033[2J")
+			}
+			fmt.Println(renderClusterUsages(usages))
+		}
+
+		if topOnce {
+			return
+		}
+		time.Sleep(topInterval)
+	}
+}
+
+// getTopContainers returns the containers to report on
+func getTopContainers(args []string) []string {
+	if len(args) == 1 {
+		return []string{containerNamePrefix + args[0]}
+	}
+	var containerNames []string
+	for _, containerName := range getNanoContainers() {
+		if containerStatus(containerName, false, "running") {
+			containerNames = append(containerNames, containerName)
+		}
+	}
+	return containerNames
+}
+
+// renderClusterUsages returns the table view of the usages
+func renderClusterUsages(usages []clusterUsage) string {
+	table := termtables.CreateTable()
+	table.AddHeaders("NAME", "CPU %", "MEM USAGE / LIMIT", "MEM %", "NET I/O", "BLOCK I/O", "CEPH USED / TOTAL", "OSD %", "RGW OPS/S", "BUCKETS")
+	var collectionErrors []string
+	for _, usage := range usages {
+		table.AddRow(
+			usage.Name,
+			fmt.Sprintf("%.2f", usage.CPUPercent),
+			humanBytes(usage.MemoryUsage)+" / "+humanBytes(usage.MemoryLimit),
+			fmt.Sprintf("%.2f", usage.MemoryPercent),
+			humanBytes(usage.NetworkRx)+" / "+humanBytes(usage.NetworkTx),
+			humanBytes(usage.BlockRead)+" / "+humanBytes(usage.BlockWrite),
+			humanBytes(usage.CephUsed)+" / "+humanBytes(usage.CephTotal),
+			fmt.Sprintf("%.2f", usage.OSDUtilization),
+			fmt.Sprintf("%.1f", usage.RGWOpsPerSecond),
+			usage.Buckets,
+		)
+		for _, collectionError := range usage.CollectionErrors {
+			collectionErrors = append(collectionErrors, usage.Name+": "+collectionError)
+		}
+	}
+	view := table.Render()
+	if len(collectionErrors) > 0 {
+		view += "\n" + strings.Join(collectionErrors, "\n")
+	}
+	return view
+}
+
+// getClusterUsage collects the container stats and the Ceph usage of a cluster
+// Failing to collect an item is reported in the usage rather than stopping the view
+func getClusterUsage(containerName string, rgwSamples map[string]rgwRequestSample) clusterUsage {
+	usage := clusterUsage{Name: containerName[len(containerNamePrefix):]}
+	recordError := func(item string, err error) {
+		usage.CollectionErrors = append(usage.CollectionErrors, item+": "+err.Error())
+	}
+
+	if err := getContainerUsage(containerName, &usage); err != nil {
+		recordError("container stats", err)
+	}
+
+	var df struct {
+		Stats struct {
+			TotalBytes        uint64 `json:"total_bytes"`
+			TotalUsedBytes    uint64 `json:"total_used_bytes"`
+			TotalUsedRawBytes uint64 `json:"total_used_raw_bytes"`
+		} `json:"stats"`
+	}
+	if err := execContainerJSON(containerName, []string{"ceph", "df", "--format", "json"}, &df); err != nil {
+		recordError("ceph df", err)
+	} else {
+		usage.CephTotal = df.Stats.TotalBytes
+		usage.CephUsed = df.Stats.TotalUsedBytes
+		// Nautilus and later report the raw usage separately
+		if df.Stats.TotalUsedRawBytes > 0 {
+			usage.CephUsed = df.Stats.TotalUsedRawBytes
+		}
+	}
+
+	var osdDF struct {
+		Nodes []struct {
+			Utilization float64 `json:"utilization"`
+		} `json:"nodes"`
+	}
+	if err := execContainerJSON(containerName, []string{"ceph", "osd", "df", "--format", "json"}, &osdDF); err != nil {
+		recordError("ceph osd df", err)
+	} else {
+		for _, node := range osdDF.Nodes {
+			if node.Utilization > usage.OSDUtilization {
+				usage.OSDUtilization = node.Utilization
+			}
+		}
+	}
+
+	sample, err := getRGWRequestSample(containerName)
+	if err != nil {
+		recordError("rgw perf counters", err)
+	} else {
+		if previous, ok := rgwSamples[containerName]; ok {
+			usage.RGWOpsPerSecond = rgwOpsPerSecond(previous, sample)
+		}
+		rgwSamples[containerName] = sample
+	}
+
+	var buckets []string
+	if err := execContainerJSON(containerName, []string{"radosgw-admin", "bucket", "list"}, &buckets); err != nil {
+		recordError("radosgw-admin bucket list", err)
+	} else {
+		usage.Buckets = len(buckets)
+	}
+
+	return usage
+}
+
+// getContainerUsage fills the usage with the stats of the container runtime
+func getContainerUsage(containerName string, usage *clusterUsage) error {
+	inspect, err := getDocker().ContainerInspect(ctx, containerName)
+	if err != nil {
+		return err
+	}
+
+	response, err := getDocker().ContainerStats(ctx, containerName, false)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return err
+	}
+
+	usage.CPUPercent = calculateCPUPercent(&stats)
+	usage.MemoryUsage = calculateMemoryUsage(&stats)
+	// The flavor limit is what matters, the runtime reports the host memory when there is none
+	usage.MemoryLimit = stats.MemoryStats.Limit
+	if inspect.HostConfig != nil && inspect.HostConfig.Memory > 0 {
+		usage.MemoryLimit = uint64(inspect.HostConfig.Memory)
+	}
+	if usage.MemoryLimit > 0 {
+		usage.MemoryPercent = float64(usage.MemoryUsage) / float64(usage.MemoryLimit) * 100.0
+	}
+	usage.NetworkRx, usage.NetworkTx = calculateNetworkIO(&stats)
+	usage.BlockRead, usage.BlockWrite = calculateBlockIO(&stats)
+	return nil
+}
+
+// calculateCPUPercent returns the CPU usage like 'docker stats' does, 100% being one CPU
+func calculateCPUPercent(stats *types.StatsJSON) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * onlineCPUs * 100.0
+}
+
+// calculateMemoryUsage returns the memory used by the container without the page cache
+func calculateMemoryUsage(stats *types.StatsJSON) uint64 {
+	// cgroup v1 reports total_inactive_file, cgroup v2 inactive_file, older runtimes only cache
+	for _, key := range []string{"total_inactive_file", "inactive_file", "cache"} {
+		if cache, ok := stats.MemoryStats.Stats[key]; ok && cache < stats.MemoryStats.Usage {
+			return stats.MemoryStats.Usage - cache
+		}
+	}
+	return stats.MemoryStats.Usage
+}
+
+// calculateNetworkIO returns the bytes received and sent on all the container interfaces
+func calculateNetworkIO(stats *types.StatsJSON) (uint64, uint64) {
+	var rx, tx uint64
+	for _, network := range stats.Networks {
+		rx += network.RxBytes
+		tx += network.TxBytes
+	}
+	return rx, tx
+}
+
+// calculateBlockIO returns the bytes read and written on all the container block devices
+func calculateBlockIO(stats *types.StatsJSON) (uint64, uint64) {
+	var read, write uint64
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			read += entry.Value
+		case "write":
+			write += entry.Value
+		}
+	}
+	return read, write
+}
+
+// getRGWRequestSample reads the request counter of the RGW daemon
+func getRGWRequestSample(containerName string) (rgwRequestSample, error) {
+	var perf struct {
+		RGW struct {
+			Req float64 `json:"req"`
+		} `json:"rgw"`
+	}
+	err := execContainerJSON(containerName, []string{"ceph", "daemon", "client.rgw." + containerName + "-faa32aebf00b", "perf", "dump"}, &perf)
+	if err != nil {
+		return rgwRequestSample{}, err
+	}
+	return rgwRequestSample{requests: perf.RGW.Req, at: time.Now()}, nil
+}
+
+// rgwOpsPerSecond returns the rate of requests between two samples
+func rgwOpsPerSecond(previous rgwRequestSample, current rgwRequestSample) float64 {
+	elapsed := current.at.Sub(previous.at).Seconds()
+	// The counter goes back to zero when RGW restarts
+	if elapsed <= 0 || current.requests < previous.requests {
+		return 0
+	}
+	return (current.requests - previous.requests) / elapsed
+}
+
+// execContainerJSON runs a command inside the container and decodes its JSON output
+func execContainerJSON(containerName string, cmd []string, v interface{}) error {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := execContainerWithStdio(containerName, cmd, nil, &stdout, &stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return errors.New(strings.TrimSpace(stderr.String()) + fmt.Sprintf(" (exit code %d)", exitCode))
+	}
+	return json.Unmarshal(stdout.Bytes(), v)
+}
+
+// humanBytes returns a size with a binary unit, like 1.5GiB
+func humanBytes(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
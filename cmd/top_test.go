@@ -0,0 +1,83 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateContainerStats(t *testing.T) {
+	var stats types.StatsJSON
+	stats.PreCPUStats.CPUUsage.TotalUsage = 1000
+	stats.PreCPUStats.SystemUsage = 10000
+	stats.CPUStats.CPUUsage.TotalUsage = 1500
+	stats.CPUStats.SystemUsage = 12000
+	stats.CPUStats.OnlineCPUs = 2
+	assert.InDelta(t, 50.0, calculateCPUPercent(&stats), 0.001)
+
+	// Older runtimes don't report the number of online CPUs
+	stats.CPUStats.OnlineCPUs = 0
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{1, 2, 3, 4}
+	assert.InDelta(t, 100.0, calculateCPUPercent(&stats), 0.001)
+
+	stats.MemoryStats.Usage = 1000
+	stats.MemoryStats.Stats = map[string]uint64{"cache": 300}
+	assert.Equal(t, uint64(700), calculateMemoryUsage(&stats))
+	stats.MemoryStats.Stats = map[string]uint64{"inactive_file": 100, "cache": 300}
+	assert.Equal(t, uint64(900), calculateMemoryUsage(&stats))
+
+	stats.BlkioStats.IoServiceBytesRecursive = []types.BlkioStatEntry{
+		{Op: "Read", Value: 10},
+		{Op: "Write", Value: 20},
+		{Op: "read", Value: 1},
+		{Op: "Total", Value: 31},
+	}
+	read, write := calculateBlockIO(&stats)
+	assert.Equal(t, uint64(11), read)
+	assert.Equal(t, uint64(20), write)
+
+	stats.Networks = map[string]types.NetworkStats{
+		"eth0": {RxBytes: 100, TxBytes: 50},
+		"eth1": {RxBytes: 1, TxBytes: 2},
+	}
+	rx, tx := calculateNetworkIO(&stats)
+	assert.Equal(t, uint64(101), rx)
+	assert.Equal(t, uint64(52), tx)
+}
+
+func TestRGWOpsPerSecond(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, 25.0, rgwOpsPerSecond(rgwRequestSample{100, now}, rgwRequestSample{150, now.Add(2 * time.Second)}))
+	// RGW restarted
+	assert.Equal(t, 0.0, rgwOpsPerSecond(rgwRequestSample{100, now}, rgwRequestSample{10, now.Add(2 * time.Second)}))
+}
+
+func TestHumanBytes(t *testing.T) {
+	assert.Equal(t, "512B", humanBytes(512))
+	assert.Equal(t, "1.5KiB", humanBytes(1536))
+	assert.Equal(t, "1.0GiB", humanBytes(1<<30))
+}
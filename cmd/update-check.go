@@ -47,7 +47,7 @@ func cliUpdateCheckNano() *cobra.Command {
 
 // updateCheckNano print Ceph Nano version
 func updateCheckNano(cmd *cobra.Command, args []string) {
-	url := githubCNReleasesURL
+	url := getReleasesURL()
 	output := curlURL(url)
 
 	parser, err := gojq.NewStringQuery(string(output))
@@ -84,7 +84,7 @@ func updateCheckNano(cmd *cobra.Command, args []string) {
 			latestBuildURL, err := getLatestBuildURL(runtime.GOOS, runtime.GOARCH, latestTagString, assets)
 			if err == nil {
 				findURL = false
-				fmt.Printf("There is a newer version of cn available. Download it with:'curl -L %s -o cn && chmod +x cn && sudo mv cn /usr/local/bin/'\n", latestBuildURL)
+				fmt.Printf("There is a newer version of cn available. Install it with 'cn self-update' or download it with:'curl -L %s -o cn && chmod +x cn && sudo mv cn /usr/local/bin/'\n", latestBuildURL)
 			}
 		}
 		if findURL {
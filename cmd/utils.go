@@ -23,7 +23,6 @@
 package cmd
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -34,11 +33,9 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
 	"os/user"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -47,61 +44,12 @@ import (
 	"github.com/alecthomas/units"
 	"github.com/docker/docker/api/types"
 	"github.com/elgs/gojq"
-	"github.com/jmoiron/jsonq"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/sys/unix"
 )
 
-const pageSize int = 100
-
-// byLastOctetValue implements sort.Interface used in sorting a list
-// of ip address by their last octet value.
-type byLastOctetValue []net.IP
-
-func (n byLastOctetValue) Len() int      { return len(n) }
-func (n byLastOctetValue) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
-func (n byLastOctetValue) Less(i, j int) bool {
-	return []byte(n[i].To4())[3] < []byte(n[j].To4())[3]
-}
-
-// getInterfaceIPv4s is synonymous to net.InterfaceAddrs()
-// returns net.IP IPv4 only representation of the net.Addr.
-// Additionally the returned list is sorted by their last
-// octet value.
-//
-// [The logic to sort by last octet is implemented to
-// prefer CIDRs with higher octets, this in-turn skips the
-// localhost/loopback address to be not preferred as the
-// first ip on the list. Subsequently this list helps us print
-// a user friendly message with appropriate values].
-func getInterfaceIPv4s() ([]net.IP, error) {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return nil, fmt.Errorf("Unable to determine network interface address. %s", err)
-	}
-	// Go through each return network address and collate IPv4 addresses.
-	var nips []net.IP
-	for _, addr := range addrs {
-		if addr.Network() == "ip+net" {
-			var nip net.IP
-			// Attempt to parse the addr through CIDR.
-			nip, _, err = net.ParseCIDR(addr.String())
-			if err != nil {
-				return nil, fmt.Errorf("Unable to parse address %s, error %s", addr, err)
-			}
-			// Collect only IPv4 addrs.
-			if nip.To4() != nil {
-				nips = append(nips, nip)
-			}
-		}
-	}
-	// Sort the list of IPs by their last octet value.
-	sort.Sort(sort.Reverse(byLastOctetValue(nips)))
-	return nips, nil
-}
-
 func stripCtlAndExtFromUTF8(str string) string {
 	return strings.Map(func(r rune) rune {
 		if r >= 32 && r < 127 || r == 10 {
@@ -140,6 +88,46 @@ func execContainer(containerName string, cmd []string) string {
 	return stripCtlAndExtFromUTF8(string(output))
 }
 
+// execContainerStream execs a given command inside the container and streams its output
+// stdout is returned as a reader while stderr is copied to the given writer
+func execContainerStream(containerName string, cmd []string, stderr io.Writer) (io.ReadCloser, error) {
+	optionsCreate := types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	}
+
+	response, err := getDocker().ContainerExecCreate(ctx, containerName, optionsCreate)
+	if err != nil {
+		return nil, err
+	}
+
+	optionsAttach := types.ExecConfig{
+		Detach: false,
+		Tty:    false,
+	}
+	connection, err := getDocker().ContainerExecAttach(ctx, response.ID, optionsAttach)
+	if err != nil {
+		return nil, err
+	}
+
+	return demultiplexStream(hijackedReadCloser{connection}, stderr), nil
+}
+
+// hijackedReadCloser closes the connection of an attached exec once its output is read
+type hijackedReadCloser struct {
+	types.HijackedResponse
+}
+
+func (h hijackedReadCloser) Read(p []byte) (int, error) {
+	return h.Reader.Read(p)
+}
+
+func (h hijackedReadCloser) Close() error {
+	h.HijackedResponse.Close()
+	return nil
+}
+
 // enterContainer enters inside a given container
 func enterContainer(containerName string) error {
 	optionsCreate := types.ExecConfig{
@@ -251,12 +239,26 @@ func cephNanoHealth(containerName string) {
 	buf.ReadFrom(out)
 	newStr := buf.String()
 	fmt.Println(newStr)
+	reportStartFailure(containerName)
+}
+
+// reportStartFailure collects a support bundle and asks to open an issue
+func reportStartFailure(containerName string) {
+	recordHealthCheckFailure(containerName)
+	if bundle := collectSupportBundleOnFailure(containerName); len(bundle) > 0 {
+		log.Fatal("Please open an issue at: https://github.com/ceph/cn and attach the support bundle " + bundle + ".")
+	}
 	log.Fatal("Please open an issue at: https://github.com/ceph/cn with the logs above.")
 }
 
 // curlTestURL tests a given URL
 func curlTestURL(url string) bool {
-	response, err := http.Get(url)
+	return curlTestURLWithClient(http.DefaultClient, url)
+}
+
+// curlTestURLWithClient tests a given URL with a specific HTTP client
+func curlTestURLWithClient(client *http.Client, url string) bool {
+	response, err := client.Get(url)
 	if err != nil {
 		return false
 	}
@@ -282,73 +284,20 @@ func curlURL(url string) []byte {
 	return content
 }
 
-// countTags queries the number of tags
-func countTags() int {
-	var url string
-	data := map[string]interface{}{}
-	url = "https://registry.hub.docker.com/v2/repositories/ceph/daemon/tags/"
-	output := curlURL(url)
-	dec := json.NewDecoder(strings.NewReader(string(output)))
-	dec.Decode(&data)
-	jq := jsonq.NewQuery(data)
-	tagCount, _ := jq.Int("count")
-	return tagCount
-}
-
-func pageCount() int {
-	tagCount := countTags()
-	pageCount := tagCount / pageSize
-	remainder := tagCount % pageSize
-	if remainder > 0 {
-		pageCount++
-	}
-	return int(pageCount)
-}
-
-// parseMap parses a json element
-// re-adapted code from:
-// https://stackoverflow.com/questions/29366038/looping-iterate-over-the-second-level-nested-json-in-go-lang
-func parseMap(aMap map[string]interface{}, keyType string, image string) {
-	for key, val := range aMap {
-		switch concreteVal := val.(type) {
-		case []interface{}:
-			parseArray(val.([]interface{}), keyType, image)
-		default:
-			if key == keyType {
-				fmt.Print(image)
-				fmt.Println(concreteVal)
-			}
-		}
-	}
-}
-
-// parseArray parses json array
-// re-adapted code from:
-// https://stackoverflow.com/questions/29366038/looping-iterate-over-the-second-level-nested-json-in-go-lang
-func parseArray(anArray []interface{}, keyType string, image string) {
-	for _, val := range anArray {
-		switch concreteVal := val.(type) {
-		case map[string]interface{}:
-			parseMap(val.(map[string]interface{}), keyType, image)
-		default:
-			fmt.Print(image)
-			fmt.Println(concreteVal)
-		}
-	}
-}
-
 // CephNanoS3Health loops for 20 seconds while testing Ceph RGW health
 func cephNanoS3Health(containerName string, rgwPort string) {
 	// setting timeout
 	timeout := 20
 	poll := 0
-	ips, _ := getInterfaceIPv4s()
-	// Taking the first IP is probably not ideal
-	// IMHO, using the interface with most of the traffic is better
-	url := "http://" + ips[0].String() + ":" + rgwPort
+	url := buildURL(getEndpointScheme(containerName), getClusterEndpointHost(containerName), rgwPort)
+
+	client := http.DefaultClient
+	if isTLSCluster(containerName) {
+		client = getTLSHTTPClient()
+	}
 
 	for poll < timeout {
-		if curlTestURL(url) {
+		if curlTestURLWithClient(client, url) {
 			return
 		}
 		time.Sleep(time.Second * 1)
@@ -358,36 +307,49 @@ func cephNanoS3Health(containerName string, rgwPort string) {
 	log.Println("Timeout while trying to reach: " + url)
 	log.Println("S3 gateway for cluster " + containerNameToShow + " is not responding. Showing S3 logs (if any):")
 	showS3Logs(containerName)
-	log.Fatal("Please open an issue at: https://github.com/ceph/cn.")
+	reportStartFailure(containerName)
 }
 
 // echoInfo prints useful information about Ceph Nano
 func echoInfo(containerName string) {
+	// Always wait the container to be ready
+	cephNanoHealth(containerName)
+	cephNanoS3Health(containerName, dockerInspect(containerName, "PortBindingsRgw"))
+
+	printClusterInfo(containerName)
+}
+
+// printClusterInfo prints the endpoints and keys of a cluster that is ready
+func printClusterInfo(containerName string) {
 	// Get listening port
 	rgwPort := dockerInspect(containerName, "PortBindingsRgw")
 	cnBrowserPort := dockerInspect(containerName, "PortBindingsBrowser")
 
-	// Always wait the container to be ready
-	cephNanoHealth(containerName)
-	cephNanoS3Health(containerName, rgwPort)
-
 	// Fetch Amazon Keys
 	cephNanoAccessKey, cephNanoSecretKey := getAwsKey(containerName)
 
-	// Get IPs, later using the first IP of the list is not ideal
-	// However, Docker binds RGW port on 0.0.0.0 so any address will work
-	ips, _ := getInterfaceIPv4s()
+	// Get the address the cluster was advertised on when created
+	endpointHost := getClusterEndpointHost(containerName)
 
 	// Get the working directory
 	dir := dockerInspect(containerName, "Binds")
 
-	infoLine := "\n" + "Endpoint: http://" + ips[0].String() + ":" + rgwPort + "\n"
+	infoLine := "\n" + "Endpoint: " + buildURL(getEndpointScheme(containerName), endpointHost, rgwPort) + "\n"
 	if cnBrowserPort != "NoUIYet" {
-		infoLine = infoLine + "Dashboard: http://" + ips[0].String() + ":" + cnBrowserPort + "\n"
+		infoLine = infoLine + "Dashboard: " + buildURL("http", endpointHost, cnBrowserPort) + "\n"
 	}
     infoLine = infoLine + "Access key: " + cephNanoAccessKey + "\n" +
                           "Secret key: " + cephNanoSecretKey + "\n" +
                           "Working directory: " + dir + "\n"
+	if isTLSCluster(containerName) {
+		infoLine = infoLine + "CA bundle: " + getCABundlePath() + "\n"
+	}
+	if isMemoryStorageCluster(containerName) {
+		infoLine = infoLine + "Storage: memory, data is thrown away on stop and purge\n"
+	}
+	if prometheusPort := dockerInspect(containerName, "prometheus_port"); len(prometheusPort) > 0 {
+		infoLine = infoLine + "Prometheus: " + buildURL("http", endpointHost, prometheusPort) + "/metrics\n"
+	}
 	fmt.Println(infoLine)
 }
 
@@ -435,6 +397,11 @@ func dockerInspect(containerName string, pattern string) string {
 	case "Binds":
 		return strings.Split(inspect.HostConfig.Binds[0], ":")[0]
 	case "PortBindingsRgw":
+		// Newer containers record the published port in a label as
+		// RGW_FRONTEND_PORT is not published when TLS is enabled
+		if rgwPort, ok := inspect.Config.Labels["rgw_port"]; ok {
+			return rgwPort
+		}
 		return strings.Split(inspect.Config.Env[0], "=")[1]
 	case "PortBindingsBrowser":
 		parts := strings.Split(inspect.Config.Env[1], "=")
@@ -447,16 +414,45 @@ func dockerInspect(containerName string, pattern string) string {
 
 	case "BindsData":
 		// The part is helpful when passing a dedicated directory to store Ceph's data
-		// We look for bindmounts, if we find more than 1 (the first one is the work-dir)
-		// then this means we passed a dedicated directory, this is used by the purge function
-		// to remove the OSD data content once we purge the cluster
-		parts := inspect.HostConfig.Binds
-		if len(inspect.HostConfig.Binds) >= 2 {
-			parts = strings.Split(parts[1], ":")
-		} else {
-			return "noDataDir"
+		// We look for the OSD environment variables set by runContainer rather than
+		// the position of the bindmounts as other bindmounts (e.g: certificates) can follow the work-dir
+		// This is used by the purge function to remove the OSD data content once we purge the cluster
+		for _, env := range inspect.Config.Env {
+			if strings.HasPrefix(env, "OSD_PATH=") {
+				return strings.TrimPrefix(env, "OSD_PATH=")
+			}
+			if strings.HasPrefix(env, "OSD_DEVICE=") {
+				return "/dev"
+			}
+		}
+		return "noDataDir"
+
+	case "tls":
+		if inspect.Config.Labels["tls"] == "true" {
+			return "true"
 		}
-		return parts[1]
+		return "false"
+
+	case "advertise_address":
+		return inspect.Config.Labels["advertise_address"]
+
+	case "prometheus_port":
+		return inspect.Config.Labels["prometheus_port"]
+
+	case "storage":
+		return inspect.Config.Labels["storage"]
+
+	case "data_file":
+		return inspect.Config.Labels["data_file"]
+
+	case "data_file_created":
+		return inspect.Config.Labels["data_file_created"]
+
+	case "loop_device":
+		return inspect.Config.Labels["loop_device"]
+
+	case "partition":
+		return inspect.Config.Labels["partition"]
 
 	case "flavor":
 		flavor := inspect.Config.Labels["flavor"]
@@ -502,32 +498,19 @@ func inspectImage(ImageID string, dataType string) string {
 
 // pullImage downloads the container image
 func pullImage() bool {
-	_, _, err := getDocker().ImageInspectWithRaw(ctx, getImageName())
+	return pullImageNamed(getImageName())
+}
+
+// pullImageNamed downloads a container image unless it's present, e.g: loaded from a bundle
+func pullImageNamed(name string) bool {
+	_, _, err := getDocker().ImageInspectWithRaw(ctx, getLocalImage(name))
 	if err != nil {
-		fmt.Println("The container image (" + getImageName() + ") is not present, pulling it. \n" +
+		fmt.Println("The container image (" + name + ") is not present, pulling it. \n" +
 			"This operation can take a few minutes.")
 
-		out, err := getDocker().ImagePull(ctx, getImageName(), types.ImagePullOptions{})
-		if err != nil {
-			// the error message will appear on a new line after the info above
-			log.Println()
+		if _, err := pullImageWithProgress(name); err != nil {
 			log.Fatal(err)
 		}
-
-		reader := bufio.NewReader(out)
-		defer out.Close() // pullResp is io.ReadCloser
-		var respo bytes.Buffer
-		for {
-			line, err := reader.ReadBytes('\n')
-			if err != nil {
-				// it could be EOF or read error
-				break
-			}
-			respo.Write(line)
-			respo.WriteByte('\n')
-			fmt.Print(".")
-		}
-		fmt.Println("")
 		return true
 	}
 	return false
@@ -645,8 +628,7 @@ func copyDir(src string, dst string) (err error) {
 }
 
 // checkPortInUsed checks if a port is in-used
-func checkPortInUsed(portNum string) bool {
-	hostName := "0.0.0.0"
+func checkPortInUsed(hostName string, portNum string) bool {
 	seconds := 1
 	timeOut := time.Duration(seconds) * time.Second
 
@@ -661,11 +643,11 @@ func checkPortInUsed(portNum string) bool {
 }
 
 // generateRGWPortToUse generates the binding port for Ceph Rados Gateway
-func generateRGWPortToUse() string {
+func generateRGWPortToUse(hostName string) string {
 	maxPort := 8100
 	for i := 8000; i <= maxPort; i++ {
 		portNumStr := fmt.Sprint(i)
-		status := checkPortInUsed(portNumStr)
+		status := checkPortInUsed(hostName, portNumStr)
 		if status {
 			return portNumStr
 		}
@@ -674,11 +656,24 @@ func generateRGWPortToUse() string {
 }
 
 // generateBrowserPortToUse generates the binding port for cn UI
-func generateBrowserPortToUse() string {
+func generateBrowserPortToUse(hostName string) string {
 	maxPort := 5100
 	for i := 5000; i <= maxPort; i++ {
 		portNumStr := fmt.Sprint(i)
-		status := checkPortInUsed(portNumStr)
+		status := checkPortInUsed(hostName, portNumStr)
+		if status {
+			return portNumStr
+		}
+	}
+	return "notfound"
+}
+
+// generatePrometheusPortToUse generates the binding port for the mgr prometheus module
+func generatePrometheusPortToUse(hostName string) string {
+	maxPort := 9383
+	for i := 9283; i <= maxPort; i++ {
+		portNumStr := fmt.Sprint(i)
+		status := checkPortInUsed(hostName, portNumStr)
 		if status {
 			return portNumStr
 		}
@@ -715,59 +710,6 @@ func getFileType(pathname string) (string, error) {
 	return "error", fmt.Errorf("only recognize file, directory, socket, block device and character device")
 }
 
-// testBinaryExist tests if a binary is present on the system
-func testBinaryExist(binary string) bool {
-	binary, err := exec.LookPath(binary)
-	if err != nil {
-		log.Fatal(binary + " is not installed!")
-	}
-	return true
-}
-
-// getDiskFormat returns information about a disk such as filesystem and partition table
-func getDiskFormat(disk string) string {
-	testBinaryExist("blkid")
-
-	out, err := exec.Command("blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", disk).Output()
-	if err != nil {
-		// Disk device does not have any label or is a partition
-		// For `blkid`, if the specified token (TYPE/PTTYPE, etc) was
-		// not found, or no (specified) devices could be identified, an
-		// exit code of 2 is returned.
-		// We are not 100% that this is the problem, but the code called before this already made sure
-		// that the device was a block special device, so if blkid fails that's probably because there is
-		// nothing to see. My first assumption is that the device does not have a partition label OR
-		// is a partition
-		log.Fatal("I suspect either the disk" + disk + " has no partition label or is a partition.\n" +
-			"If you gave me a whole device, make sure it has a partition table (e.g: gpt). \n" +
-			"If you gave me a partition, I don't support partitions yet, give me a whole device.\n" +
-			"As an alternative, you can create a filesystem on this partition and give the mountpoint to me.\n" +
-			"\nAlso if the disk was an OSD you need to zap it (e.g: with 'ceph-disk zap').")
-	}
-	return string(out)
-}
-
-// getDiskPartitions returns the list of partitions on a disk
-func getDiskPartitions(disk string) []string {
-	testBinaryExist("parted")
-
-	out, err := exec.Command("parted", "-s", "-m", disk, "print").Output()
-	if err != nil {
-		log.Fatal(err)
-	}
-	var partitions []string
-	lines := strings.Split(string(out), "\n")
-	// build a slice
-	for _, l := range lines {
-		if len(l) <= 0 {
-			// Ignore empty line.
-			continue
-		}
-		partitions = append(partitions, l)
-	}
-	return partitions
-}
-
 // exclusiveOpenFailsOnDevice tries to open a device with O_EXCL flag
 // stolen and re-adapted from https://github.com/kubernetes/kubernetes/blob/77d18dbad9d2abea7d7b7b22be02fb422e03f0a9/pkg/util/mount/mount_linux.go#L306
 func exclusiveOpenFailsOnDevice(pathname string) (bool, error) {
@@ -812,54 +754,17 @@ func whoAmI() (string, string) {
 
 // toBytes converts storage units into bytes to ease comparison between different units
 func toBytes(value string) int64 {
-	var bytes units.Base2Bytes
-	var err error
-	bytes, err = units.ParseBase2Bytes(value)
+	bytes, err := parseBytes(value)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return int64(bytes)
-}
-
-func listDockerRegistryImageTags() {
-	var numPage int
-	var url string
-
-	// Creating the maps for JSON
-	m := map[string]interface{}{}
-
-	numPage = 1
-	if ListAllTags {
-		numPage = pageCount()
-	}
-
-	for i := 1; i <= numPage; i++ {
-		// convert numPage into a string for concatenation, (see the end)
-		url = "https://registry.hub.docker.com/v2/repositories/ceph/daemon/tags/?page_size=" + strconv.Itoa(pageSize) + "&page=" + strconv.Itoa(i)
-		output := curlURL(url)
-
-		// Parsing/Unmarshalling JSON encoding/json
-		err := json.Unmarshal([]byte(output), &m)
-		if err != nil {
-			log.Fatal(err)
-		}
-		parseMap(m, "name", "ceph/daemon:")
-	}
+	return bytes
 }
 
-func listRedHatRegistryImageTags() {
-	url := "https://registry.access.redhat.com/v2/rhceph/rhceph-3-rhel7/tags/list"
-	output := curlURL(url)
-
-	// Creating the maps for JSON
-	m := map[string]interface{}{}
-
-	// Parsing/Unmarshalling JSON encoding/json
-	err := json.Unmarshal([]byte(output), &m)
-	if err != nil {
-		log.Fatal(err)
-	}
-	parseMap(m, "tags", "registry.access.redhat.com/rhceph/rhceph-3-rhel7:")
+// parseBytes transforms a user-defined size (like 1GB) in bytes
+func parseBytes(value string) (int64, error) {
+	bytes, err := units.ParseBase2Bytes(value)
+	return int64(bytes), err
 }
 
 func getImageName(customImageName ...string) string {
@@ -873,8 +778,13 @@ func getImageName(customImageName ...string) string {
 		return getImageNameFromConfig(image_name)
 	}
 
+	// An alias may also come from a bundle loaded with 'image load'
+	if image := findLoadedImage(readLoadedImages(), image_name); image != nil && image.Alias == image_name {
+		return image.Name
+	}
+
 	// Returning what the user provided, surely a custom value.
-	return imageName
+	return image_name
 }
 
 func getPrivileged(containerFlavor string) bool {
@@ -885,6 +795,47 @@ func setPrivileged(containerFlavor string, value bool) {
 	viper.SetDefault(FLAVORS+"."+containerFlavor+".privileged", value)
 }
 
+// getTLS reports if the S3 endpoint of a flavor is published over https
+func getTLS(containerFlavor string) bool {
+	// If the user provided --tls, let's return that value
+	if enableTLS {
+		return true
+	}
+
+	// Flavors not inheriting from default may not define it
+	if !isParameterExist(FLAVORS, containerFlavor, "tls") {
+		return false
+	}
+	return getBoolFromConfig(FLAVORS, containerFlavor, "tls")
+}
+
+// getPrometheus reports if a flavor enables the mgr prometheus module
+func getPrometheus(containerFlavor string) bool {
+	// If the user provided --prometheus, let's return that value
+	if enablePrometheus {
+		return true
+	}
+
+	// Flavors not inheriting from default may not define it
+	if !isParameterExist(FLAVORS, containerFlavor, "prometheus") {
+		return false
+	}
+	return getBoolFromConfig(FLAVORS, containerFlavor, "prometheus")
+}
+
+// isTLSCluster reports if a running cluster publishes its S3 endpoint over https
+func isTLSCluster(containerName string) bool {
+	return dockerInspect(containerName, "tls") == "true"
+}
+
+// getEndpointScheme returns the scheme of the S3 endpoint of a cluster
+func getEndpointScheme(containerName string) string {
+	if isTLSCluster(containerName) {
+		return "https"
+	}
+	return "http"
+}
+
 // PrettyPrint to print a datastructure
 func PrettyPrint(v interface{}) {
 	b, err := json.MarshalIndent(v, "", "  ")
@@ -939,6 +890,54 @@ func getSize(containerFlavor string) string {
 	return getStringFromConfig(FLAVORS, containerFlavor, "size")
 }
 
+func getBindAddress(containerFlavor string) string {
+
+	// If the user provided a --bind-address, let's return that value
+	if len(bindAddress) > 0 {
+		return bindAddress
+	}
+
+	// Flavors not inheriting from default may not define it
+	if !isParameterExist(FLAVORS, containerFlavor, "bind_address") {
+		return DEFAULTBINDADDRESS
+	}
+
+	// Unless return the value from the flavor
+	return getStringFromConfig(FLAVORS, containerFlavor, "bind_address")
+}
+
+func getAdvertiseAddress(containerFlavor string) string {
+
+	// If the user provided a --advertise-address, let's return that value
+	if len(advertiseAddress) > 0 {
+		return advertiseAddress
+	}
+
+	// Flavors not inheriting from default may not define it
+	if !isParameterExist(FLAVORS, containerFlavor, "advertise_address") {
+		return ""
+	}
+
+	// Unless return the value from the flavor
+	return getStringFromConfig(FLAVORS, containerFlavor, "advertise_address")
+}
+
+func getTTL(containerFlavor string) string {
+
+	// If the user provided a --ttl, let's return that value
+	if len(clusterTTL) > 0 {
+		return clusterTTL
+	}
+
+	// Flavors not inheriting from default may not define it
+	if !isParameterExist(FLAVORS, containerFlavor, "ttl") {
+		return ""
+	}
+
+	// Unless return the value from the flavor
+	return getStringFromConfig(FLAVORS, containerFlavor, "ttl")
+}
+
 func getWorkDirectory(containerFlavor string) string {
 
 	// If the user provided a -d, let's return that value
@@ -974,3 +973,27 @@ func getCephNanoPath() string {
 	}
 	return cephNanoPath
 }
+
+// writeFileAtomically replaces a file atomically, a reader never sees half of it
+// The mode of an existing file is kept, mode is used otherwise
+func writeFileAtomically(file string, content []byte, mode os.FileMode) error {
+	if finfo, err := os.Stat(file); err == nil {
+		mode = finfo.Mode()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
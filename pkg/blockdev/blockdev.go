@@ -0,0 +1,169 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+// Package blockdev reads partition tables and content signatures straight from a
+// block device or an image file, without relying on blkid or parted being installed.
+package blockdev
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Usage of a signature, following the blkid terminology
+const (
+	UsageFilesystem = "filesystem"
+	UsageRaid       = "raid"
+	UsageCrypto     = "crypto"
+	UsageOther      = "other"
+)
+
+// Partition table types, following the blkid terminology
+const (
+	PartitionTableGPT = "gpt"
+	PartitionTableDOS = "dos"
+)
+
+// Signature is something recognized on a device: a filesystem, an LVM physical volume, a bluestore label...
+type Signature struct {
+	Type   string // Type is the blkid name of the signature, e.g: ext4, xfs, LVM2_member, ceph_bluestore
+	Usage  string // Usage is what the signature is used for, e.g: filesystem, raid
+	Offset int64  // Offset is where the magic was found, in bytes
+	Length int    // Length of the magic, in bytes
+}
+
+// Partition is an entry of a partition table
+type Partition struct {
+	Number int    // Number is the number of the partition as the kernel names it (sdb3 is 3)
+	Start  int64  // Start is the offset of the partition, in bytes
+	Size   int64  // Size of the partition, in bytes
+	Type   string // Type is the GPT type GUID or the MBR type as an hexadecimal byte
+	Name   string // Name is the GPT partition name
+}
+
+// Report is what was found on a device
+type Report struct {
+	Size           int64
+	SectorSize     int64
+	PartitionTable string
+	Partitions     []Partition
+	Signatures     []Signature
+}
+
+// IsEmpty reports if nothing was found on the device
+func (r *Report) IsEmpty() bool {
+	return len(r.PartitionTable) == 0 && len(r.Signatures) == 0
+}
+
+// String describes the content of a device in a human readable way
+func (r *Report) String() string {
+	if r.IsEmpty() {
+		return "no partition table and no signature"
+	}
+	description := ""
+	if len(r.PartitionTable) > 0 {
+		description = fmt.Sprintf("a partition table type %s and %d partition(s)", r.PartitionTable, len(r.Partitions))
+	}
+	for _, signature := range r.Signatures {
+		if len(description) > 0 {
+			description += ", "
+		}
+		description += fmt.Sprintf("a %s signature (%s) at offset %d", signature.Type, signature.Usage, signature.Offset)
+	}
+	return description
+}
+
+// Inspect reads the partition table and the signatures of a block device or an image file
+func Inspect(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Stat doesn't return the size of block devices, seeking does
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the size of %s: %s", path, err)
+	}
+	report, err := InspectReader(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect %s: %s", path, err)
+	}
+	return report, nil
+}
+
+// InspectReader reads the partition table and the signatures of a device of the given size
+func InspectReader(r io.ReaderAt, size int64) (*Report, error) {
+	d := &device{r: r, size: size}
+	report := &Report{Size: size, SectorSize: defaultSectorSize}
+
+	signatures, err := findSignatures(d)
+	if err != nil {
+		return nil, err
+	}
+	report.Signatures = signatures
+
+	gpt, sectorSize, partitions, err := readGPT(d)
+	if err != nil {
+		return nil, err
+	}
+	if gpt {
+		report.PartitionTable = PartitionTableGPT
+		report.SectorSize = sectorSize
+		report.Partitions = partitions
+		return report, nil
+	}
+
+	// FAT and NTFS boot sectors end with the same 0x55AA marker as an MBR
+	if hasBootSectorFilesystem(signatures) {
+		return report, nil
+	}
+	dos, partitions, err := readMBR(d)
+	if err != nil {
+		return nil, err
+	}
+	if dos {
+		report.PartitionTable = PartitionTableDOS
+		report.Partitions = partitions
+	}
+	return report, nil
+}
+
+// device reads a device without going past its end
+type device struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// read returns length bytes at offset, or nil if the device is too small
+func (d *device) read(offset int64, length int) ([]byte, error) {
+	if offset < 0 || offset+int64(length) > d.size {
+		return nil, nil
+	}
+	buf := make([]byte, length)
+	if _, err := d.r.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,246 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testImageSize = 8 << 20
+
+// writeImage creates a sparse image file with the given content at the given offsets
+func writeImage(t *testing.T, chunks map[int64][]byte) string {
+	f, err := ioutil.TempFile("", "cn-blockdev-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(testImageSize); err != nil {
+		t.Fatal(err)
+	}
+	for offset, chunk := range chunks {
+		if _, err := f.WriteAt(chunk, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f.Name()
+}
+
+func inspectImage(t *testing.T, chunks map[int64][]byte) *Report {
+	path := writeImage(t, chunks)
+	defer os.Remove(path)
+	report, err := Inspect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// mbrSector returns a boot sector with the given primary partitions {type, start LBA, sectors}
+func mbrSector(entries ...[3]uint32) []byte {
+	sector := make([]byte, 512)
+	for i, e := range entries {
+		raw := sector[446+16*i:]
+		raw[4] = byte(e[0])
+		binary.LittleEndian.PutUint32(raw[8:], e[1])
+		binary.LittleEndian.PutUint32(raw[12:], e[2])
+	}
+	sector[510], sector[511] = 0x55, 0xAA
+	return sector
+}
+
+// gptImage returns the header and entries of a GPT with the given partitions {first LBA, last LBA}
+func gptImage(names []string, partitions ...[2]uint64) map[int64][]byte {
+	linuxFilesystem := []byte{0xaf, 0x3d, 0xc6, 0x0f, 0x83, 0x84, 0x72, 0x47, 0x8e, 0x79, 0x3d, 0x69, 0xd8, 0x47, 0x7d, 0xe4}
+	entries := make([]byte, 128*128)
+	for i, p := range partitions {
+		entry := entries[128*i:]
+		copy(entry[0:16], linuxFilesystem)
+		binary.LittleEndian.PutUint64(entry[32:], p[0])
+		binary.LittleEndian.PutUint64(entry[40:], p[1])
+		for j, c := range utf16.Encode([]rune(names[i])) {
+			binary.LittleEndian.PutUint16(entry[56+2*j:], c)
+		}
+	}
+
+	header := make([]byte, 512)
+	copy(header, "EFI PART")
+	binary.LittleEndian.PutUint32(header[8:], 0x00010000)
+	binary.LittleEndian.PutUint32(header[12:], 92)
+	binary.LittleEndian.PutUint64(header[72:], 2)
+	binary.LittleEndian.PutUint32(header[80:], 128)
+	binary.LittleEndian.PutUint32(header[84:], 128)
+	binary.LittleEndian.PutUint32(header[88:], crc32.ChecksumIEEE(entries))
+	binary.LittleEndian.PutUint32(header[16:], crc32.ChecksumIEEE(header[:92]))
+
+	return map[int64][]byte{
+		0:    mbrSector([3]uint32{0xee, 1, testImageSize/512 - 1}),
+		512:  header,
+		1024: entries,
+	}
+}
+
+func TestInspectEmpty(t *testing.T) {
+	report := inspectImage(t, nil)
+	assert.True(t, report.IsEmpty())
+	assert.Equal(t, int64(testImageSize), report.Size)
+	assert.Equal(t, "no partition table and no signature", report.String())
+}
+
+func TestInspectSignatures(t *testing.T) {
+	ext4 := make([]byte, 1024)
+	binary.LittleEndian.PutUint16(ext4[0x38:], 0xEF53)
+	binary.LittleEndian.PutUint32(ext4[0x60:], 0x40)
+	ext3 := make([]byte, 1024)
+	binary.LittleEndian.PutUint16(ext3[0x38:], 0xEF53)
+	binary.LittleEndian.PutUint32(ext3[0x5C:], 0x4)
+	lvm := make([]byte, 32)
+	copy(lvm, "LABELONE")
+	copy(lvm[24:], "LVM2 001")
+	fat32 := mbrSector()
+	copy(fat32[82:], "FAT32   ")
+
+	tests := []struct {
+		chunks  map[int64][]byte
+		sigType string
+		usage   string
+	}{
+		{map[int64][]byte{1024: ext4}, "ext4", UsageFilesystem},
+		{map[int64][]byte{1024: ext3}, "ext3", UsageFilesystem},
+		{map[int64][]byte{0: []byte("XFSB")}, "xfs", UsageFilesystem},
+		{map[int64][]byte{0x10040: []byte("_BHRfS_M")}, "btrfs", UsageFilesystem},
+		{map[int64][]byte{512: lvm}, "LVM2_member", UsageRaid},
+		{map[int64][]byte{0: []byte("bluestore block device\n")}, "ceph_bluestore", UsageOther},
+		{map[int64][]byte{4086: []byte("SWAPSPACE2")}, "swap", UsageOther},
+		{map[int64][]byte{0: []byte("LUKS\xba\xbe")}, "crypto_LUKS", UsageCrypto},
+		{map[int64][]byte{4096: {0xfc, 0x4e, 0x2b, 0xa9}}, "linux_raid_member", UsageRaid},
+		{map[int64][]byte{0: fat32}, "vfat", UsageFilesystem},
+	}
+	for _, test := range tests {
+		report := inspectImage(t, test.chunks)
+		assert.False(t, report.IsEmpty(), test.sigType)
+		if assert.Len(t, report.Signatures, 1, test.sigType) {
+			assert.Equal(t, test.sigType, report.Signatures[0].Type)
+			assert.Equal(t, test.usage, report.Signatures[0].Usage)
+		}
+		// A FAT boot sector is not a partition table
+		assert.Equal(t, "", report.PartitionTable, test.sigType)
+	}
+}
+
+func TestInspectGPT(t *testing.T) {
+	report := inspectImage(t, gptImage([]string{"data", "journal"}, [2]uint64{2048, 4095}, [2]uint64{4096, 8191}))
+	assert.Equal(t, PartitionTableGPT, report.PartitionTable)
+	assert.Equal(t, int64(512), report.SectorSize)
+	assert.Empty(t, report.Signatures)
+	if assert.Len(t, report.Partitions, 2) {
+		assert.Equal(t, Partition{Number: 1, Start: 2048 * 512, Size: 2048 * 512, Type: "0fc63daf-8483-4772-8e79-3d69d8477de4", Name: "data"}, report.Partitions[0])
+		assert.Equal(t, 2, report.Partitions[1].Number)
+		assert.Equal(t, "journal", report.Partitions[1].Name)
+	}
+	assert.Equal(t, "a partition table type gpt and 2 partition(s)", report.String())
+
+	// An empty table is still a partition table
+	report = inspectImage(t, gptImage(nil))
+	assert.Equal(t, PartitionTableGPT, report.PartitionTable)
+	assert.Empty(t, report.Partitions)
+	assert.False(t, report.IsEmpty())
+}
+
+func TestInspectCorruptedGPT(t *testing.T) {
+	chunks := gptImage([]string{"data"}, [2]uint64{2048, 4095})
+	chunks[1024][0] ^= 0xff
+	path := writeImage(t, chunks)
+	defer os.Remove(path)
+	_, err := Inspect(path)
+	assert.NotNil(t, err)
+}
+
+func TestInspectMBR(t *testing.T) {
+	// Two primary partitions, an extended one holding two logical partitions
+	ebr1 := mbrSector([3]uint32{0x83, 63, 1000}, [3]uint32{0x05, 2048, 2048})
+	ebr2 := mbrSector([3]uint32{0x82, 63, 500})
+	report := inspectImage(t, map[int64][]byte{
+		0:                   mbrSector([3]uint32{0x83, 2048, 2048}, [3]uint32{0x8e, 4096, 2048}, [3]uint32{0x05, 8192, 4096}),
+		8192 * 512:          ebr1,
+		(8192 + 2048) * 512: ebr2,
+	})
+	assert.Equal(t, PartitionTableDOS, report.PartitionTable)
+	if assert.Len(t, report.Partitions, 5) {
+		assert.Equal(t, Partition{Number: 1, Start: 2048 * 512, Size: 2048 * 512, Type: "0x83"}, report.Partitions[0])
+		assert.Equal(t, "0x8e", report.Partitions[1].Type)
+		assert.Equal(t, "0x05", report.Partitions[2].Type)
+		assert.Equal(t, Partition{Number: 5, Start: (8192 + 63) * 512, Size: 1000 * 512, Type: "0x83"}, report.Partitions[3])
+		assert.Equal(t, Partition{Number: 6, Start: (8192 + 2048 + 63) * 512, Size: 500 * 512, Type: "0x82"}, report.Partitions[4])
+	}
+}
+
+func TestInspectSmallDevice(t *testing.T) {
+	report, err := InspectReader(zeroReader{}, 100)
+	assert.Nil(t, err)
+	assert.True(t, report.IsEmpty())
+}
+
+type zeroReader struct{}
+
+func (zeroReader) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestWipe(t *testing.T) {
+	lvm := make([]byte, 512)
+	copy(lvm, "LABELONE")
+	copy(lvm[24:], "LVM2 001")
+	copy(lvm[32:], "metadata is kept")
+	path := writeImage(t, map[int64][]byte{512: lvm, 0: []byte("bluestore block device\n")})
+	defer os.Remove(path)
+
+	wiped, err := Wipe(path)
+	assert.Nil(t, err)
+	assert.Len(t, wiped, 2)
+
+	report, err := Inspect(path)
+	assert.Nil(t, err)
+	assert.True(t, report.IsEmpty())
+
+	// Only the magics are erased
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "metadata is kept", string(content[512+32:512+48]))
+	assert.Equal(t, byte('\n'), content[22])
+
+	// Nothing left to wipe
+	wiped, err = Wipe(path)
+	assert.Nil(t, err)
+	assert.Empty(t, wiped)
+}
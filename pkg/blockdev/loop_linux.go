@@ -0,0 +1,135 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// loopControl is the device allocating loop devices
+const loopControl = "/dev/loop-control"
+
+// AttachLoop attaches a file to the first free loop device and returns the device path
+func AttachLoop(file string) (string, error) {
+	ctl, err := os.OpenFile(loopControl, os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("unable to open %s: %s", loopControl, err)
+	}
+	defer ctl.Close()
+
+	// Another process can grab the free device between the two calls, let's retry a few times
+	for i := 0; i < 10; i++ {
+		index, err := unix.IoctlRetInt(int(ctl.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return "", fmt.Errorf("unable to find a free loop device: %s", err)
+		}
+		device := fmt.Sprintf("/dev/loop%d", index)
+		err = AttachLoopAt(device, file)
+		if err == nil {
+			return device, nil
+		}
+		if err != unix.EBUSY {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("unable to find a free loop device for %s", file)
+}
+
+// AttachLoopAt attaches a file to a given loop device
+// unix.EBUSY is returned if the device is already in use
+func AttachLoopAt(device string, file string) error {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	loop, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer loop.Close()
+
+	if err := unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_SET_FD, int(f.Fd())); err != nil {
+		if err == unix.EBUSY {
+			return err
+		}
+		return fmt.Errorf("unable to attach %s to %s: %s", file, device, err)
+	}
+
+	// The backing file name is only informative, tools like losetup show it
+	info := unix.LoopInfo64{}
+	copy(info.File_name[:len(info.File_name)-1], file)
+	if err := unix.IoctlLoopSetStatus64(int(loop.Fd()), &info); err != nil {
+		unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0)
+		return fmt.Errorf("unable to configure %s: %s", device, err)
+	}
+	return nil
+}
+
+// IsLoopBackedBy reports if a loop device is attached to a given file
+func IsLoopBackedBy(device string, file string) (bool, error) {
+	loop, err := os.Open(device)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer loop.Close()
+
+	info, err := unix.IoctlLoopGetStatus64(int(loop.Fd()))
+	if err == unix.ENXIO {
+		// Nothing is attached
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to get the status of %s: %s", device, err)
+	}
+
+	finfo, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	stat := finfo.Sys().(*syscall.Stat_t)
+	return info.Inode == uint64(stat.Ino) && info.Device == uint64(stat.Dev), nil
+}
+
+// DetachLoop detaches the file of a loop device
+// The kernel defers the detach until the last user of the device closes it
+func DetachLoop(device string) error {
+	loop, err := os.Open(device)
+	if err != nil {
+		return err
+	}
+	defer loop.Close()
+
+	if err := unix.IoctlSetInt(int(loop.Fd()), unix.LOOP_CLR_FD, 0); err != nil && err != unix.ENXIO {
+		return fmt.Errorf("unable to detach %s: %s", device, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"errors"
+)
+
+// errLoopUnsupported is returned on operating systems without Linux loop devices
+var errLoopUnsupported = errors.New("loop devices are only supported on Linux")
+
+// AttachLoop attaches a file to the first free loop device and returns the device path
+func AttachLoop(file string) (string, error) {
+	return "", errLoopUnsupported
+}
+
+// AttachLoopAt attaches a file to a given loop device
+func AttachLoopAt(device string, file string) error {
+	return errLoopUnsupported
+}
+
+// IsLoopBackedBy reports if a loop device is attached to a given file
+func IsLoopBackedBy(device string, file string) (bool, error) {
+	return false, errLoopUnsupported
+}
+
+// DetachLoop detaches the file of a loop device
+func DetachLoop(device string) error {
+	return errLoopUnsupported
+}
@@ -0,0 +1,223 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"unicode/utf16"
+)
+
+const (
+	// defaultSectorSize is the logical sector size MBR partition tables are expressed in
+	defaultSectorSize = 512
+
+	// maxLogicalPartitions stops walking a looping chain of extended boot records
+	maxLogicalPartitions = 128
+)
+
+// gptSectorSizes are the logical sector sizes a GPT header is looked for with
+var gptSectorSizes = []int64{512, 4096}
+
+// readGPT reads a GUID partition table, it reports false if there is no valid one
+func readGPT(d *device) (bool, int64, []Partition, error) {
+	for _, sectorSize := range gptSectorSizes {
+		header, err := d.read(sectorSize, int(sectorSize))
+		if err != nil {
+			return false, 0, nil, err
+		}
+		if header == nil || !bytes.Equal(header[0:8], []byte("EFI PART")) {
+			continue
+		}
+		headerSize := binary.LittleEndian.Uint32(header[12:])
+		if headerSize < 92 || int64(headerSize) > sectorSize {
+			continue
+		}
+		// The checksum is computed with its own field zeroed
+		headerCRC := binary.LittleEndian.Uint32(header[16:])
+		check := make([]byte, headerSize)
+		copy(check, header[:headerSize])
+		binary.LittleEndian.PutUint32(check[16:], 0)
+		if crc32.ChecksumIEEE(check) != headerCRC {
+			continue
+		}
+
+		entriesLBA := int64(binary.LittleEndian.Uint64(header[72:]))
+		entriesCount := binary.LittleEndian.Uint32(header[80:])
+		entrySize := binary.LittleEndian.Uint32(header[84:])
+		entriesCRC := binary.LittleEndian.Uint32(header[88:])
+		if entrySize < 128 || entrySize > 1024 || entriesCount > 4096 {
+			return false, 0, nil, fmt.Errorf("invalid GPT header: %d entries of %d bytes", entriesCount, entrySize)
+		}
+		entries, err := d.read(entriesLBA*sectorSize, int(entriesCount*entrySize))
+		if err != nil {
+			return false, 0, nil, err
+		}
+		if entries == nil || crc32.ChecksumIEEE(entries) != entriesCRC {
+			return false, 0, nil, fmt.Errorf("the GPT partition entries are corrupted")
+		}
+
+		var partitions []Partition
+		for i := uint32(0); i < entriesCount; i++ {
+			entry := entries[i*entrySize : (i+1)*entrySize]
+			typeGUID := entry[0:16]
+			if bytes.Equal(typeGUID, make([]byte, 16)) {
+				// Unused entry
+				continue
+			}
+			firstLBA := int64(binary.LittleEndian.Uint64(entry[32:]))
+			lastLBA := int64(binary.LittleEndian.Uint64(entry[40:]))
+			partitions = append(partitions, Partition{
+				Number: int(i) + 1,
+				Start:  firstLBA * sectorSize,
+				Size:   (lastLBA - firstLBA + 1) * sectorSize,
+				Type:   formatGUID(typeGUID),
+				Name:   decodeUTF16(entry[56:128]),
+			})
+		}
+		return true, sectorSize, partitions, nil
+	}
+	return false, 0, nil, nil
+}
+
+// readMBR reads an MS-DOS partition table including the logical partitions
+func readMBR(d *device) (bool, []Partition, error) {
+	sector, err := d.read(0, defaultSectorSize)
+	if err != nil || sector == nil {
+		return false, nil, err
+	}
+	if sector[510] != 0x55 || sector[511] != 0xAA {
+		return false, nil, nil
+	}
+	entries := parseMBREntries(sector)
+	// The boot indicator is either 0x00 or 0x80, anything else is not a partition table
+	for _, e := range entries {
+		if e.status != 0x00 && e.status != 0x80 {
+			return false, nil, nil
+		}
+	}
+
+	var partitions []Partition
+	for i, e := range entries {
+		if e.partType == 0 || e.sectors == 0 {
+			continue
+		}
+		partitions = append(partitions, Partition{
+			Number: i + 1,
+			Start:  int64(e.startLBA) * defaultSectorSize,
+			Size:   int64(e.sectors) * defaultSectorSize,
+			Type:   fmt.Sprintf("0x%02x", e.partType),
+		})
+		if isExtendedPartition(e.partType) {
+			logicals, err := readLogicalPartitions(d, int64(e.startLBA))
+			if err != nil {
+				return false, nil, err
+			}
+			partitions = append(partitions, logicals...)
+		}
+	}
+	return true, partitions, nil
+}
+
+// readLogicalPartitions walks the chain of extended boot records of an extended partition
+// Logical partitions are numbered from 5 like the kernel does
+func readLogicalPartitions(d *device, extendedStart int64) ([]Partition, error) {
+	var partitions []Partition
+	ebrLBA := extendedStart
+	for number := 5; number < 5+maxLogicalPartitions; number++ {
+		sector, err := d.read(ebrLBA*defaultSectorSize, defaultSectorSize)
+		if err != nil {
+			return nil, err
+		}
+		if sector == nil || sector[510] != 0x55 || sector[511] != 0xAA {
+			break
+		}
+		entries := parseMBREntries(sector)
+		// The first entry is the logical partition, relative to its EBR
+		if entries[0].partType != 0 && entries[0].sectors != 0 {
+			partitions = append(partitions, Partition{
+				Number: number,
+				Start:  (ebrLBA + int64(entries[0].startLBA)) * defaultSectorSize,
+				Size:   int64(entries[0].sectors) * defaultSectorSize,
+				Type:   fmt.Sprintf("0x%02x", entries[0].partType),
+			})
+		}
+		// The second entry links to the next EBR, relative to the extended partition
+		if !isExtendedPartition(entries[1].partType) || entries[1].startLBA == 0 {
+			break
+		}
+		ebrLBA = extendedStart + int64(entries[1].startLBA)
+	}
+	return partitions, nil
+}
+
+// mbrEntry is a primary partition entry of an MBR or an EBR
+type mbrEntry struct {
+	status   byte
+	partType byte
+	startLBA uint32
+	sectors  uint32
+}
+
+func parseMBREntries(sector []byte) [4]mbrEntry {
+	var entries [4]mbrEntry
+	for i := range entries {
+		raw := sector[446+16*i : 446+16*(i+1)]
+		entries[i] = mbrEntry{
+			status:   raw[0],
+			partType: raw[4],
+			startLBA: binary.LittleEndian.Uint32(raw[8:]),
+			sectors:  binary.LittleEndian.Uint32(raw[12:]),
+		}
+	}
+	return entries
+}
+
+func isExtendedPartition(partType byte) bool {
+	return partType == 0x05 || partType == 0x0f || partType == 0x85
+}
+
+// formatGUID returns the canonical representation of a mixed-endian GUID
+func formatGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10],
+		b[10:16])
+}
+
+// decodeUTF16 decodes a NUL terminated UTF-16LE string
+func decodeUTF16(b []byte) string {
+	var u []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
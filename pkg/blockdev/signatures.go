@@ -0,0 +1,165 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// magic is a sequence of bytes identifying a signature at a given offset
+type magic struct {
+	sigType string
+	usage   string
+	offset  int64
+	value   []byte
+}
+
+// magics are the simple signatures, more complex ones have their own probe function
+var magics = []magic{
+	{"xfs", UsageFilesystem, 0, []byte("XFSB")},
+	{"btrfs", UsageFilesystem, 0x10040, []byte("_BHRfS_M")},
+	{"ceph_bluestore", UsageOther, 0, []byte("bluestore block device")},
+	{"crypto_LUKS", UsageCrypto, 0, []byte("LUKS\xba\xbe")},
+	{"ntfs", UsageFilesystem, 3, []byte("NTFS    ")},
+	{"vfat", UsageFilesystem, 54, []byte("FAT12   ")},
+	{"vfat", UsageFilesystem, 54, []byte("FAT16   ")},
+	{"vfat", UsageFilesystem, 82, []byte("FAT32   ")},
+	{"iso9660", UsageFilesystem, 0x8001, []byte("CD001")},
+}
+
+// probes are functions looking for signatures that can't be described by a single magic
+var probes = []func(d *device) (*Signature, error){
+	probeExt,
+	probeLVM,
+	probeSwap,
+	probeLinuxRaid,
+}
+
+// findSignatures returns all the signatures found on a device
+func findSignatures(d *device) ([]Signature, error) {
+	var signatures []Signature
+	for _, m := range magics {
+		buf, err := d.read(m.offset, len(m.value))
+		if err != nil {
+			return nil, err
+		}
+		if buf != nil && bytes.Equal(buf, m.value) {
+			signatures = append(signatures, Signature{Type: m.sigType, Usage: m.usage, Offset: m.offset, Length: len(m.value)})
+		}
+	}
+	for _, probe := range probes {
+		signature, err := probe(d)
+		if err != nil {
+			return nil, err
+		}
+		if signature != nil {
+			signatures = append(signatures, *signature)
+		}
+	}
+	return signatures, nil
+}
+
+// hasBootSectorFilesystem reports if a filesystem lives in the first sector, where an MBR would be
+func hasBootSectorFilesystem(signatures []Signature) bool {
+	for _, signature := range signatures {
+		if signature.Type == "vfat" || signature.Type == "ntfs" {
+			return true
+		}
+	}
+	return false
+}
+
+// probeExt looks for an ext2, ext3 or ext4 superblock
+func probeExt(d *device) (*Signature, error) {
+	const superblockOffset = 1024
+	sb, err := d.read(superblockOffset, 1024)
+	if err != nil || sb == nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint16(sb[0x38:]) != 0xEF53 {
+		return nil, nil
+	}
+
+	const (
+		compatHasJournal = 0x4
+		incompatExtents  = 0x40
+		incompat64bit    = 0x80
+		incompatFlexBG   = 0x200
+	)
+	compat := binary.LittleEndian.Uint32(sb[0x5C:])
+	incompat := binary.LittleEndian.Uint32(sb[0x60:])
+	sigType := "ext2"
+	if incompat&(incompatExtents|incompat64bit|incompatFlexBG) != 0 {
+		sigType = "ext4"
+	} else if compat&compatHasJournal != 0 {
+		sigType = "ext3"
+	}
+	return &Signature{Type: sigType, Usage: UsageFilesystem, Offset: superblockOffset + 0x38, Length: 2}, nil
+}
+
+// probeLVM looks for an LVM2 physical volume label in the first 4 sectors
+func probeLVM(d *device) (*Signature, error) {
+	for sector := int64(0); sector < 4; sector++ {
+		offset := sector * 512
+		label, err := d.read(offset, 32)
+		if err != nil || label == nil {
+			return nil, err
+		}
+		if bytes.Equal(label[0:8], []byte("LABELONE")) && bytes.Equal(label[24:32], []byte("LVM2 001")) {
+			// Both magics belong to the label header, wiping covers the whole header
+			return &Signature{Type: "LVM2_member", Usage: UsageRaid, Offset: offset, Length: 32}, nil
+		}
+	}
+	return nil, nil
+}
+
+// probeSwap looks for a swap signature at the end of the first page, for the common page sizes
+func probeSwap(d *device) (*Signature, error) {
+	for _, pageSize := range []int64{4096, 8192, 16384, 65536} {
+		offset := pageSize - 10
+		buf, err := d.read(offset, 10)
+		if err != nil || buf == nil {
+			return nil, err
+		}
+		if bytes.Equal(buf, []byte("SWAPSPACE2")) || bytes.Equal(buf, []byte("SWAP-SPACE")) {
+			return &Signature{Type: "swap", Usage: UsageOther, Offset: offset, Length: 10}, nil
+		}
+	}
+	return nil, nil
+}
+
+// probeLinuxRaid looks for an md superblock version 1.1 (start of the device) or 1.2 (4KiB from the start)
+func probeLinuxRaid(d *device) (*Signature, error) {
+	const mdMagic = 0xa92b4efc
+	for _, offset := range []int64{0, 4096} {
+		buf, err := d.read(offset, 4)
+		if err != nil || buf == nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(buf) == mdMagic {
+			return &Signature{Type: "linux_raid_member", Usage: UsageRaid, Offset: offset, Length: 4}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,94 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// sysfsDevBlock exposes the block devices by major:minor
+const sysfsDevBlock = "/sys/dev/block"
+
+// sysfsDir returns the sysfs directory of a block device, symlinks like /dev/disk/by-id/* are followed
+func sysfsDir(path string) (string, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		return "", fmt.Errorf("unable to stat %s: %s", path, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return "", fmt.Errorf("%s is not a block device", path)
+	}
+	rdev := uint64(stat.Rdev)
+	return filepath.Join(sysfsDevBlock, fmt.Sprintf("%d:%d", unix.Major(rdev), unix.Minor(rdev))), nil
+}
+
+// IsPartition reports if a block device is a partition of a disk, e.g: /dev/sdb3
+func IsPartition(path string) (bool, error) {
+	dir, err := sysfsDir(path)
+	if err != nil {
+		return false, err
+	}
+	return isPartitionDir(dir)
+}
+
+// Holders returns the devices built on top of a block device, e.g: the device mapper of an LVM logical volume
+func Holders(path string) ([]string, error) {
+	dir, err := sysfsDir(path)
+	if err != nil {
+		return nil, err
+	}
+	return holdersOf(dir)
+}
+
+// isPartitionDir reports if a sysfs block device directory is a partition, only partitions have a partition file
+func isPartitionDir(dir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(dir, "partition"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// holdersOf lists the holders of a sysfs block device directory
+func holdersOf(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "holders"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var holders []string
+	for _, entry := range entries {
+		holders = append(holders, entry.Name())
+	}
+	return holders, nil
+}
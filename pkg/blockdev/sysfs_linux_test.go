@@ -0,0 +1,64 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSysfs(t *testing.T) {
+	sysfs, err := ioutil.TempDir("", "cn-sysfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sysfs)
+
+	// 8:16 is a whole disk, 8:19 its third partition used by an LVM logical volume
+	disk := filepath.Join(sysfs, "8:16")
+	partition := filepath.Join(sysfs, "8:19")
+	assert.Nil(t, os.MkdirAll(filepath.Join(disk, "holders"), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(partition, "holders", "dm-0"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(partition, "partition"), []byte("3\n"), 0644))
+
+	isPartition, err := isPartitionDir(disk)
+	assert.Nil(t, err)
+	assert.False(t, isPartition)
+	isPartition, err = isPartitionDir(partition)
+	assert.Nil(t, err)
+	assert.True(t, isPartition)
+
+	holders, err := holdersOf(disk)
+	assert.Nil(t, err)
+	assert.Empty(t, holders)
+	holders, err = holdersOf(partition)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dm-0"}, holders)
+
+	_, err = IsPartition(sysfs)
+	assert.NotNil(t, err)
+}
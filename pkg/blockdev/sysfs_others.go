@@ -0,0 +1,43 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"errors"
+)
+
+// errSysfsUnsupported is returned on operating systems without the Linux sysfs
+var errSysfsUnsupported = errors.New("block devices are only inspected through sysfs on Linux")
+
+// IsPartition reports if a block device is a partition of a disk, e.g: /dev/sdb3
+func IsPartition(path string) (bool, error) {
+	return false, errSysfsUnsupported
+}
+
+// Holders returns the devices built on top of a block device, e.g: the device mapper of an LVM logical volume
+func Holders(path string) ([]string, error) {
+	return nil, errSysfsUnsupported
+}
@@ -0,0 +1,65 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package blockdev
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Wipe erases the signatures of a block device or an image file and returns what was erased
+// Like wipefs, only the magics are zeroed, the rest of the data and the partition table are left untouched
+func Wipe(path string) ([]Signature, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the size of %s: %s", path, err)
+	}
+	report, err := InspectReader(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("unable to inspect %s: %s", path, err)
+	}
+	if err := wipeSignatures(f, report.Signatures); err != nil {
+		return nil, fmt.Errorf("unable to wipe %s: %s", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	return report.Signatures, nil
+}
+
+// wipeSignatures zeroes the magic of each signature
+func wipeSignatures(w io.WriterAt, signatures []Signature) error {
+	for _, signature := range signatures {
+		if _, err := w.WriteAt(make([]byte, signature.Length), signature.Offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// dockerHubAuthKey is how 'docker login' names the Docker Hub in its configuration file
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// challengeParamRegexp matches the parameters of a WWW-Authenticate header, e.g: realm="https://auth.docker.io/token"
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Credentials log in a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// dockerConfig is the part of ~/.docker/config.json holding the credentials
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+}
+
+// DockerConfigPath returns the configuration file where 'docker login' stores the credentials
+func DockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); len(dir) > 0 {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// LoadCredentials returns the credentials of a registry stored in a docker configuration file
+// A missing file or registry means anonymous access, credential helpers are not supported
+func LoadCredentials(path string, registry string) (*Credentials, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || len(path) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err)
+	}
+	for key, auth := range config.Auths {
+		if authKeyRegistry(key) != registry || len(auth.Auth) == 0 {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the credentials of %s in %s: %s", key, path, err)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid credentials for %s in %s", key, path)
+		}
+		return &Credentials{Username: parts[0], Password: parts[1]}, nil
+	}
+	return nil, nil
+}
+
+// authKeyRegistry returns the registry host of a key of the auths section, e.g: https://quay.io/v1/ is quay.io
+func authKeyRegistry(key string) string {
+	if key == dockerHubAuthKey {
+		return DefaultRegistry
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	if host == "index.docker.io" || host == dockerHubAPI {
+		return DefaultRegistry
+	}
+	return host
+}
+
+// challenge is a parsed WWW-Authenticate header
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g: Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) challenge {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	c := challenge{scheme: strings.ToLower(parts[0]), params: map[string]string{}}
+	if len(parts) == 2 {
+		for _, match := range challengeParamRegexp.FindAllStringSubmatch(parts[1], -1) {
+			c.params[strings.ToLower(match[1])] = match[2]
+		}
+	}
+	return c
+}
+
+// authorize answers the challenge of a registry and returns the Authorization header to send
+func (c *Client) authorize(header string, repository string) (string, error) {
+	ch := parseChallenge(header)
+	switch ch.scheme {
+	case "basic":
+		if c.Credentials == nil {
+			return "", errors.New("the registry requires a login, run 'docker login' first")
+		}
+		return "Basic " + basicAuth(c.Credentials), nil
+	case "bearer":
+		token, err := c.fetchToken(ch, repository)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	}
+	return "", fmt.Errorf("unsupported authentication scheme %q", ch.scheme)
+}
+
+// fetchToken asks the token server of a registry for a pull token
+func (c *Client) fetchToken(ch challenge, repository string) (string, error) {
+	realm, err := url.Parse(ch.params["realm"])
+	if err != nil || len(realm.Host) == 0 {
+		return "", fmt.Errorf("invalid token server %q", ch.params["realm"])
+	}
+	query := realm.Query()
+	if service, ok := ch.params["service"]; ok {
+		query.Set("service", service)
+	}
+	scope := ch.params["scope"]
+	if len(scope) == 0 {
+		scope = "repository:" + repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.Credentials != nil {
+		req.Header.Set("Authorization", "Basic "+basicAuth(c.Credentials))
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to get a token from %s: %s", realm.Host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("unable to decode the token from %s: %s", realm.Host, err)
+	}
+	if len(token.Token) > 0 {
+		return token.Token, nil
+	}
+	if len(token.AccessToken) > 0 {
+		return token.AccessToken, nil
+	}
+	return "", fmt.Errorf("%s returned an empty token", realm.Host)
+}
+
+// basicAuth encodes credentials for a Basic Authorization header
+func basicAuth(credentials *Credentials) string {
+	return base64.StdEncoding.EncodeToString([]byte(credentials.Username + ":" + credentials.Password))
+}
@@ -0,0 +1,100 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+// Package registry talks to container registries implementing the Docker Registry HTTP API V2.
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultRegistry is the registry of images without a registry host, e.g: ceph/daemon
+const DefaultRegistry = "docker.io"
+
+// repositoryRegexp matches the path components of a repository, e.g: ceph/daemon
+var repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+// Reference is a parsed image name, e.g: quay.io/ceph/daemon:latest-mimic
+type Reference struct {
+	Registry   string // Registry is the host of the registry, e.g: docker.io, quay.io, localhost:5000
+	Repository string // Repository is the path of the image in the registry, e.g: ceph/daemon, library/busybox
+	Tag        string // Tag is empty if the image name had none
+	Digest     string // Digest is set if the image name was pinned, e.g: sha256:...
+}
+
+// ParseReference parses an image name the way docker does
+func ParseReference(image string) (Reference, error) {
+	ref := Reference{Registry: DefaultRegistry}
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+	}
+	// A colon after the last slash separates the tag, a colon before belongs to the registry port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+	if i := strings.Index(name, "/"); i >= 0 && isRegistryHost(name[:i]) {
+		ref.Registry = name[:i]
+		name = name[i+1:]
+	}
+	if ref.Registry == "index.docker.io" || ref.Registry == dockerHubAPI {
+		ref.Registry = DefaultRegistry
+	}
+	// Official images of the Docker Hub live in the library namespace
+	if ref.Registry == DefaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if !repositoryRegexp.MatchString(name) {
+		return Reference{}, fmt.Errorf("invalid image name %q", image)
+	}
+	ref.Repository = name
+	return ref, nil
+}
+
+// isRegistryHost reports if the first component of an image name is a registry host
+func isRegistryHost(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// Name returns the image name without tag nor digest, as docker shows it, e.g: ceph/daemon, quay.io/ceph/daemon
+func (r Reference) Name() string {
+	if r.Registry != DefaultRegistry {
+		return r.Registry + "/" + r.Repository
+	}
+	return strings.TrimPrefix(r.Repository, "library/")
+}
+
+// String returns the image name with its tag and digest, if any
+func (r Reference) String() string {
+	name := r.Name()
+	if len(r.Tag) > 0 {
+		name += ":" + r.Tag
+	}
+	if len(r.Digest) > 0 {
+		name += "@" + r.Digest
+	}
+	return name
+}
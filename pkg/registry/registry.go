@@ -0,0 +1,328 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// dockerHubAPI is the host serving the registry API of the Docker Hub
+	dockerHubAPI = "registry-1.docker.io"
+
+	// tagsPageSize is the number of tags asked per page, registries may return less
+	tagsPageSize = 100
+
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// manifestMediaTypes are the manifests cn accepts, the manifest lists first
+var manifestMediaTypes = []string{mediaTypeManifestList, mediaTypeManifest, mediaTypeOCIIndex, mediaTypeOCIManifest}
+
+// linkNextRegexp matches the next page of a Link header, e.g: </v2/ceph/daemon/tags/list?last=v3&n=100>; rel="next"
+var linkNextRegexp = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// Client queries the Registry HTTP API V2 of a registry
+type Client struct {
+	BaseURL      string       // BaseURL is the root of the API, e.g: https://quay.io
+	HTTPClient   *http.Client // HTTPClient sends the requests
+	Credentials  *Credentials // Credentials are nil for an anonymous access
+	OS           string       // OS is the platform inspected in a multi-arch manifest list, linux for the Ceph images
+	Architecture string       // Architecture is the platform inspected in a multi-arch manifest list, e.g: amd64
+
+	mu             sync.Mutex
+	authorizations map[string]string // authorizations are the Authorization headers by repository
+}
+
+// Image describes a tag of a repository
+type Image struct {
+	Tag    string
+	Digest string            // Digest is the digest of the manifest (or manifest list) of the tag
+	Size   int64             // Size is the compressed size of the layers and the config, in bytes
+	Labels map[string]string // Labels are the labels of the image config, e.g: RELEASE
+}
+
+// descriptor points to a manifest or a blob
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+	Platform  struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform"`
+}
+
+// manifest is an image manifest or a manifest list
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// NewClient returns a client of a registry host using the credentials of 'docker login'
+func NewClient(registry string) (*Client, error) {
+	credentials, err := LoadCredentials(DockerConfigPath(), registry)
+	if err != nil {
+		return nil, err
+	}
+	host := registry
+	if registry == DefaultRegistry {
+		host = dockerHubAPI
+	}
+	return &Client{
+		BaseURL:      "https://" + host,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		Credentials:  credentials,
+		OS:           "linux",
+		Architecture: runtime.GOARCH,
+	}, nil
+}
+
+// Tags returns all the tags of a repository, following the pagination of the registry
+func (c *Client) Tags(repository string) ([]string, error) {
+	var tags []string
+	next := c.BaseURL + "/v2/" + repository + "/tags/list?n=" + strconv.Itoa(tagsPageSize)
+	for len(next) > 0 {
+		resp, err := c.get(repository, next)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the tags of %s: %s", repository, err)
+		}
+		tags = append(tags, page.Tags...)
+		if next, err = nextPage(resp.Request.URL, resp.Header.Get("Link")); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextPage returns the absolute URL of the next page of a Link header, or an empty string on the last page
+func nextPage(current *url.URL, link string) (string, error) {
+	match := linkNextRegexp.FindStringSubmatch(link)
+	if match == nil {
+		return "", nil
+	}
+	next, err := current.Parse(match[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid Link header %q: %s", link, err)
+	}
+	return next.String(), nil
+}
+
+// Inspect returns the digest, the size and the labels of a tag
+// The image of the OS and Architecture of the client is inspected when the tag is a multi-arch manifest list
+func (c *Client) Inspect(repository string, tag string) (*Image, error) {
+	m, digest, err := c.getManifest(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{Tag: tag, Digest: digest}
+
+	if len(m.Manifests) > 0 {
+		platform, err := selectPlatform(m.Manifests, c.OS, c.Architecture)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: %s", repository, tag, err)
+		}
+		if m, _, err = c.getManifest(repository, platform.Digest); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Config.Digest) == 0 {
+		return nil, fmt.Errorf("%s:%s: unsupported manifest type %q", repository, tag, m.MediaType)
+	}
+
+	image.Size = m.Config.Size
+	for _, layer := range m.Layers {
+		image.Size += layer.Size
+	}
+	if image.Labels, err = c.getLabels(repository, m.Config.Digest); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// Digest returns the digest of a tag, what docker pulls by digest
+// A HEAD request is enough when the registry returns the digest, it doesn't count as a pull on the Docker Hub
+func (c *Client) Digest(repository string, tag string) (string, error) {
+	resp, err := c.do("HEAD", repository, c.manifestURL(repository, tag), manifestMediaTypes...)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if digest := resp.Header.Get("Docker-Content-Digest"); len(digest) > 0 {
+		return digest, nil
+	}
+	_, digest, err := c.getManifest(repository, tag)
+	return digest, err
+}
+
+// selectPlatform returns the manifest of a platform from a manifest list
+func selectPlatform(manifests []descriptor, os string, architecture string) (descriptor, error) {
+	for _, m := range manifests {
+		if m.Platform.OS == os && m.Platform.Architecture == architecture {
+			return m, nil
+		}
+	}
+	return descriptor{}, fmt.Errorf("no image for %s/%s", os, architecture)
+}
+
+// getManifest returns a manifest and its digest
+func (c *Client) getManifest(repository string, reference string) (*manifest, string, error) {
+	resp, err := c.get(repository, c.manifestURL(repository, reference), manifestMediaTypes...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, "", fmt.Errorf("unable to decode the manifest of %s:%s: %s", repository, reference, err)
+	}
+	// Registries should return the digest, it's the hash of the manifest otherwise
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if len(digest) == 0 {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	}
+	return m, digest, nil
+}
+
+// manifestURL returns the URL of the manifest of a tag or a digest
+func (c *Client) manifestURL(repository string, reference string) string {
+	return c.BaseURL + "/v2/" + repository + "/manifests/" + reference
+}
+
+// getLabels returns the labels of an image config
+func (c *Client) getLabels(repository string, digest string) (map[string]string, error) {
+	resp, err := c.get(repository, c.BaseURL+"/v2/"+repository+"/blobs/"+digest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("unable to decode the config %s of %s: %s", digest, repository, err)
+	}
+	return config.Config.Labels, nil
+}
+
+// get sends a GET request to the registry, answering its authentication challenge if needed
+// The caller must close the body of the response
+func (c *Client) get(repository string, url string, accept ...string) (*http.Response, error) {
+	return c.do("GET", repository, url, accept...)
+}
+
+// do sends a request to the registry, answering its authentication challenge if needed
+// The caller must close the body of the response
+func (c *Client) do(method string, repository string, url string, accept ...string) (*http.Response, error) {
+	c.mu.Lock()
+	authorization := c.authorizations[repository]
+	c.mu.Unlock()
+
+	resp, err := c.send(method, url, authorization, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		header := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if authorization, err = c.authorize(header, repository); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if c.authorizations == nil {
+			c.authorizations = map[string]string{}
+		}
+		c.authorizations[repository] = authorization
+		c.mu.Unlock()
+		if resp, err = c.send(method, url, authorization, accept); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s%s", url, resp.Status, registryErrors(resp.Body))
+	}
+	return resp, nil
+}
+
+// send sends a request with the given Authorization and Accept headers
+func (c *Client) send(method string, url string, authorization string, accept []string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	for _, mediaType := range accept {
+		req.Header.Add("Accept", mediaType)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// registryErrors returns the messages of an error response of the registry, e.g: ", manifest unknown"
+func registryErrors(body io.Reader) string {
+	var response struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return ""
+	}
+	messages := ""
+	for _, e := range response.Errors {
+		messages += ", " + e.Message
+	}
+	return messages
+}
@@ -0,0 +1,201 @@
+/*
+ * Ceph Nano (C) 2019 Red Hat, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+/*
+ * Below main package has canonical imports for 'go get' and 'go build'
+ * to work with all other clones of github.com/ceph/cn repository. For
+ * more information refer https://golang.org/doc/go1.4#canonicalimports
+ */
+
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// heads counts the HEAD requests of the manifest of latest-mimic
+var heads int
+
+// newTestRegistry returns a registry stand-in serving ceph/daemon behind a token server
+func newTestRegistry(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "nano" || password != "secret" || r.URL.Query().Get("scope") != "repository:ceph/daemon:pull" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "pull-token"})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:ceph/daemon:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/ceph/daemon/tags/list":
+			if r.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</v2/ceph/daemon/tags/list?last=latest-mimic&n=100>; rel="next"`)
+				w.Write([]byte(`{"name":"ceph/daemon","tags":["latest-luminous","latest-mimic"]}`))
+				return
+			}
+			w.Write([]byte(`{"name":"ceph/daemon","tags":["latest-nautilus"]}`))
+		case "/v2/ceph/daemon/manifests/latest-mimic":
+			assert.Contains(t, r.Header["Accept"], mediaTypeManifestList)
+			w.Header().Set("Docker-Content-Digest", "sha256:list")
+			if r.Method == "HEAD" {
+				heads++
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"mediaType": mediaTypeManifestList,
+				"manifests": []map[string]interface{}{
+					{"digest": "sha256:other", "platform": map[string]string{"os": "plan9", "architecture": "mips"}},
+					{"digest": "sha256:native", "platform": map[string]string{"os": "linux", "architecture": "arm64"}},
+				},
+			})
+		case "/v2/ceph/daemon/manifests/sha256:native":
+			w.Write([]byte(`{"mediaType":"` + mediaTypeManifest + `","config":{"digest":"sha256:config","size":100},"layers":[{"size":1000},{"size":2000}]}`))
+		case "/v2/ceph/daemon/blobs/sha256:config":
+			w.Write([]byte(`{"config":{"Labels":{"RELEASE":"mimic"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+		}
+	})
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	server := newTestRegistry(t)
+	defer server.Close()
+	client := &Client{BaseURL: server.URL, HTTPClient: server.Client(), Credentials: &Credentials{Username: "nano", Password: "secret"},
+		OS: "linux", Architecture: "arm64"}
+
+	tags, err := client.Tags("ceph/daemon")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"latest-luminous", "latest-mimic", "latest-nautilus"}, tags)
+
+	image, err := client.Inspect("ceph/daemon", "latest-mimic")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "sha256:list", image.Digest)
+		assert.Equal(t, int64(3100), image.Size)
+		assert.Equal(t, "mimic", image.Labels["RELEASE"])
+	}
+
+	// The Ceph images are linux images whatever the OS of the client
+	other := &Client{BaseURL: server.URL, HTTPClient: server.Client(), Credentials: client.Credentials, OS: "darwin", Architecture: "arm64"}
+	_, err = other.Inspect("ceph/daemon", "latest-mimic")
+	assert.NotNil(t, err)
+
+	digest, err := client.Digest("ceph/daemon", "latest-mimic")
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:list", digest)
+	assert.Equal(t, 1, heads)
+
+	_, err = client.Digest("ceph/daemon", "latest-octopus")
+	if assert.NotNil(t, err) {
+		assert.True(t, strings.HasSuffix(err.Error(), "404 Not Found"), err.Error())
+	}
+
+	_, err = client.Inspect("ceph/daemon", "latest-octopus")
+	if assert.NotNil(t, err) {
+		assert.True(t, strings.HasSuffix(err.Error(), "404 Not Found, manifest unknown"), err.Error())
+	}
+
+	// No token without the right credentials
+	anonymous := &Client{BaseURL: server.URL, HTTPClient: server.Client()}
+	_, err = anonymous.Tags("ceph/daemon")
+	assert.NotNil(t, err)
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image string
+		ref   Reference
+		name  string
+	}{
+		{"ceph/daemon", Reference{Registry: "docker.io", Repository: "ceph/daemon"}, "ceph/daemon"},
+		{"ceph/daemon:latest-mimic", Reference{Registry: "docker.io", Repository: "ceph/daemon", Tag: "latest-mimic"}, "ceph/daemon"},
+		{"busybox", Reference{Registry: "docker.io", Repository: "library/busybox"}, "busybox"},
+		{"docker.io/ceph/daemon", Reference{Registry: "docker.io", Repository: "ceph/daemon"}, "ceph/daemon"},
+		{"localhost:5000/nano:v1@sha256:abc", Reference{Registry: "localhost:5000", Repository: "nano", Tag: "v1", Digest: "sha256:abc"}, "localhost:5000/nano"},
+		{"registry.access.redhat.com/rhceph/rhceph-3-rhel7", Reference{Registry: "registry.access.redhat.com", Repository: "rhceph/rhceph-3-rhel7"}, "registry.access.redhat.com/rhceph/rhceph-3-rhel7"},
+	}
+	for _, test := range tests {
+		ref, err := ParseReference(test.image)
+		assert.Nil(t, err, test.image)
+		assert.Equal(t, test.ref, ref)
+		assert.Equal(t, test.name, ref.Name())
+		assert.Equal(t, strings.TrimPrefix(test.image, "docker.io/"), ref.String())
+	}
+
+	_, err := ParseReference("Ceph/Daemon")
+	assert.NotNil(t, err)
+}
+
+func TestLoadCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cn-docker-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+
+	// No configuration file means anonymous
+	credentials, err := LoadCredentials(path, "quay.io")
+	assert.Nil(t, err)
+	assert.Nil(t, credentials)
+
+	auth := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	config := `{"auths":{"https://index.docker.io/v1/":{"auth":"` + auth("hub:pass") + `"},"quay.io":{"auth":"` + auth("quay:pa:ss") + `"}}}`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(config), 0600))
+
+	credentials, err = LoadCredentials(path, "docker.io")
+	assert.Nil(t, err)
+	assert.Equal(t, &Credentials{Username: "hub", Password: "pass"}, credentials)
+	credentials, err = LoadCredentials(path, "quay.io")
+	assert.Nil(t, err)
+	assert.Equal(t, &Credentials{Username: "quay", Password: "pa:ss"}, credentials)
+	credentials, err = LoadCredentials(path, "localhost:5000")
+	assert.Nil(t, err)
+	assert.Nil(t, credentials)
+}
+
+func TestNextPage(t *testing.T) {
+	current, _ := url.Parse("https://quay.io/v2/ceph/daemon/tags/list?n=100")
+	next, err := nextPage(current, `</v2/ceph/daemon/tags/list?last=v3&n=100>; rel="next"`)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://quay.io/v2/ceph/daemon/tags/list?last=v3&n=100", next)
+
+	next, err = nextPage(current, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", next)
+}